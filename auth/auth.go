@@ -0,0 +1,53 @@
+// Package auth defines the credential store used by authenticator side of PPP authentication protocols
+package auth
+
+import (
+	"sync"
+)
+
+// CredentialStore is the interface an authenticator uses to look up peer's credential
+type CredentialStore interface {
+	// GetPassword returns the password of username, ok is false if username is unknown
+	GetPassword(username string) (passwd string, ok bool)
+}
+
+// StaticStore is a CredentialStore implementation that keeps credentials in memory,
+// use NewStaticStore to create instance
+type StaticStore struct {
+	creds map[string]string
+	mux   *sync.RWMutex
+}
+
+// NewStaticStore returns a new StaticStore with initial credentials in creds, key is username, value is password
+func NewStaticStore(creds map[string]string) *StaticStore {
+	r := &StaticStore{
+		creds: make(map[string]string),
+		mux:   new(sync.RWMutex),
+	}
+	for u, p := range creds {
+		r.creds[u] = p
+	}
+	return r
+}
+
+// Add adds or replaces the credential of username
+func (s *StaticStore) Add(username, passwd string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.creds[username] = passwd
+}
+
+// Del removes the credential of username
+func (s *StaticStore) Del(username string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.creds, username)
+}
+
+// GetPassword implements CredentialStore interface
+func (s *StaticStore) GetPassword(username string) (string, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	p, ok := s.creds[username]
+	return p, ok
+}
@@ -0,0 +1,173 @@
+package chap
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/hujun-open/zouppp/auth"
+	"github.com/hujun-open/zouppp/lcp"
+
+	"go.uber.org/zap"
+)
+
+// Authenticator is the authenticator side of CHAP, it issues challenge and validates peer's response against a CredentialStore
+type Authenticator struct {
+	name     string
+	store    auth.CredentialStore
+	sendChan chan []byte
+	recvChan chan []byte
+	logger   *zap.Logger
+	timeout  time.Duration
+	retry    int
+	reqID    uint8
+	alg      lcp.CHAPAuthAlg
+}
+
+// AuthenticatorModifier is a function to provide custom configuration when creating new Authenticator instances
+type AuthenticatorModifier func(ca *Authenticator)
+
+// WithAuthenticatorAlg specifies the CHAP algorithm, lcp.AlgCHAPwithMD5 or lcp.AlgMSCHAP2; default is lcp.AlgCHAPwithMD5
+func WithAuthenticatorAlg(alg lcp.CHAPAuthAlg) AuthenticatorModifier {
+	return func(ca *Authenticator) {
+		ca.alg = alg
+	}
+}
+
+const (
+	// DefaultRetry is the default number of challenge transmission
+	DefaultRetry      = 3
+	challengeLen      = 16
+	defaultSuccessMsg = "Welcome"
+	defaultFailMsg    = "Authentication failed"
+)
+
+// NewAuthenticator creates a new CHAP Authenticator, name is included in challenge as authenticator's name,
+// store is used to validate peer's response; using pppProto as underlying PPP protocol;
+// optionally AuthenticatorModifier could provide custom configurations;
+func NewAuthenticator(name string, store auth.CredentialStore, pppProto *lcp.PPP, options ...AuthenticatorModifier) *Authenticator {
+	r := new(Authenticator)
+	r.name = name
+	r.store = store
+	r.sendChan, r.recvChan = pppProto.Register(lcp.ProtoCHAP)
+	r.logger = pppProto.GetLogger().Named("CHAP")
+	r.timeout = DefaultTimeout
+	r.retry = DefaultRetry
+	r.alg = lcp.AlgCHAPwithMD5
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+func (ca *Authenticator) send(pkt *Pkt) error {
+	b, err := pkt.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize CHAP %v,%w", pkt.Code, err)
+	}
+	t := time.NewTimer(ca.timeout)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return fmt.Errorf("send timeout")
+	case ca.sendChan <- lcp.NewPPPPkt(b, lcp.ProtoCHAP).Serialize():
+	}
+	ca.logger.Sugar().Debugf("send CHAP %v:\n%v", pkt.Code, pkt)
+	return nil
+}
+
+// getResponse sends challenge and waits for the response with same ID, retransmit challenge upon timeout
+func (ca *Authenticator) getResponse(challenge *Pkt) (*Pkt, error) {
+	for i := 0; i < ca.retry; i++ {
+		if err := ca.send(challenge); err != nil {
+			return nil, err
+		}
+		t := time.NewTimer(ca.timeout)
+	L1:
+		for {
+			select {
+			case b := <-ca.recvChan:
+				pkt := new(Pkt)
+				if err := pkt.Parse(b); err != nil {
+					ca.logger.Sugar().Warnf("got an invalid CHAP pkt,%v", err)
+					continue L1
+				}
+				if pkt.Code != CodeResponse || pkt.ID != challenge.ID {
+					continue L1
+				}
+				t.Stop()
+				return pkt, nil
+			case <-t.C:
+				break L1
+			}
+		}
+	}
+	return nil, fmt.Errorf("CHAP authentication failed, timeout")
+}
+
+// AuthPeer challenges the peer, validates the MD5 or MS-CHAPv2 response and sends Success/Failure,
+// returns peer's name if authentication succeeds
+func (ca *Authenticator) AuthPeer() (string, error) {
+	challenge := new(Pkt)
+	challenge.Code = CodeChallenge
+	ca.reqID++
+	challenge.ID = ca.reqID
+	challenge.Value = make([]byte, challengeLen)
+	if _, err := rand.Read(challenge.Value); err != nil {
+		return "", fmt.Errorf("failed to generate challenge,%w", err)
+	}
+	challenge.Name = []byte(ca.name)
+	resp, err := ca.getResponse(challenge)
+	if err != nil {
+		return "", err
+	}
+	ca.logger.Sugar().Debugf("got CHAP response:\n%v", resp)
+	peerName := string(resp.Name)
+	final := new(Pkt)
+	final.ID = resp.ID
+	passwd, ok := ca.store.GetPassword(peerName)
+	successMsg := defaultSuccessMsg
+	failMsg := defaultFailMsg
+	switch ca.alg {
+	case lcp.AlgMSCHAP2:
+		failMsg = fmt.Sprintf("E=691 R=0 C=%X V=3 M=%v", challenge.Value, defaultFailMsg)
+		mresp := new(msCHAPv2Response)
+		if err = mresp.parse(resp.Value); err != nil {
+			ok = false
+		}
+		if ok {
+			var expected []byte
+			expected, err = msCHAPv2NTResponse(challenge.Value, mresp.PeerChallenge, peerName, passwd)
+			if err != nil {
+				return "", fmt.Errorf("failed to generate NT-Response,%w", err)
+			}
+			ok = subtle.ConstantTimeCompare(expected, mresp.NTResponse) == 1
+			successMsg = msCHAPv2AuthenticatorResponse(passwd, mresp.NTResponse, mresp.PeerChallenge, challenge.Value, peerName) +
+				" M=" + defaultSuccessMsg
+		}
+	default:
+		if ok {
+			h := md5.New()
+			h.Write([]byte{challenge.ID})
+			h.Write([]byte(passwd))
+			h.Write(challenge.Value)
+			ok = subtle.ConstantTimeCompare(h.Sum(nil), resp.Value) == 1
+		}
+	}
+	if !ok {
+		final.Code = CodeFailure
+		final.Msg = []byte(failMsg)
+		if err = ca.send(final); err != nil {
+			return "", fmt.Errorf("failed to send CHAP failure,%w", err)
+		}
+		return "", fmt.Errorf("auth failed for %v", peerName)
+	}
+	final.Code = CodeSuccess
+	final.Msg = []byte(successMsg)
+	if err = ca.send(final); err != nil {
+		return "", fmt.Errorf("failed to send CHAP success,%w", err)
+	}
+	return peerName, nil
+}
@@ -1,8 +1,9 @@
-// Package chap implments CHAPwithMD5 as specified in rfc1994
+// Package chap implments CHAPwithMD5 as specified in rfc1994, and MS-CHAPv2 as specified in rfc2759
 package chap
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"fmt"
 	"time"
 
@@ -19,19 +20,35 @@ type CHAP struct {
 	recvChan chan []byte
 	logger   *zap.Logger
 	timeout  time.Duration
+	alg      lcp.CHAPAuthAlg
 }
 
 // DefaultTimeout is the default timeout for CHAP
 const DefaultTimeout = 10 * time.Second
 
-// NewCHAP creates a new CHAP instance with specified uname,passwd; using pppProto as underlying PPP protocol
-func NewCHAP(uname, passwd string, pppProto *lcp.PPP) *CHAP {
+// Modifier is a function to provide custom configuration when creating new CHAP instances
+type Modifier func(chap *CHAP)
+
+// WithAlg specifies the CHAP algorithm, lcp.AlgCHAPwithMD5 or lcp.AlgMSCHAP2; default is lcp.AlgCHAPwithMD5
+func WithAlg(alg lcp.CHAPAuthAlg) Modifier {
+	return func(chap *CHAP) {
+		chap.alg = alg
+	}
+}
+
+// NewCHAP creates a new CHAP instance with specified uname,passwd; using pppProto as underlying PPP protocol;
+// optionally Modifer could provide custom configurations;
+func NewCHAP(uname, passwd string, pppProto *lcp.PPP, options ...Modifier) *CHAP {
 	r := new(CHAP)
 	r.peerID = uname
 	r.passwd = passwd
 	r.sendChan, r.recvChan = pppProto.Register(lcp.ProtoCHAP)
 	r.logger = pppProto.GetLogger()
 	r.timeout = DefaultTimeout
+	r.alg = lcp.AlgCHAPwithMD5
+	for _, option := range options {
+		option(r)
+	}
 	return r
 }
 
@@ -90,19 +107,34 @@ func (chap *CHAP) AUTHSelf() error {
 	resp := new(Pkt)
 	resp.Code = CodeResponse
 	resp.ID = challenge.ID
-
-	h := md5.New()
-	toBuf := append([]byte{challenge.ID}, []byte(chap.passwd)...)
-	toBuf = append(toBuf, challenge.Value...)
-	chap.logger.Sugar().Debugf("hashing id %x, passwd %s,challege %x", challenge.ID, chap.passwd, challenge.Value)
-	h.Write(toBuf)
-	resp.Value = h.Sum(nil)
-	chap.logger.Sugar().Debugf("hash value is %x", resp.Value)
-	//h.Write(append([]byte{challenge.ID}, []byte(chap.Passwd)...))
-	//h.Write(challenge.Value)
-	//resp.Value = h.Sum(nil)
-
 	resp.Name = []byte(chap.peerID)
+	// expected authenticator response in success pkt, only for MS-CHAPv2
+	var expectedAuthResp string
+	switch chap.alg {
+	case lcp.AlgMSCHAP2:
+		if len(challenge.Value) != msCHAPv2ChallengeLen {
+			return fmt.Errorf("invalid MS-CHAPv2 challenge length %d", len(challenge.Value))
+		}
+		mresp := new(msCHAPv2Response)
+		mresp.PeerChallenge = make([]byte, msCHAPv2ChallengeLen)
+		if _, err = rand.Read(mresp.PeerChallenge); err != nil {
+			return fmt.Errorf("failed to generate peer challenge,%w", err)
+		}
+		mresp.NTResponse, err = msCHAPv2NTResponse(challenge.Value, mresp.PeerChallenge, chap.peerID, chap.passwd)
+		if err != nil {
+			return fmt.Errorf("failed to generate NT-Response,%w", err)
+		}
+		resp.Value = mresp.serialize()
+		expectedAuthResp = msCHAPv2AuthenticatorResponse(chap.passwd, mresp.NTResponse, mresp.PeerChallenge, challenge.Value, chap.peerID)
+	default:
+		h := md5.New()
+		toBuf := append([]byte{challenge.ID}, []byte(chap.passwd)...)
+		toBuf = append(toBuf, challenge.Value...)
+		chap.logger.Sugar().Debugf("hashing id %x, passwd %s,challege %x", challenge.ID, chap.passwd, challenge.Value)
+		h.Write(toBuf)
+		resp.Value = h.Sum(nil)
+		chap.logger.Sugar().Debugf("hash value is %x", resp.Value)
+	}
 	b, err := resp.Serialize()
 	if err != nil {
 		return fmt.Errorf("failed to serialize CHAP response,%w", err)
@@ -120,5 +152,8 @@ func (chap *CHAP) AUTHSelf() error {
 	if finalresp.Code == CodeFailure {
 		return fmt.Errorf("gateway returned failed")
 	}
+	if chap.alg == lcp.AlgMSCHAP2 {
+		return verifyMSCHAPv2Success(finalresp.Msg, expectedAuthResp)
+	}
 	return nil
 }
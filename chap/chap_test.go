@@ -0,0 +1,84 @@
+// chap_test
+package chap
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/hujun-open/zouppp/auth"
+	"github.com/hujun-open/zouppp/lcp"
+	"github.com/hujun-open/zouppp/loopback"
+	"go.uber.org/zap"
+)
+
+func TestCHAP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	store := auth.NewStaticStore(map[string]string{"user1": "passwd1"})
+	testList := []struct {
+		passwd     string
+		alg        lcp.CHAPAuthAlg
+		shouldFail bool
+	}{
+		{passwd: "passwd1", alg: lcp.AlgCHAPwithMD5},
+		{passwd: "wrongpasswd", alg: lcp.AlgCHAPwithMD5, shouldFail: true},
+		{passwd: "passwd1", alg: lcp.AlgMSCHAP2},
+		{passwd: "wrongpasswd", alg: lcp.AlgMSCHAP2, shouldFail: true},
+	}
+	for i, c := range testList {
+		clntConn, srvConn := loopback.NewPacketConnPair()
+		clnt := NewCHAP("user1", c.passwd, lcp.NewPPP(ctx, clntConn, logger.Named("clnt")), WithAlg(c.alg))
+		srv := NewAuthenticator("bras", store, lcp.NewPPP(ctx, srvConn, logger.Named("srv")), WithAuthenticatorAlg(c.alg))
+		clntErrCh := make(chan error, 1)
+		go func() {
+			clntErrCh <- clnt.AUTHSelf()
+		}()
+		peer, srvErr := srv.AuthPeer()
+		clntErr := <-clntErrCh
+		if c.shouldFail {
+			if srvErr == nil || clntErr == nil {
+				t.Fatalf("case %d should fail but succeed", i)
+			}
+			continue
+		}
+		if srvErr != nil || clntErr != nil {
+			t.Fatalf("case %d failed, authenticator: %v, client: %v", i, srvErr, clntErr)
+		}
+		if peer != "user1" {
+			t.Fatalf("case %d authenticated unexpected peer %v", i, peer)
+		}
+	}
+}
+
+// test vectors from RFC2759 section 9.2
+func TestMSCHAPv2Vectors(t *testing.T) {
+	mustDecode := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	authChallenge := mustDecode("5B5D7C7D7B3F2F3E3C2C602132262628")
+	peerChallenge := mustDecode("21402324255E262A28295F2B3A337C7E")
+	if h := msCHAPv2ChallengeHash(peerChallenge, authChallenge, "User"); !bytes.Equal(h, mustDecode("D02E4386BCE91226")) {
+		t.Fatalf("wrong challenge hash %X", h)
+	}
+	if h := ntPasswordHash("clientPass"); !bytes.Equal(h, mustDecode("44EBBA8D5312B8D611474411F56989AE")) {
+		t.Fatalf("wrong password hash %X", h)
+	}
+	ntresp, err := msCHAPv2NTResponse(authChallenge, peerChallenge, "User", "clientPass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ntresp, mustDecode("82309ECD8D708B5EA08FAA3981CD83544233114A3D85D6DF")) {
+		t.Fatalf("wrong NT-Response %X", ntresp)
+	}
+	authresp := msCHAPv2AuthenticatorResponse("clientPass", ntresp, peerChallenge, authChallenge, "User")
+	if authresp != "S=407A5589115FD0D6209F510FE9C04566932CDA56" {
+		t.Fatalf("wrong authenticator response %v", authresp)
+	}
+}
@@ -74,9 +74,10 @@ func (cp *Pkt) Serialize() ([]byte, error) {
 		buf = append(header, byte(len(cp.Value)))
 		buf = append(buf, cp.Value...)
 		buf = append(buf, cp.Name...)
-
+	default:
+		buf = append(header, cp.Msg...)
 	}
-	totalen := 5 + len(cp.Name) + len(cp.Value)
+	totalen := len(buf)
 	if totalen > 65535 {
 		return nil, fmt.Errorf("result pkt too big")
 	}
@@ -0,0 +1,143 @@
+package chap
+
+import (
+	"bytes"
+	"crypto/des"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// MS-CHAPv2 as specified in RFC2759
+
+const (
+	msCHAPv2ChallengeLen = 16
+	// length of response value: peer-challenge(16) + reserved(8) + NT-Response(24) + flags(1)
+	msCHAPv2ResponseLen = 49
+	msCHAPv2NTRespLen   = 24
+)
+
+var (
+	msCHAPv2Magic1 = []byte("Magic server to client signing constant")
+	msCHAPv2Magic2 = []byte("Pad to make it do more than one iteration")
+)
+
+// msCHAPv2UserName returns the username used in challenge hash, which excludes any prepended domain name
+func msCHAPv2UserName(uname string) string {
+	if i := strings.LastIndex(uname, `\`); i >= 0 {
+		return uname[i+1:]
+	}
+	return uname
+}
+
+func msCHAPv2ChallengeHash(peerChallenge, authChallenge []byte, uname string) []byte {
+	h := sha1.New()
+	h.Write(peerChallenge)
+	h.Write(authChallenge)
+	h.Write([]byte(msCHAPv2UserName(uname)))
+	return h.Sum(nil)[:8]
+}
+
+func ntPasswordHash(passwd string) []byte {
+	u := utf16.Encode([]rune(passwd))
+	buf := make([]byte, 2*len(u))
+	for i, c := range u {
+		buf[2*i] = byte(c)
+		buf[2*i+1] = byte(c >> 8)
+	}
+	h := md4.New()
+	h.Write(buf)
+	return h.Sum(nil)
+}
+
+// desKey expands 7 bytes into a 8 bytes DES key
+func desKey(b []byte) []byte {
+	k := []byte{
+		b[0] >> 1,
+		(b[0]&0x01)<<6 | b[1]>>2,
+		(b[1]&0x03)<<5 | b[2]>>3,
+		(b[2]&0x07)<<4 | b[3]>>4,
+		(b[3]&0x0f)<<3 | b[4]>>5,
+		(b[4]&0x1f)<<2 | b[5]>>6,
+		(b[5]&0x3f)<<1 | b[6]>>7,
+		b[6] & 0x7f,
+	}
+	for i := range k {
+		k[i] <<= 1
+	}
+	return k
+}
+
+func msCHAPChallengeResponse(challenge, passwdHash []byte) ([]byte, error) {
+	zpasswdHash := make([]byte, 21)
+	copy(zpasswdHash, passwdHash)
+	r := make([]byte, msCHAPv2NTRespLen)
+	for i := 0; i < 3; i++ {
+		c, err := des.NewCipher(desKey(zpasswdHash[i*7 : i*7+7]))
+		if err != nil {
+			return nil, err
+		}
+		c.Encrypt(r[i*8:i*8+8], challenge)
+	}
+	return r, nil
+}
+
+// msCHAPv2NTResponse generates the NT-Response
+func msCHAPv2NTResponse(authChallenge, peerChallenge []byte, uname, passwd string) ([]byte, error) {
+	return msCHAPChallengeResponse(msCHAPv2ChallengeHash(peerChallenge, authChallenge, uname), ntPasswordHash(passwd))
+}
+
+// msCHAPv2AuthenticatorResponse generates the authenticator response, in the format of "S=<auth_string>"
+func msCHAPv2AuthenticatorResponse(passwd string, ntResponse, peerChallenge, authChallenge []byte, uname string) string {
+	h := md4.New()
+	h.Write(ntPasswordHash(passwd))
+	passwdHashHash := h.Sum(nil)
+	s := sha1.New()
+	s.Write(passwdHashHash)
+	s.Write(ntResponse)
+	s.Write(msCHAPv2Magic1)
+	digest := s.Sum(nil)
+	s = sha1.New()
+	s.Write(digest)
+	s.Write(msCHAPv2ChallengeHash(peerChallenge, authChallenge, uname))
+	s.Write(msCHAPv2Magic2)
+	return "S=" + strings.ToUpper(hex.EncodeToString(s.Sum(nil)))
+}
+
+// msCHAPv2Response is the value field of MS-CHAPv2 response pkt
+type msCHAPv2Response struct {
+	PeerChallenge []byte
+	NTResponse    []byte
+	Flags         byte
+}
+
+func (resp *msCHAPv2Response) parse(buf []byte) error {
+	if len(buf) != msCHAPv2ResponseLen {
+		return fmt.Errorf("invalid MS-CHAPv2 response value length %d", len(buf))
+	}
+	resp.PeerChallenge = buf[:16]
+	resp.NTResponse = buf[24:48]
+	resp.Flags = buf[48]
+	return nil
+}
+
+func (resp *msCHAPv2Response) serialize() []byte {
+	buf := make([]byte, msCHAPv2ResponseLen)
+	copy(buf[:16], resp.PeerChallenge)
+	copy(buf[24:48], resp.NTResponse)
+	buf[48] = resp.Flags
+	return buf
+}
+
+// verifyMSCHAPv2Success checks the authenticator response in the message of success pkt
+func verifyMSCHAPv2Success(msg []byte, expected string) error {
+	// message is in format of "S=<auth_string> M=<message>"
+	if len(msg) < len(expected) || !bytes.Equal(bytes.ToUpper(msg[:len(expected)]), []byte(expected)) {
+		return fmt.Errorf("invalid MS-CHAPv2 authenticator response %q", string(msg))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hujun-open/zouppp/pppoe"
+)
+
+// accessLineSpec is the parsed spec of a numeric BBF access-line sub-tag, see Setup.AccessLine
+type accessLineSpec struct {
+	num pppoe.BBFSubTagNum
+	// val is the value could contain VarName, used if isRange is false
+	val      string
+	isRange  bool
+	min, max uint32
+}
+
+// parseAccessLineSpec parses s in format of <sub-tag>=<value>, value is either a number could contain VarName,
+// or a range <min>-<max>
+func parseAccessLineSpec(s string) (*accessLineSpec, error) {
+	name, val, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid access-line sub-tag %v, should be <sub-tag>=<value>", s)
+	}
+	r := new(accessLineSpec)
+	if err := r.num.UnmarshalText([]byte(name)); err != nil {
+		return nil, err
+	}
+	if !r.num.IsUint32() {
+		return nil, fmt.Errorf("%v is not a numeric access-line sub-tag", r.num)
+	}
+	if minstr, maxstr, isRange := strings.Cut(val, "-"); isRange {
+		minv, err := strconv.ParseUint(minstr, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min value of %v, %w", r.num, err)
+		}
+		maxv, err := strconv.ParseUint(maxstr, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max value of %v, %w", r.num, err)
+		}
+		if maxv < minv {
+			return nil, fmt.Errorf("max value of %v is less than min value", r.num)
+		}
+		r.isRange = true
+		r.min, r.max = uint32(minv), uint32(maxv)
+		return r, nil
+	}
+	r.val = val
+	if _, err := r.value(0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// value returns the sub-tag value of client id;
+// for a range, values are assigned in turn from min to max
+func (spec *accessLineSpec) value(id int) (uint32, error) {
+	if spec.isRange {
+		return spec.min + uint32(uint64(id)%(uint64(spec.max-spec.min)+1)), nil
+	}
+	v, err := strconv.ParseUint(genStrFunc(spec.val, id), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value of %v, %w", spec.num, err)
+	}
+	return uint32(v), nil
+}
+
+// genAccessLine returns access-line sub-tag values of client id according to specs
+func genAccessLine(specs []*accessLineSpec, id int) (map[pppoe.BBFSubTagNum]uint32, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	r := make(map[pppoe.BBFSubTagNum]uint32)
+	for _, spec := range specs {
+		v, err := spec.value(id)
+		if err != nil {
+			return nil, err
+		}
+		r[spec.num] = v
+	}
+	return r, nil
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/hujun-open/zouppp/pppoe"
+)
+
+func TestAccessLine(t *testing.T) {
+	specs := []*accessLineSpec{}
+	for _, s := range []string{"ActualDataRateUpstream=1000-1002", "0x82=5@ID", "DSLType=5"} {
+		spec, err := parseAccessLineSpec(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		specs = append(specs, spec)
+	}
+	for id, expected := range []map[pppoe.BBFSubTagNum]uint32{
+		{pppoe.BBFSubTagActualDataRateUpstream: 1000, pppoe.BBFSubTagActualDataRateDownstream: 50, pppoe.BBFSubTagDSLType: 5},
+		{pppoe.BBFSubTagActualDataRateUpstream: 1001, pppoe.BBFSubTagActualDataRateDownstream: 51, pppoe.BBFSubTagDSLType: 5},
+		{pppoe.BBFSubTagActualDataRateUpstream: 1002, pppoe.BBFSubTagActualDataRateDownstream: 52, pppoe.BBFSubTagDSLType: 5},
+		{pppoe.BBFSubTagActualDataRateUpstream: 1000, pppoe.BBFSubTagActualDataRateDownstream: 53, pppoe.BBFSubTagDSLType: 5},
+	} {
+		attrs, err := genAccessLine(specs, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for num, v := range expected {
+			if attrs[num] != v {
+				t.Fatalf("client %d expect %v %d, got %d", id, num, v, attrs[num])
+			}
+		}
+	}
+	for _, s := range []string{"CircuitID=1", "ActualDataRateUpstream=2-1", "unknown=1", "DSLType"} {
+		if _, err := parseAccessLineSpec(s); err == nil {
+			t.Fatalf("expect error parsing %v", s)
+		}
+	}
+}
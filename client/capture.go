@@ -0,0 +1,141 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+	"github.com/hujun-open/zouppp/lcp"
+	"github.com/hujun-open/zouppp/pcapng"
+	"github.com/hujun-open/zouppp/pppoe"
+	"go.uber.org/zap"
+)
+
+const (
+	// captureAppName is the application name in the section header block of capture file
+	captureAppName   = "zouppp"
+	ipv6HeaderLen    = 40
+	protoUDP         = 17
+	dhcpv6ClientPort = 546
+	dhcpv6ServerPort = 547
+)
+
+// sessionCapture writes PPPoE discovery and PPP control pkts of a ZouPPP session into a pcapng file
+type sessionCapture struct {
+	w      *pcapng.Writer
+	ifid   uint32
+	mac    net.HardwareAddr
+	vlans  etherconn.VLANs
+	logger *zap.Logger
+}
+
+// newSessionCapture returns a sessionCapture writes to cfg.Capture, an interface description block identifies the session is added;
+// return nil if cfg.Capture is empty
+func newSessionCapture(cfg *Config, logger *zap.Logger) (*sessionCapture, error) {
+	if cfg.Capture == "" {
+		return nil, nil
+	}
+	w, err := cfg.setup.captureWriter(cfg.Capture)
+	if err != nil {
+		return nil, err
+	}
+	r := &sessionCapture{
+		w:      w,
+		mac:    cfg.Mac,
+		vlans:  cfg.VLANs,
+		logger: logger,
+	}
+	vlanstr := "none"
+	if len(cfg.VLANs) > 0 {
+		vlanstr = fmt.Sprint(cfg.VLANs.IDs())
+	}
+	r.ifid, err = w.AddInterface(cfg.Mac.String()+cfg.VLANs.String(),
+		fmt.Sprintf("PPPoE client MAC %v VLAN %v", cfg.Mac, vlanstr),
+		fmt.Sprintf("username %v", cfg.UserName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add interface to capture file %v, %w", cfg.Capture, err)
+	}
+	return r, nil
+}
+
+// handle implements pppoe.CaptureHandler, only PPPoE discovery and PPP control pkts are written
+func (c *sessionCapture) handle(sent bool, etype uint16, peer net.HardwareAddr, pkt []byte) {
+	if len(pkt) < 6 {
+		return
+	}
+	if etype == pppoe.EtherTypePPPoESession && !isControlPkt(pkt[6:]) {
+		return
+	}
+	dst, src := c.mac, peer
+	dir := "rcvd"
+	if sent {
+		dst, src = peer, c.mac
+		dir = "sent"
+	}
+	frame := make([]byte, 0, 14+4*len(c.vlans)+len(pkt))
+	frame = append(frame, dst...)
+	frame = append(frame, src...)
+	for _, vlan := range c.vlans {
+		frame = binary.BigEndian.AppendUint16(frame, vlan.EtherType)
+		frame = binary.BigEndian.AppendUint16(frame, vlan.ID)
+	}
+	frame = binary.BigEndian.AppendUint16(frame, etype)
+	frame = append(frame, pkt...)
+	comment := fmt.Sprintf("%v %v session-id 0x%04x", dir, pppoe.Code(pkt[1]), binary.BigEndian.Uint16(pkt[2:4]))
+	if err := c.w.WritePacket(c.ifid, time.Now(), frame, comment); err != nil {
+		c.logger.Sugar().Warnf("failed to write capture, %v", err)
+	}
+}
+
+// isControlPkt returns true if PPP pkt buf is a control protocol pkt, or a DHCPv6 pkt over IPv6
+func isControlPkt(buf []byte) bool {
+	pkt := new(lcp.PPPPkt)
+	if err := pkt.Parse(buf); err != nil {
+		return false
+	}
+	if pkt.Proto >= 0x8000 {
+		return true
+	}
+	if pkt.Proto != lcp.ProtoIPv6 {
+		return false
+	}
+	ip := pkt.Payload
+	if len(ip) < ipv6HeaderLen+4 || ip[6] != protoUDP {
+		return false
+	}
+	for _, port := range []uint16{binary.BigEndian.Uint16(ip[ipv6HeaderLen : ipv6HeaderLen+2]), binary.BigEndian.Uint16(ip[ipv6HeaderLen+2 : ipv6HeaderLen+4])} {
+		if port == dhcpv6ClientPort || port == dhcpv6ServerPort {
+			return true
+		}
+	}
+	return false
+}
+
+// captureWriter returns the pcapng writer of file path, the file is created upon first call, sessions with same path share the writer
+func (setup *Setup) captureWriter(path string) (*pcapng.Writer, error) {
+	setup.captureLock.Lock()
+	defer setup.captureLock.Unlock()
+	if w, ok := setup.captures[path]; ok {
+		return w, nil
+	}
+	w, err := pcapng.Create(path, captureAppName)
+	if err != nil {
+		return nil, err
+	}
+	setup.captures[path] = w
+	return w, nil
+}
+
+// CloseCaptures closes all capture files
+func (setup *Setup) CloseCaptures() {
+	setup.captureLock.Lock()
+	defer setup.captureLock.Unlock()
+	for path, w := range setup.captures {
+		if err := w.Close(); err != nil {
+			setup.logger.Sugar().Errorf("failed to close capture file %v, %v", path, err)
+		}
+		delete(setup.captures, path)
+	}
+}
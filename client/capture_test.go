@@ -0,0 +1,72 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hujun-open/etherconn"
+	"github.com/hujun-open/zouppp/pppoe"
+	"go.uber.org/zap"
+)
+
+func TestCapture(t *testing.T) {
+	setup := DefaultSetup()
+	setup.logger = zap.NewNop()
+	dir := t.TempDir()
+	setup.Capture = filepath.Join(dir, "s@ID.pcapng")
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	acmac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	cfg := &Config{
+		setup:   setup,
+		Mac:     mac,
+		VLANs:   etherconn.VLANs{{ID: 100, EtherType: 0x8100}},
+		Capture: genStrFunc(setup.Capture, 1),
+	}
+	capt, err := newSessionCapture(cfg, setup.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionPkt := func(payload ...byte) []byte {
+		return append([]byte{0x11, 0, 0, 1, 0, byte(len(payload))}, payload...)
+	}
+	dhcpv6 := make([]byte, ipv6HeaderLen+8)
+	dhcpv6[6] = protoUDP
+	binary.BigEndian.PutUint16(dhcpv6[ipv6HeaderLen:], dhcpv6ClientPort)
+	binary.BigEndian.PutUint16(dhcpv6[ipv6HeaderLen+2:], dhcpv6ServerPort)
+	padi := []byte{0x11, byte(pppoe.CodePADI), 0, 0, 0, 0}
+	capt.handle(true, pppoe.EtherTypePPPoEDiscovery, etherconn.BroadCastMAC, padi)
+	// LCP
+	capt.handle(false, pppoe.EtherTypePPPoESession, acmac, sessionPkt(0xc0, 0x21, 1, 1, 0, 4))
+	// IPv4 data, not captured
+	capt.handle(true, pppoe.EtherTypePPPoESession, acmac, sessionPkt(0, 0x21, 0x45))
+	// DHCPv6
+	capt.handle(true, pppoe.EtherTypePPPoESession, acmac, sessionPkt(append([]byte{0, 0x57}, dhcpv6...)...))
+	setup.CloseCaptures()
+	buf, err := os.ReadFile(filepath.Join(dir, "s1.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := [][]byte{}
+	for len(buf) >= 12 {
+		l := binary.LittleEndian.Uint32(buf[4:8])
+		if binary.LittleEndian.Uint32(buf[0:4]) == 6 {
+			caplen := binary.LittleEndian.Uint32(buf[20:24])
+			frames = append(frames, buf[28:28+caplen])
+		}
+		buf = buf[l:]
+	}
+	if len(frames) != 3 {
+		t.Fatalf("expect 3 captured pkts, got %d", len(frames))
+	}
+	expected := append(append(append([]byte{}, etherconn.BroadCastMAC...), mac...), 0x81, 0, 0, 100, 0x88, 0x63)
+	if !bytes.Equal(frames[0], append(expected, padi...)) {
+		t.Fatalf("invalid captured PADI frame %x", frames[0])
+	}
+	if !bytes.Equal(frames[1][:6], mac) || !bytes.Equal(frames[1][6:12], acmac) {
+		t.Fatalf("invalid MAC of rcvd pkt")
+	}
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultHoldTime is the default amount of time each session holds in churn mode
+	DefaultHoldTime = time.Minute
+	// DefaultCPS is the default re-dial rate in churn mode
+	DefaultCPS = 10
+	// churnTeardownTimeout is the amount of time to wait for LCP term-ack before terminating the session anyway
+	churnTeardownTimeout = 3 * time.Second
+)
+
+// ChurnTeardown is the method to tear down a session in churn mode
+type ChurnTeardown uint8
+
+const (
+	// ChurnTeardownPADT tears down the session by sending PADT
+	ChurnTeardownPADT ChurnTeardown = iota
+	// ChurnTeardownLCP tears down the session by LCP terminate-request, followed by PADT
+	ChurnTeardownLCP
+)
+
+func (ct ChurnTeardown) MarshalText() (text []byte, err error) {
+	switch ct {
+	case ChurnTeardownPADT:
+		return []byte("PADT"), nil
+	case ChurnTeardownLCP:
+		return []byte("LCP"), nil
+	}
+	return nil, fmt.Errorf("unknown churn teardown method %d", ct)
+}
+
+func (ct *ChurnTeardown) UnmarshalText(text []byte) error {
+	input := strings.TrimSpace(strings.ToLower(string(text)))
+	switch input {
+	case "padt":
+		*ct = ChurnTeardownPADT
+	case "lcp":
+		*ct = ChurnTeardownLCP
+	default:
+		return fmt.Errorf("unknown churn teardown method, %s", string(text))
+	}
+	return nil
+}
+
+// ChurnStats is the stats of churn mode
+type ChurnStats struct {
+	// Redials is the number of re-dials
+	Redials atomic.Uint64
+	// Succeeded is the number of dials (including the initial dials) reached open state
+	Succeeded atomic.Uint64
+	// Failed is the number of dials (including the initial dials) failed
+	Failed atomic.Uint64
+	// Teardowns is the number of sessions torn down after hold time
+	Teardowns atomic.Uint64
+}
+
+func (cs *ChurnStats) String() string {
+	return fmt.Sprintf("churn: re-dials:%d succeeded:%d failed:%d torn-down:%d",
+		cs.Redials.Load(), cs.Succeeded.Load(), cs.Failed.Load(), cs.Teardowns.Load())
+}
+
+// callPacer paces calls to a max rate
+type callPacer struct {
+	interval time.Duration
+	lock     *sync.Mutex
+	next     time.Time
+}
+
+// newCallPacer returns a callPacer allows cps calls per second, no limit if cps is 0
+func newCallPacer(cps float64) *callPacer {
+	r := &callPacer{
+		lock: new(sync.Mutex),
+	}
+	if cps > 0 {
+		r.interval = time.Duration(float64(time.Second) / cps)
+	}
+	return r
+}
+
+// reserve reserves a call slot, returns the amount of time to wait before the call
+func (cp *callPacer) reserve() time.Duration {
+	if cp.interval == 0 {
+		return 0
+	}
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	now := time.Now()
+	if cp.next.Before(now) {
+		cp.next = now
+	}
+	d := cp.next.Sub(now)
+	cp.next = cp.next.Add(cp.interval)
+	return d
+}
+
+func (setup *Setup) churnEnabled() bool {
+	return setup.Churn
+}
+
+// holdTime returns the hold time of a session, randomly distributed between HoldTime and HoldTimeMax if HoldTimeMax is bigger
+func (setup *Setup) holdTime() time.Duration {
+	if setup.HoldTimeMax <= setup.HoldTime {
+		return setup.HoldTime
+	}
+	return setup.HoldTime + time.Duration(rand.Int63n(int64(setup.HoldTimeMax-setup.HoldTime)+1))
+}
+
+// ChurnStats returns the stats of churn mode, nil if churn mode is not enabled
+func (setup *Setup) ChurnStats() *ChurnStats {
+	return setup.churnStats
+}
+
+// churnOpened is called when reaching open state, it starts the hold timer
+func (zou *ZouPPP) churnOpened(ctx context.Context) {
+	if !zou.cfg.setup.churnEnabled() {
+		return
+	}
+	zou.cfg.setup.churnStats.Succeeded.Add(1)
+	go zou.hold(ctx)
+}
+
+// hold tears down the session after hold time
+func (zou *ZouPPP) hold(ctx context.Context) {
+	t := time.NewTimer(zou.cfg.setup.holdTime())
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-t.C:
+	}
+	if zou.stale(ctx) {
+		return
+	}
+	zou.cfg.setup.churnStats.Teardowns.Add(1)
+	zou.logger.Info("hold time expired, tearing down")
+	if zou.cfg.setup.ChurnTeardown != ChurnTeardownLCP {
+		// PADT is sent by scheduleChurn
+		zou.cancelMe()
+		return
+	}
+	// session terminates upon LCP finished event, see lcpEvtHandler
+	a := attemptOf(ctx)
+	a.lcpTerminating.Store(true)
+	a.lcpProto.Load().Close(ctx)
+	t.Reset(churnTeardownTimeout)
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+		if !zou.stale(ctx) {
+			zou.logger.Warn("no LCP term-ack received")
+			zou.cancelMe()
+		}
+	}
+}
+
+// scheduleChurn sends PADT and schedules next dial paced by CPS, wasOpen is true if the session went down from open state;
+// return false if churn mode is not enabled or no more dial will be done
+func (zou *ZouPPP) scheduleChurn(wasOpen bool) bool {
+	setup := zou.cfg.setup
+	if !setup.churnEnabled() {
+		return false
+	}
+	if atomic.LoadUint32(zou.closed) != 0 || zou.parentCtx == nil || zou.parentCtx.Err() != nil {
+		return false
+	}
+	if !wasOpen {
+		setup.churnStats.Failed.Add(1)
+	}
+	zou.current().pppoeProto.Close()
+	go zou.churnRedial(setup.churnPacer.reserve())
+	return true
+}
+
+// churnRedial waits for the delay then dial again
+func (zou *ZouPPP) churnRedial(delay time.Duration) {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-zou.parentCtx.Done():
+		zou.releaseSessionWG()
+		return
+	case <-zou.closeChan:
+		zou.releaseSessionWG()
+		return
+	}
+	if atomic.LoadUint32(zou.closed) != 0 {
+		zou.releaseSessionWG()
+		return
+	}
+	zou.cfg.setup.churnStats.Redials.Add(1)
+	zou.dial(zou.parentCtx)
+}
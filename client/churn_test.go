@@ -0,0 +1,41 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallPacer(t *testing.T) {
+	cp := newCallPacer(10)
+	if d := cp.reserve(); d != 0 {
+		t.Fatalf("expect no wait for 1st call, got %v", d)
+	}
+	for i := 1; i < 5; i++ {
+		d := cp.reserve()
+		expected := time.Duration(i) * 100 * time.Millisecond
+		if d > expected || d < expected-10*time.Millisecond {
+			t.Fatalf("call %d expect wait about %v, got %v", i, expected, d)
+		}
+	}
+	if d := newCallPacer(0).reserve(); d != 0 {
+		t.Fatalf("expect no wait without limit, got %v", d)
+	}
+}
+
+func TestHoldTime(t *testing.T) {
+	setup := DefaultSetup()
+	setup.HoldTime = time.Second
+	if d := setup.holdTime(); d != time.Second {
+		t.Fatalf("expect fixed hold time, got %v", d)
+	}
+	setup.HoldTimeMax = 2 * time.Second
+	for i := 0; i < 100; i++ {
+		if d := setup.holdTime(); d < setup.HoldTime || d > setup.HoldTimeMax {
+			t.Fatalf("hold time %v out of range", d)
+		}
+	}
+	var ct ChurnTeardown
+	if err := ct.UnmarshalText([]byte("lcp")); err != nil || ct != ChurnTeardownLCP {
+		t.Fatalf("failed to parse teardown method, %v", err)
+	}
+}
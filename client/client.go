@@ -3,9 +3,11 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"math/big"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -13,8 +15,10 @@ import (
 
 	"github.com/hujun-open/zouppp/chap"
 	"github.com/hujun-open/zouppp/datapath"
+	"github.com/hujun-open/zouppp/eap"
 	"github.com/hujun-open/zouppp/lcp"
 	"github.com/hujun-open/zouppp/pap"
+	"github.com/hujun-open/zouppp/pcapng"
 	"github.com/hujun-open/zouppp/pppoe"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 
@@ -25,7 +29,7 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-// VarName is the placeholder in PPPIfName/RID/CID/UserName/Password of Setup that will be replaced by client id
+// VarName is the placeholder in PPPIfName/RID/CID/AccessLine/ServiceName/ACName/UserName/Password/Capture of Setup that will be replaced by client id
 const VarName = "@ID"
 
 func genStrFunc(s string, id int) string {
@@ -68,26 +72,54 @@ func stateStr(s uint32) string {
 // ZouPPP represents a single PPPoE/PPP client session
 type ZouPPP struct {
 	cfg               *Config
-	pppoeProto        *pppoe.PPPoE
-	pppProto          *lcp.PPP
+	econn             *etherconn.EtherConn
+	pppoeOptions      []pppoe.Modifier
 	fastpath          *datapath.TUNIF
 	createFastPathMux *sync.Mutex
-	lcpProto          *lcp.LCP
-	ipcpProto         *lcp.LCP
-	ipv6cpProto       *lcp.LCP
 	logger            *zap.Logger
-	ncpWG             *mywg.MyWG
 	dialWG            *sync.WaitGroup
 	onceDoneDialWG    *sync.Once
-	onceSendResult    *sync.Once
 	sessionWG         *sync.WaitGroup
-	cancelFunc        context.CancelFunc
+	onceHoldSessionWG *sync.Once
+	sessionWGHeld     *uint32
 	state             *uint32
-	dialSucceed       bool
-	result            *DialResult
-	assignedV4Addr    net.IP
-	assignedIANAs     []net.IP
-	assignedIAPDs     []*net.IPNet
+	launchScheduler   *LaunchScheduler
+	onceLaunchDone    *sync.Once
+	// cur is the current dial attempt, guarded by attemptLock
+	cur         *attempt
+	attempts    int
+	attemptLock *sync.RWMutex
+	// following are for re-dial
+	parentCtx     context.Context
+	closed        *uint32
+	closeChan     chan struct{}
+	redialLock    *sync.Mutex
+	redialAttempt int
+	downTime      time.Time
+	redialHandler RedialHandler
+}
+
+// attempt is the state of one dial attempt, it is replaced as a whole upon re-dial,
+// so that routines of a previous attempt never touch the current one
+type attempt struct {
+	cancelFunc context.CancelFunc
+	pppoeProto *pppoe.PPPoE
+	pppProto   *lcp.PPP
+	// lcpProto, ipcpProto and ipv6cpProto are created during dialing, they could be read concurrently via ZouPPP methods
+	lcpProto       atomic.Pointer[lcp.LCP]
+	ipcpProto      atomic.Pointer[lcp.LCP]
+	ipv6cpProto    atomic.Pointer[lcp.LCP]
+	ncpWG          *mywg.MyWG
+	assignedV4Addr net.IP
+	assignedIANAs  []net.IP
+	assignedIAPDs  []*net.IPNet
+	// lcpTerminating is set when tearing down by LCP terminate-request in churn mode
+	lcpTerminating atomic.Bool
+	// result is the dialing result of the attempt, it is reported once
+	result         *DialResult
+	resultLock     *sync.Mutex
+	reported       bool
+	onceSendResult *sync.Once
 }
 
 // NewZouPPP creates a new ZouPPP instance, dialwg is done when dial finishes,
@@ -96,34 +128,40 @@ func NewZouPPP(econn *etherconn.EtherConn, cfg *Config,
 	options ...ZouPPPModifier) (zou *ZouPPP, err error) {
 	zou = new(ZouPPP)
 	zou.cfg = cfg
+	zou.econn = econn
 	zou.logger = cfg.setup.logger.Named(econn.LocalAddr().String())
-	taglist := []pppoe.Tag{pppoe.NewSvcTag("")}
-	if cfg.CID != "" || cfg.RID != "" {
-		taglist = append(taglist, pppoe.NewCircuitRemoteIDTag(cfg.CID, cfg.RID))
+	taglist := []pppoe.Tag{}
+	if cfg.CID != "" || cfg.RID != "" || len(cfg.AccessLine) > 0 {
+		taglist = append(taglist, pppoe.NewAccessLineTag(cfg.CID, cfg.RID, cfg.AccessLine))
+	}
+	zou.pppoeOptions = []pppoe.Modifier{
+		pppoe.WithTags(taglist),
+		pppoe.WithServiceName(cfg.ServiceName),
+		pppoe.WithRequiredACName(cfg.ACName),
+		pppoe.WithMaxPayload(cfg.setup.MaxPayload),
+		pppoe.WithCounters(cfg.setup.metrics.PPPoECounters()),
+		pppoe.WithPADOSelection(cfg.setup.PADOWindow, cfg.setup.padoSelector),
+		pppoe.WithPADIBackoff(cfg.setup.padiBackoff()),
+		pppoe.WithPADRBackoff(cfg.setup.padrBackoff()),
 	}
-	zou.pppoeProto = pppoe.NewPPPoE(econn,
-		zou.logger,
-		pppoe.WithTags(taglist))
+	capt, err := newSessionCapture(cfg, zou.logger)
 	if err != nil {
 		return nil, err
 	}
-	zou.ncpWG = mywg.NewMyWG()
-	// if zou.cfg.setup.IPv4 {
-	// 	zou.ncpWG.Add(1)
-	// }
-	// if zou.cfg.setup.IPv6 {
-	// 	zou.ncpWG.Add(1)
-	// }
-	// if zou.cfg.setup.DHCPv6IANA || zou.cfg.setup.DHCPv6IAPD {
-	// 	zou.ncpWG.Add(1)
-	// }
-	zou.onceSendResult = new(sync.Once)
-	zou.result = new(DialResult)
-	zou.result.R = ResultFailure
-	zou.result.PPPoEEP = zou.pppoeProto.LocalAddr().(*pppoe.Endpoint)
+	if capt != nil {
+		zou.pppoeOptions = append(zou.pppoeOptions, pppoe.WithCaptureHandler(capt.handle))
+	}
+	zou.attemptLock = new(sync.RWMutex)
+	zou.cur = zou.newAttempt()
 	zou.createFastPathMux = new(sync.Mutex)
 	zou.state = new(uint32)
 	atomic.StoreUint32(zou.state, StateInitial)
+	cfg.setup.metrics.sessionCreated(StateInitial)
+	zou.onceHoldSessionWG = new(sync.Once)
+	zou.sessionWGHeld = new(uint32)
+	zou.closed = new(uint32)
+	zou.closeChan = make(chan struct{})
+	zou.redialLock = new(sync.Mutex)
 	for _, option := range options {
 		option(zou)
 	}
@@ -141,7 +179,8 @@ func WithDialWG(wg *sync.WaitGroup) ZouPPPModifier {
 	}
 }
 
-// WithSessionWG specifies a WaitGroup, which will be done after closed after reach open state
+// WithSessionWG specifies a WaitGroup, which will be done after closed after reach open state;
+// if re-dial or churn mode is enabled, it is done after ZouPPP stops dialing
 func WithSessionWG(wg *sync.WaitGroup) ZouPPPModifier {
 	return func(zou *ZouPPP) {
 		zou.sessionWG = wg
@@ -164,46 +203,178 @@ func addWG(wg *sync.WaitGroup, delta int) {
 	}
 }
 
-// Dial dial PPPoE/LCP/PAPorCHAP/NCPs
+// Dial dial PPPoE/LCP/PAPorCHAP/NCPs;
+// if re-dial is enabled in setup, ZouPPP re-dials after dialing fails or the session goes down,
+// until max attempts is reached, ctx is cancelled or Close is called;
+// if churn mode is enabled in setup, ZouPPP tears down the session after hold time and dials again,
+// until ctx is cancelled or Close is called
 func (zou *ZouPPP) Dial(ctx context.Context) {
+	zou.parentCtx = ctx
+	if zou.cfg.setup.redialEnabled() || zou.cfg.setup.churnEnabled() {
+		zou.holdSessionWG()
+	}
+	zou.dial(ctx)
+}
+
+type attemptKey struct{}
+
+// attemptOf returns the dial attempt ctx belongs to
+func attemptOf(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}
+
+// current returns the current dial attempt
+func (zou *ZouPPP) current() *attempt {
+	zou.attemptLock.RLock()
+	defer zou.attemptLock.RUnlock()
+	return zou.cur
+}
+
+// stale returns true if ctx belongs to a previous dial attempt
+func (zou *ZouPPP) stale(ctx context.Context) bool {
+	return attemptOf(ctx) != zou.current()
+}
+
+// newAttempt returns a new dial attempt, with a new PPPoE instance
+func (zou *ZouPPP) newAttempt() *attempt {
+	a := &attempt{
+		ncpWG:          mywg.NewMyWG(),
+		result:         &DialResult{R: ResultFailure},
+		resultLock:     new(sync.Mutex),
+		onceSendResult: new(sync.Once),
+	}
+	a.pppoeProto = pppoe.NewPPPoE(zou.econn, zou.logger,
+		append(zou.pppoeOptions, pppoe.WithDiscoveryHandler(func(pkt *pppoe.Pkt) { zou.pppoeEvtHandler(a, pkt) }))...)
+	a.result.PPPoEEP = a.pppoeProto.LocalAddr().(*pppoe.Endpoint)
+	return a
+}
+
+// startAttempt makes a new dial attempt current, returns it with its ctx derived from ctx;
+// it returns nil if ZouPPP is closed, Close and startAttempt are serialized so that Close never misses an attempt
+func (zou *ZouPPP) startAttempt(ctx context.Context) (*attempt, context.Context) {
+	a := zou.newAttempt()
+	childctx, cancel := context.WithCancel(context.WithValue(ctx, attemptKey{}, a))
+	a.cancelFunc = cancel
+	zou.attemptLock.Lock()
+	defer zou.attemptLock.Unlock()
+	if atomic.LoadUint32(zou.closed) != 0 {
+		cancel()
+		return nil, nil
+	}
+	zou.cur = a
+	zou.attempts++
+	if zou.attempts > 1 {
+		zou.resetAttempt()
+	}
+	return a, childctx
+}
+
+// resetAttempt resets per dial attempt states kept in ZouPPP, for re-dial
+func (zou *ZouPPP) resetAttempt() {
+	zou.createFastPathMux.Lock()
+	zou.fastpath = nil
+	zou.createFastPathMux.Unlock()
+}
+
+func (zou *ZouPPP) dial(ctx context.Context) {
+	zou.setState(StateDialing)
+	a, childctx := zou.startAttempt(ctx)
+	if a == nil {
+		// closed
+		zou.cancelMe()
+		return
+	}
 	needTOTerminate := true
-	atomic.StoreUint32(zou.state, StateDialing)
 	defer func() {
 		if needTOTerminate {
 			zou.cancelMe()
 		}
 	}()
-	zou.result.StartTime = time.Now()
-	var childctx context.Context
-	childctx, zou.cancelFunc = context.WithCancel(ctx)
-	err := zou.pppoeProto.Dial(childctx)
+	a.resultLock.Lock()
+	a.result.StartTime = time.Now()
+	startTime := a.result.StartTime
+	a.resultLock.Unlock()
+	zou.phaseStart(PhasePADO, startTime)
+	err := a.pppoeProto.Dial(childctx)
+	if padoTime := a.pppoeProto.PADOTime(); !padoTime.IsZero() {
+		zou.phaseEnd(PhasePADO, padoTime)
+		zou.phaseStart(PhasePADS, padoTime)
+	}
 	if err != nil {
 		zou.logger.Error(err.Error())
+		zou.fail(pppoeFailureCause(err), err)
 		return
 	}
+	zou.phaseEnd(PhasePADS, a.pppoeProto.PADSTime())
+	zou.phaseStart(PhaseLCP, a.pppoeProto.PADSTime())
 	zou.logger.Info("pppoe open")
-	zou.pppProto = lcp.NewPPP(childctx, zou.pppoeProto, zou.pppoeProto.GetLogger())
-	defPeerRule, err := lcp.NewDefaultPeerOptionRule(zou.cfg.setup.AuthProto)
+	a.pppProto = lcp.NewPPP(childctx, a.pppoeProto, a.pppoeProto.GetLogger())
+	authOp, err := zou.cfg.setup.AuthProto.Op()
 	if err != nil {
 		zou.logger.Error(err.Error())
+		zou.fail(CauseOther, err)
 		return
 	}
-	zou.lcpProto = lcp.NewLCP(childctx, lcp.ProtoLCP, zou.pppProto, zou.lcpEvtHandler, lcp.WithPeerOptionRule(defPeerRule))
-	err = zou.lcpProto.Open(childctx)
+	defPeerRule := lcp.NewDefaultPeerOptionRuleWithAuthOp(authOp)
+	lcpMods := append(zou.cfg.setup.lcpModifiers(), lcp.WithPeerOptionRule(defPeerRule))
+	defPeerRule.PFC, defPeerRule.ACFC = zou.cfg.setup.PFC, zou.cfg.setup.ACFC
+	defPeerRule.LQR = zou.cfg.setup.LQRPeriod > 0
+	mru := uint16(lcp.DefaultMRU)
+	if maxPayload := a.pppoeProto.MaxPayload(); maxPayload > pppoe.DefaultMaxPayload {
+		// RFC4638, both sides could use MRU up to the negotiated max payload
+		defPeerRule.MaxMRU = maxPayload
+		mru = maxPayload
+	}
+	if mru != lcp.DefaultMRU || zou.cfg.setup.PFC || zou.cfg.setup.ACFC || zou.cfg.setup.LQRPeriod > 0 {
+		ownRule := lcp.NewDefaultOwnOptionRuleWithCompression(mru, zou.cfg.setup.PFC, zou.cfg.setup.ACFC)
+		if zou.cfg.setup.LQRPeriod > 0 {
+			ownRule.SetLQR(zou.cfg.setup.LQRPeriod)
+		}
+		lcpMods = append(lcpMods, lcp.WithOwnOptionRule(ownRule))
+	}
+	if zou.cfg.setup.metrics != nil {
+		lcpMods = append(lcpMods, lcp.WithEchoRTTHandler(zou.cfg.setup.metrics.observeEchoRTT))
+	}
+	lcpProto := lcp.NewLCP(childctx, lcp.ProtoLCP, a.pppProto, zou.lcpEvtHandler, lcpMods...)
+	a.lcpProto.Store(lcpProto)
+	err = lcpProto.Open(childctx)
 	if err != nil {
 		zou.logger.Error(err.Error())
+		zou.fail(CauseOther, err)
 		return
 	}
-	zou.lcpProto.Up(childctx)
+	lcpProto.Up(childctx)
 	needTOTerminate = false
 }
 
-// Close shutdown the client
+// Close shutdown the client, also stops re-dialing
 func (zou *ZouPPP) Close() {
-	zou.pppoeProto.Close()
+	zou.attemptLock.Lock()
+	if atomic.CompareAndSwapUint32(zou.closed, 0, 1) {
+		close(zou.closeChan)
+	}
+	a := zou.cur
+	zou.attemptLock.Unlock()
+	a.pppoeProto.Close()
 	zou.cancelMe()
 }
 
+// setState sets ZouPPP state to s
+func (zou *ZouPPP) setState(s uint32) {
+	old := atomic.SwapUint32(zou.state, s)
+	zou.cfg.setup.metrics.stateChanged(old, s)
+}
+
+// casState changes ZouPPP state from old to s, return false if current state is not old
+func (zou *ZouPPP) casState(old, s uint32) bool {
+	if !atomic.CompareAndSwapUint32(zou.state, old, s) {
+		return false
+	}
+	zou.cfg.setup.metrics.stateChanged(old, s)
+	return true
+}
+
 func (zou *ZouPPP) cancelMe() {
 	s := atomic.LoadUint32(zou.state)
 	zou.logger.Sugar().Debugf("zouppp stopped at state %v", stateStr(s))
@@ -211,27 +382,108 @@ func (zou *ZouPPP) cancelMe() {
 	case StateClosed, StateClosing:
 		return
 	}
+	if !zou.casState(s, StateClosing) {
+		return
+	}
 	switch s {
 	case StateInitial, StateDialing:
 		zou.reportDialResult()
 	}
-	if s == StateOpen {
-		//no need to report result here, since the result should already been reported
+	if cancel := zou.current().cancelFunc; cancel != nil {
+		cancel()
+	}
+	zou.createFastPathMux.Lock()
+	if zou.fastpath != nil {
+		zou.fastpath.Close()
+	}
+	zou.createFastPathMux.Unlock()
+	if !zou.scheduleChurn(s == StateOpen) && !zou.scheduleRedial(s == StateOpen) {
+		zou.releaseSessionWG()
+	}
+}
+
+// holdSessionWG adds sessionWG once
+func (zou *ZouPPP) holdSessionWG() {
+	zou.onceHoldSessionWG.Do(func() {
+		addWG(zou.sessionWG, 1)
+		atomic.StoreUint32(zou.sessionWGHeld, 1)
+	})
+}
+
+// releaseSessionWG done sessionWG if it is held
+func (zou *ZouPPP) releaseSessionWG() {
+	if atomic.CompareAndSwapUint32(zou.sessionWGHeld, 1, 2) {
 		doneWG(zou.sessionWG, nil)
 	}
-	atomic.StoreUint32(zou.state, StateClosing)
-	zou.cancelFunc()
+}
+
+// fail records the cause of dialing failure of current attempt, only the first cause is recorded
+func (zou *ZouPPP) fail(cause FailureCause, err error) {
+	a := zou.current()
+	a.resultLock.Lock()
+	defer a.resultLock.Unlock()
+	if a.reported || a.result.Cause != CauseNone {
+		return
+	}
+	a.result.Cause = cause
+	a.result.Err = err
+}
 
+// phaseStart records the start time of phase p of current attempt
+func (zou *ZouPPP) phaseStart(p Phase, t time.Time) {
+	a := zou.current()
+	a.resultLock.Lock()
+	defer a.resultLock.Unlock()
+	if !a.reported {
+		a.result.Phases[p].Start = t
+	}
 }
 
+// phaseEnd records the end time of phase p of current attempt
+func (zou *ZouPPP) phaseEnd(p Phase, t time.Time) {
+	a := zou.current()
+	a.resultLock.Lock()
+	defer a.resultLock.Unlock()
+	if !a.reported {
+		a.result.Phases[p].End = t
+	}
+}
+
+func (zou *ZouPPP) phaseFinished(p Phase) bool {
+	a := zou.current()
+	a.resultLock.Lock()
+	defer a.resultLock.Unlock()
+	return a.result.Phases[p].Finished()
+}
+
+// reportDialResult reports the dialing result of current attempt,
+// every attempt is reported, including re-dials and churned calls
 func (zou *ZouPPP) reportDialResult() {
 	doneWG(zou.dialWG, zou.onceDoneDialWG)
-	zou.onceSendResult.Do(func() {
-		zou.result.DialFinishTime = time.Now()
-		zou.result.R = ResultFailure
+	zou.launchDone()
+	a := zou.current()
+	a.onceSendResult.Do(func() {
+		a.resultLock.Lock()
+		a.reported = true
+		a.result.DialFinishTime = time.Now()
+		a.result.Offers = a.pppoeProto.Offers()
+		a.result.SelectedOffer = a.pppoeProto.SelectedOffer()
+		a.result.R = ResultFailure
 		if atomic.LoadUint32(zou.state) == StateOpen {
-			zou.result.R = ResultSuccess
+			a.result.R = ResultSuccess
+			a.result.Cause = CauseNone
+			a.result.Err = nil
+			a.result.IPv4Addr = a.assignedV4Addr
+			a.result.IANAs = a.assignedIANAs
+			a.result.IAPDs = a.assignedIAPDs
+		} else if a.result.Cause == CauseNone {
+			a.result.Cause = CauseOther
+			if atomic.LoadUint32(zou.closed) != 0 || (zou.parentCtx != nil && zou.parentCtx.Err() != nil) {
+				a.result.Cause = CauseCancelled
+			}
 		}
+		a.resultLock.Unlock()
+		zou.cfg.setup.metrics.observeDialResult(a.result)
 		if zou.cfg.setup.resultCh != nil {
 			select {
 			case <-zou.cfg.setup.stopResultCh:
@@ -241,7 +493,7 @@ func (zou *ZouPPP) reportDialResult() {
 			select {
 			case <-zou.cfg.setup.stopResultCh:
 				return
-			case zou.cfg.setup.resultCh <- zou.result:
+			case zou.cfg.setup.resultCh <- a.result:
 			}
 		}
 	})
@@ -249,16 +501,23 @@ func (zou *ZouPPP) reportDialResult() {
 }
 
 func (zou *ZouPPP) waitForDialDone(ctx context.Context) {
-
+	ncpWG := attemptOf(ctx).ncpWG
 	select {
 	case <-ctx.Done(): //cancelled
-		zou.ncpWG.Cancel()
-		zou.ncpWG.Wait()
-		atomic.StoreUint32(zou.state, StateClosed)
-	case <-zou.ncpWG.FinishChan: //NCP dial finished
-		addWG(zou.sessionWG, 1)
-		zou.dialSucceed = true
-		atomic.StoreUint32(zou.state, StateOpen)
+		ncpWG.Cancel()
+		ncpWG.Wait()
+		if zou.stale(ctx) {
+			return
+		}
+		zou.setState(StateClosed)
+	case <-ncpWG.FinishChan: //NCP dial finished
+		if zou.stale(ctx) || ctx.Err() != nil {
+			return
+		}
+		zou.holdSessionWG()
+		zou.setState(StateOpen)
+		zou.redialSucceed()
+		zou.churnOpened(ctx)
 	}
 	if zou.cfg.setup.Apply {
 		err := zou.createDatapath(ctx)
@@ -269,77 +528,139 @@ func (zou *ZouPPP) waitForDialDone(ctx context.Context) {
 	zou.reportDialResult()
 }
 
+// pppoeEvtHandler handles PADT/PADM/PADN received from AC during the session of attempt a,
+// PADM and PADN are logged by pppoe
+func (zou *ZouPPP) pppoeEvtHandler(a *attempt, pkt *pppoe.Pkt) {
+	if pkt.Code != pppoe.CodePADT || a != zou.current() {
+		return
+	}
+	zou.logger.Info("session terminated by AC")
+	zou.fail(CausePADTReceived, pppoe.ErrSessionTerminated)
+	// called in PPP recv routine, don't block it
+	go zou.cancelMe()
+}
+
 func (zou *ZouPPP) lcpEvtHandler(ctx context.Context, evt lcp.LayerNotifyEvent) {
 	zou.logger.Sugar().Infof("LCP layer %v", evt)
+	if zou.stale(ctx) {
+		return
+	}
+	a := attemptOf(ctx)
 	needTOTerminate := true
 	defer func() {
-		if needTOTerminate {
+		if needTOTerminate && !zou.stale(ctx) {
 			zou.cancelMe()
 		}
 	}()
 	switch evt {
 	case lcp.LCPLayerNotifyUp:
+		zou.phaseEnd(PhaseLCP, time.Now())
+		zou.phaseStart(PhaseAuth, time.Now())
 		//run auth
-		opauthlist := zou.lcpProto.PeerRule.GetOptions().Get(uint8(lcp.OpTypeAuthenticationProtocol))
+		opauthlist := a.lcpProto.Load().PeerRule.GetOptions().Get(uint8(lcp.OpTypeAuthenticationProtocol))
 		if len(opauthlist) == 0 {
 			zou.logger.Error("no authentication method is negotiated")
+			zou.fail(CauseAuthFailed, fmt.Errorf("no authentication method is negotiated"))
 			return
 		}
-		authProto := zou.lcpProto.PeerRule.GetOptions().Get(uint8(lcp.OpTypeAuthenticationProtocol))[0].(*lcp.LCPOpAuthProto).Proto
+		authOp := opauthlist[0].(*lcp.LCPOpAuthProto)
+		authProto := authOp.Proto
 		switch authProto {
 		case lcp.ProtoCHAP:
-			chapProto := chap.NewCHAP(zou.cfg.UserName, zou.cfg.Password, zou.pppProto)
+			chapProto := chap.NewCHAP(zou.cfg.UserName, zou.cfg.Password, a.pppProto, chap.WithAlg(authOp.CHAPAlg))
 			err := chapProto.AUTHSelf()
 			if err != nil {
 				zou.logger.Sugar().Errorf("auth failed,%v", err)
+				zou.fail(CauseAuthFailed, err)
+				zou.cfg.setup.metrics.authFailed(authProto.String())
+				return
+			}
+			zou.logger.Info("auth succeed")
+		case lcp.ProtoEAP:
+			eapProto := eap.NewEAP(zou.cfg.UserName, zou.cfg.Password, a.pppProto, eap.WithTLSConfig(zou.cfg.setup.eapTLSConfig))
+			err := eapProto.AuthSelf()
+			if err != nil {
+				zou.logger.Sugar().Errorf("auth failed,%v", err)
+				zou.fail(CauseAuthFailed, err)
+				zou.cfg.setup.metrics.authFailed(authProto.String())
 				return
 			}
 			zou.logger.Info("auth succeed")
 		case lcp.ProtoPAP:
-			papProto := pap.NewPAP(zou.cfg.UserName, zou.cfg.Password, zou.pppProto)
+			papProto := pap.NewPAP(zou.cfg.UserName, zou.cfg.Password, a.pppProto)
 			err := papProto.AuthSelf()
 			if err != nil {
 				zou.logger.Sugar().Errorf("auth failed,%v", err)
+				zou.fail(CauseAuthFailed, err)
+				zou.cfg.setup.metrics.authFailed(authProto.String())
 				return
 			}
 			zou.logger.Info("auth succeed")
 		default:
 			zou.logger.Sugar().Errorf("unkown auth method negoatied %v", authProto)
+			zou.fail(CauseAuthFailed, fmt.Errorf("unkown auth method negoatied %v", authProto))
 			return
 
 		}
+		zou.phaseEnd(PhaseAuth, time.Now())
 		launchWaitRoutine := false
 		if zou.cfg.setup.IPv4 {
-			zou.ipcpProto = lcp.NewLCP(ctx, lcp.ProtoIPCP, zou.pppProto, zou.ipcpEvtHandler,
-				lcp.WithOwnOptionRule(lcp.NewDefaultIPCPOwnRule()),
-				lcp.WithPeerOptionRule(&lcp.DefaultIPCPPeerRule{}),
+			zou.phaseStart(PhaseIPCP, time.Now())
+			ipcpProto := lcp.NewLCP(ctx, lcp.ProtoIPCP, a.pppProto, zou.ipcpEvtHandler,
+				append(zou.cfg.setup.lcpModifiers(),
+					lcp.WithOwnOptionRule(lcp.NewDefaultIPCPOwnRule()),
+					lcp.WithPeerOptionRule(&lcp.DefaultIPCPPeerRule{}),
+				)...,
 			)
-			err := zou.ipcpProto.Open(ctx)
+			a.ipcpProto.Store(ipcpProto)
+			err := ipcpProto.Open(ctx)
 			if err != nil {
+				zou.fail(CauseIPCPFailed, err)
 				return
 			}
-			zou.ncpWG.Add(1)
+			a.ncpWG.Add(1)
 			go zou.waitForDialDone(ctx)
 			launchWaitRoutine = true
-			zou.ipcpProto.Up(ctx)
+			ipcpProto.Up(ctx)
 		}
 		if zou.cfg.setup.IPv6 {
-			ipcp6rule := lcp.NewDefaultIP6CPRule(ctx, zou.pppoeProto.LocalAddr().(*pppoe.Endpoint).L2EP.HwAddr)
-			zou.ipv6cpProto = lcp.NewLCP(ctx, lcp.ProtoIPv6CP, zou.pppProto, zou.ipcp6EvtHandler,
-				lcp.WithOwnOptionRule(ipcp6rule),
-				lcp.WithPeerOptionRule(ipcp6rule),
+			zou.phaseStart(PhaseIPv6CP, time.Now())
+			ipcp6rule := lcp.NewDefaultIP6CPRule(ctx, a.pppoeProto.LocalAddr().(*pppoe.Endpoint).L2EP.HwAddr)
+			ipv6cpProto := lcp.NewLCP(ctx, lcp.ProtoIPv6CP, a.pppProto, zou.ipcp6EvtHandler,
+				append(zou.cfg.setup.lcpModifiers(),
+					lcp.WithOwnOptionRule(ipcp6rule),
+					lcp.WithPeerOptionRule(ipcp6rule),
+				)...,
 			)
-			err := zou.ipv6cpProto.Open(ctx)
+			a.ipv6cpProto.Store(ipv6cpProto)
+			err := ipv6cpProto.Open(ctx)
 			if err != nil {
+				zou.fail(CauseIPv6CPFailed, err)
 				return
 			}
-			zou.ncpWG.Add(1)
+			a.ncpWG.Add(1)
 			if !launchWaitRoutine {
 				go zou.waitForDialDone(ctx)
 			}
-			zou.ipv6cpProto.Up(ctx)
+			ipv6cpProto.Up(ctx)
 		}
 	case lcp.LCPLayerNotifyDown, lcp.LCPLayerNotifyFinished:
+		if evt == lcp.LCPLayerNotifyDown && a.lcpTerminating.Load() {
+			// tearing down by LCP terminate-request, wait for term-ack
+			needTOTerminate = false
+			return
+		}
+		if zou.phaseFinished(PhaseLCP) {
+			zou.fail(CauseLCPDown, fmt.Errorf("LCP layer %v", evt))
+		} else {
+			zou.fail(CauseLCPTimeout, fmt.Errorf("LCP layer %v", evt))
+		}
+		return
+	case lcp.LCPLayerNotifyLoopedBack:
+		zou.fail(CauseLCPLoopedBack, fmt.Errorf("LCP layer %v", evt))
+		// term-req can't terminate a looped-back link, send PADT instead, even if dialing result is already reported
+		zou.logger.Error("link is looped-back, terminating the session")
+		a.pppoeProto.Close()
 		return
 	default:
 	}
@@ -354,23 +675,27 @@ func (zou *ZouPPP) createDatapath(ctx context.Context) error {
 		return nil
 	}
 	zou.logger.Info("creating datapath")
+	a := attemptOf(ctx)
 	var err error
-	mruop := zou.lcpProto.PeerRule.GetOptions().GetFirst((uint8(lcp.OpTypeMaximumReceiveUnit)))
+	mruop := a.lcpProto.Load().PeerRule.GetOptions().GetFirst((uint8(lcp.OpTypeMaximumReceiveUnit)))
 	var mru uint16 = 1498
 	if mruop != nil {
 		mru = uint16(*(mruop.(*lcp.LCPOpMRU)))
 	}
+	if maxPayload := a.pppoeProto.MaxPayload(); mru > maxPayload {
+		mru = maxPayload
+	}
 
 	var v6ifid []byte
-	if zou.ipv6cpProto != nil {
-		if ifidop := zou.ipv6cpProto.OwnRule.GetOption(uint8(lcp.IP6CPOpInterfaceIdentifier)); ifidop != nil {
+	if ipv6cpProto := a.ipv6cpProto.Load(); ipv6cpProto != nil {
+		if ifidop := ipv6cpProto.OwnRule.GetOption(uint8(lcp.IP6CPOpInterfaceIdentifier)); ifidop != nil {
 			ifid := [8]byte(*ifidop.(*lcp.InterfaceIDOption))
 			v6ifid = ifid[:]
 		}
 	}
 
-	zou.fastpath, err = datapath.NewTUNIf(ctx, zou.pppProto, zou.cfg.PPPIfName,
-		append(zou.assignedIANAs, zou.assignedV4Addr),
+	zou.fastpath, err = datapath.NewTUNIf(ctx, a.pppProto, zou.cfg.PPPIfName,
+		append(a.assignedIANAs, a.assignedV4Addr),
 		v6ifid,
 		mru,
 	)
@@ -383,8 +708,8 @@ func (zou *ZouPPP) createDatapath(ctx context.Context) error {
 
 // GetV6LLA returns the IPv6 LLA the compsoed of negotiated interface-id via IPv6CP
 func (zou *ZouPPP) GetV6LLA() (net.IP, error) {
-	if zou.ipv6cpProto != nil {
-		if ifidop := zou.ipv6cpProto.OwnRule.GetOption(uint8(lcp.IP6CPOpInterfaceIdentifier)); ifidop != nil {
+	if ipv6cpProto := zou.current().ipv6cpProto.Load(); ipv6cpProto != nil {
+		if ifidop := ipv6cpProto.OwnRule.GetOption(uint8(lcp.IP6CPOpInterfaceIdentifier)); ifidop != nil {
 			ifid := [8]byte(*ifidop.(*lcp.InterfaceIDOption))
 			lla := make([]byte, 16)
 			copy(lla[:8], lcp.IPv6LinkLocalPrefix[:8])
@@ -395,25 +720,59 @@ func (zou *ZouPPP) GetV6LLA() (net.IP, error) {
 	return nil, fmt.Errorf("ipv6cp is not up")
 }
 
+// EchoStats returns the LCP echo statistics of current session, zero if LCP is not started;
+// it is reset upon re-dial
+func (zou *ZouPPP) EchoStats() lcp.EchoStats {
+	lcpProto := zou.current().lcpProto.Load()
+	if lcpProto == nil {
+		return lcp.EchoStats{}
+	}
+	return lcpProto.EchoStats()
+}
+
+// LQRStats returns the LQR statistics and link quality of current session, zero if LQR is not negotiated;
+// it is reset upon re-dial
+func (zou *ZouPPP) LQRStats() lcp.LQRStats {
+	lcpProto := zou.current().lcpProto.Load()
+	if lcpProto == nil {
+		return lcp.LQRStats{}
+	}
+	return lcpProto.LQRStats()
+}
+
 func (zou *ZouPPP) ipcpEvtHandler(ctx context.Context, evt lcp.LayerNotifyEvent) {
 	zou.logger.Sugar().Infof("IPCP layer %v", evt)
+	if zou.stale(ctx) {
+		return
+	}
+	a := attemptOf(ctx)
 	switch evt {
 	case lcp.LCPLayerNotifyUp:
-		defer zou.ncpWG.Done()
-		if v4addrop := zou.ipcpProto.OwnRule.GetOption(uint8(lcp.OpIPAddress)); v4addrop != nil {
-			zou.assignedV4Addr = v4addrop.(*lcp.IPv4AddrOption).Addr
+		defer a.ncpWG.Done()
+		zou.phaseEnd(PhaseIPCP, time.Now())
+		if v4addrop := a.ipcpProto.Load().OwnRule.GetOption(uint8(lcp.OpIPAddress)); v4addrop != nil {
+			a.assignedV4Addr = v4addrop.(*lcp.IPv4AddrOption).Addr
 		}
 	case lcp.LCPLayerNotifyDown, lcp.LCPLayerNotifyFinished:
+		zou.fail(CauseIPCPFailed, fmt.Errorf("IPCP layer %v", evt))
 		zou.cancelMe()
 		return
 	}
 }
 func (zou *ZouPPP) dialDHCPv6(ctx context.Context) {
-	defer zou.ncpWG.Done()
+	a := attemptOf(ctx)
+	defer a.ncpWG.Done()
 	if zou.cfg.setup.DHCPv6IANA || zou.cfg.setup.DHCPv6IAPD {
+		needTOTerminate := true
+		defer func() {
+			if needTOTerminate {
+				zou.cancelMe()
+			}
+		}()
+		zou.phaseStart(PhaseDHCPv6, time.Now())
 		zou.logger.Sugar().Infof("dialing DHCPv6 IANA %v IAPD %v", zou.cfg.setup.DHCPv6IANA, zou.cfg.setup.DHCPv6IAPD)
 		childctx, cancel := context.WithCancel(ctx)
-		econn := lcp.NewPPPConn(childctx, zou.pppProto, lcp.ProtoIPv6)
+		econn := lcp.NewPPPConn(childctx, a.pppProto, lcp.ProtoIPv6)
 		defer econn.Close()
 		defer cancel()
 		lla, _ := zou.GetV6LLA()
@@ -422,6 +781,7 @@ func (zou *ZouPPP) dialDHCPv6(ctx context.Context) {
 			[]etherconn.RUDPConnOption{etherconn.WithAcceptAny(true)})
 		if err != nil {
 			zou.logger.Sugar().Errorf("failed to create SharingRUDPConn %v", err)
+			zou.fail(CauseDHCPv6Failed, err)
 			return
 		}
 		clnt, err := NewDHCP6Clnt(rudpconn, &DHCP6Cfg{
@@ -432,28 +792,38 @@ func (zou *ZouPPP) dialDHCPv6(ctx context.Context) {
 		}, lla)
 		if err != nil {
 			zou.logger.Sugar().Errorf("failed to create DHCPv6 client, %v", err)
+			zou.fail(CauseDHCPv6Failed, err)
 			return
 		}
 		err = clnt.Dial()
 		if err != nil {
 			zou.logger.Error(err.Error())
+			zou.fail(dhcp6FailureCause(err), err)
 			return
 		}
-		zou.assignedIANAs = clnt.assignedIANAs
-		zou.assignedIAPDs = clnt.assignedIAPDs
+		zou.phaseEnd(PhaseDHCPv6, time.Now())
+		a.assignedIANAs = clnt.assignedIANAs
+		a.assignedIAPDs = clnt.assignedIAPDs
+		needTOTerminate = false
 
 	}
 
 }
 func (zou *ZouPPP) ipcp6EvtHandler(ctx context.Context, evt lcp.LayerNotifyEvent) {
 	zou.logger.Sugar().Infof("IPv6CP layer %v", evt)
+	if zou.stale(ctx) {
+		return
+	}
+	a := attemptOf(ctx)
 	switch evt {
 	case lcp.LCPLayerNotifyUp:
-		defer zou.ncpWG.Done()
-		zou.ncpWG.Add(1)
+		defer a.ncpWG.Done()
+		zou.phaseEnd(PhaseIPv6CP, time.Now())
+		a.ncpWG.Add(1)
 		go zou.dialDHCPv6(ctx)
 
 	case lcp.LCPLayerNotifyDown, lcp.LCPLayerNotifyFinished:
+		zou.fail(CauseIPv6CPFailed, fmt.Errorf("IPv6CP layer %v", evt))
 		zou.cancelMe()
 		return
 	}
@@ -490,6 +860,22 @@ type DialResult struct {
 	StartTime time.Time
 	// DialFinishTime is when dailing finishes
 	DialFinishTime time.Time
+	// Phases records start and end time of each dialing phase, indexed by Phase
+	Phases [NumOfPhases]PhaseTiming
+	// Cause is the cause of dialing failure, CauseNone if dialing succeed
+	Cause FailureCause
+	// Err is the error caused dialing failure
+	Err error
+	// IPv4Addr is the IPv4 address assigned via IPCP
+	IPv4Addr net.IP
+	// IANAs is the list of IANA addresses assigned via DHCPv6
+	IANAs []net.IP
+	// IAPDs is the list of IAPD prefixes assigned via DHCPv6
+	IAPDs []*net.IPNet
+	// Offers is the list of all PADOs received
+	Offers []*pppoe.Offer
+	// SelectedOffer is the selected PADO, nil if none is selected
+	SelectedOffer *pppoe.Offer
 }
 
 // Setup holds common configruation for creating one or mulitple ZouPPP sessions
@@ -510,16 +896,67 @@ type Setup struct {
 	VLANStep uint `usage:"VLAN step to increase for each client"`
 	// ExcludedVLANs is the slice of vlan id to skip, apply to all layer of vlans
 	ExcludedVLANs []uint16 `usage:"a list of excluded VLAN id, apply to all layer of vlans"`
-	// Interval is the amount of time to wait between launching each session
+	// Interval is the amount of time to wait between launching each session, ignored if LaunchRate is not 0
 	Interval time.Duration `usage:"amount of time to wait between launching each session"`
-	LogLevel LoggingLvl    `alias:"l" usage:"log levl, err|info|debug"`
+	// LaunchRate is the target rate of launching sessions, in sessions per second
+	LaunchRate float64 `usage:"target rate of launching sessions per second, overrides interval"`
+	// RampProfile is the ramp-up profile of launch rate
+	RampProfile RampProfile `usage:"ramp-up profile of launch rate, none, linear or step"`
+	// RampTime is the amount of time to ramp up to LaunchRate
+	RampTime time.Duration `usage:"amount of time to ramp up to the target launch rate"`
+	// RampSteps is the number of steps of RampStep profile
+	RampSteps uint `usage:"number of steps of step ramp-up profile"`
+	// MaxOutstanding is the max number of sessions dialing at the same time when launching, 0 means no limit
+	MaxOutstanding uint       `usage:"max number of outstanding dials when launching sessions, 0 means no limit"`
+	LogLevel       LoggingLvl `alias:"l" usage:"log levl, err|info|debug"`
 	// if Apply is true, then create a PPP interface with assigned addresses; could be set to false if only to test protocol
 	Apply bool `usage:"if Apply is true, then create a PPP interface with assigned addresses; could be set to false if only to test protocol"`
-	// number of Retries
-	Retry   uint          `usage:"number of setup retry"`
-	Timeout time.Duration `usage:"setup timeout"`
-	// AuthProto is the authenticaiton protocol to use, e.g. lcp.ProtoCHAP
-	AuthProto lcp.PPPProtocolNumber `usage:"auth protocol, PAP or CHAP"`
+	// PADOWindow is the amount of time to collect PADOs after sending PADI, before selecting an AC by PADOSelect
+	PADOWindow time.Duration `usage:"amount of time to collect PADOs before selecting an AC, 0 means selecting upon receiving PADO"`
+	// PADOSelect is the PADO selection policy: lowestdelay, roundrobin, acname:<name>, svcname:<name> or acmac:<mac>
+	PADOSelect   string `usage:"PADO selection policy, lowestdelay|roundrobin|acname:<name>|svcname:<name>|acmac:<mac>"`
+	padoSelector pppoe.PADOSelector
+	// Retry is the max number of PADI/PADR transmissions, 0 means pppoe.DefaultRetry; overridden by PADIRetry/PADRRetry
+	Retry uint `usage:"max number of PADI/PADR transmissions, 0 means 3"`
+	// Timeout is the initial PADI/PADR timeout, 0 means pppoe.DefaultTimeout; overridden by PADITimeout/PADRTimeout
+	Timeout time.Duration `usage:"initial PADI/PADR timeout, 0 means 3s"`
+	// PADITimeout/PADIBackoff/PADIMaxTimeout/PADIRetry are the PADI retransmission timers, 0 means Timeout/Retry or pppoe defaults;
+	// timeout is multiplied by PADIBackoff after each retransmission, up to PADIMaxTimeout
+	PADITimeout    time.Duration `usage:"initial PADI timeout, 0 means using timeout"`
+	PADIBackoff    float64       `usage:"PADI timeout is multiplied by this factor after each retransmission, 1 means fixed timeout, RFC2516 recommends 2"`
+	PADIMaxTimeout time.Duration `usage:"max PADI timeout, 0 means no limit"`
+	PADIRetry      uint          `usage:"max number of PADI transmissions, 0 means using retry"`
+	// PADRTimeout/PADRBackoff/PADRMaxTimeout/PADRRetry are the PADR retransmission timers, same as PADI ones
+	PADRTimeout    time.Duration `usage:"initial PADR timeout, 0 means using timeout"`
+	PADRBackoff    float64       `usage:"PADR timeout is multiplied by this factor after each retransmission, 1 means fixed timeout"`
+	PADRMaxTimeout time.Duration `usage:"max PADR timeout, 0 means no limit"`
+	PADRRetry      uint          `usage:"max number of PADR transmissions, 0 means using retry"`
+	// Report is the file path to write result report, CSV format if the file extension is .csv, otherwise JSON
+	Report string `usage:"write result report to the specified file, CSV if file extension is .csv, otherwise JSON"`
+	// HistogramBuckets is the list of upper bounds of latency histogram buckets in result summary
+	HistogramBuckets []time.Duration `usage:"upper bounds of latency histogram buckets in result summary"`
+	// Capture is the pcapng file path to write PPPoE discovery and PPP control pkts (including DHCPv6) to,
+	// a file per session if it contains @ID, otherwise all sessions write to the same file; disabled if empty
+	Capture     string `usage:"write PPPoE discovery and PPP control pkts to the specified pcapng file, a file per session if it contains @ID"`
+	captures    map[string]*pcapng.Writer
+	captureLock *sync.Mutex
+	// RedialMaxAttempts is the max number of consecutive re-dial attempts after dialing fails or session goes down, 0 means no re-dial
+	RedialMaxAttempts uint `usage:"max number of consecutive re-dial attempts after dialing fails or session goes down, 0 means no re-dial"`
+	// RedialBackoff is the delay before the 1st re-dial attempt, doubled for each following attempt
+	RedialBackoff time.Duration `usage:"delay before 1st re-dial attempt, doubled for each following attempt"`
+	// RedialMaxBackoff is the max delay between re-dial attempts
+	RedialMaxBackoff time.Duration `usage:"max delay between re-dial attempts"`
+	// RedialJitter randomizes re-dial delay by +/- RedialJitter*delay, between 0 and 1
+	RedialJitter float64 `usage:"randomize re-dial delay by +/- jitter*delay, between 0 and 1"`
+	// AuthProto is the authenticaiton protocol to use, e.g. lcp.AuthCHAP
+	AuthProto lcp.AuthProtocol `usage:"auth protocol, PAP, CHAP, MSCHAPv2 or EAP"`
+	// EAPCertFile/EAPKeyFile is the client certificate/private key PEM file for EAP-TLS, EAP-TLS is disabled if it is empty
+	EAPCertFile string `usage:"EAP-TLS client certificate file"`
+	EAPKeyFile  string `usage:"EAP-TLS client private key file"`
+	// EAPCAFile is the CA certificate PEM file to verify authenticator's certificate for EAP-TLS, no verification if it is empty
+	EAPCAFile string `usage:"EAP-TLS CA certificate file"`
+	// tls config for EAP-TLS, loaded by Init()
+	eapTLSConfig *tls.Config
 	// each ZouPPP session will send dialing result to resultCh
 	resultCh chan *DialResult
 	// close stopResultCh as signal result collecting should stop
@@ -528,10 +965,41 @@ type Setup struct {
 	RID string `usage:"BBF remote-id"`
 	// CID is the BBF circuit-id PPPoE tag
 	CID string `usage:"BBF circuit-id"`
-	// UserName for PAP/CHAP auth
-	UserName string `alias:"u" usage:"PAP/CHAP username"`
-	// Password for PAP/CHAP auth
-	Password string `alias:"p" usage:"PAP/CHAP password"`
+	// AccessLine is a list of BBF access-line characteristics sub-tags, each in format of <sub-tag>=<value>;
+	// sub-tag is the name like ActualDataRateUpstream or the number like 0x81;
+	// value is either a number could contain @ID, or a range <min>-<max> which is assigned to sessions in turn
+	AccessLine      []string `usage:"BBF access-line sub-tags, <sub-tag>=<value>, value could contain @ID or be a range <min>-<max>, e.g. ActualDataRateDownstream=10000-20000"`
+	accessLineSpecs []*accessLineSpec
+	// ServiceName is the PPPoE Service-Name to request, empty means any service
+	ServiceName string `alias:"svc" usage:"PPPoE Service-Name to request, empty means any service"`
+	// ACName is the AC-Name of the AC to connect, PADO from other AC is ignored; empty means any AC
+	ACName string `usage:"only accept PADO with this AC-Name, empty means any AC"`
+	// MaxPayload is the PPP-Max-Payload to request as defined in RFC4638, which allows LCP MRU above 1492; 0 means not requesting
+	MaxPayload uint16 `usage:"PPP-Max-Payload to request, allows MRU above 1492 if AC supports it, 0 means not requesting"`
+	// PFC requests and accepts LCP Protocol-Field-Compression option
+	PFC bool `usage:"request and accept LCP protocol field compression"`
+	// ACFC requests and accepts LCP Address-and-Control-Field-Compression option, though RFC2516 doesn't allow it over PPPoE
+	ACFC bool `usage:"request and accept LCP address and control field compression, it doesn't change PPPoE framing"`
+	// LCPRestartTimer is the amount of time to wait for response of conf-req, term-req or echo-request, also used by IPCP and IPv6CP
+	LCPRestartTimer time.Duration `usage:"LCP/NCP restart timer, 0 means 10s"`
+	// LCPMaxConfigure is the RFC1661 Max-Configure, also used by IPCP and IPv6CP
+	LCPMaxConfigure uint `usage:"max number of conf-req sent without receiving a response, 0 means 3"`
+	// LCPMaxTerminate is the RFC1661 Max-Terminate, also used by IPCP and IPv6CP
+	LCPMaxTerminate uint `usage:"max number of term-req sent without receiving a response, 0 means 3"`
+	// LCPMaxFailure is the RFC1661 Max-Failure, also used by IPCP and IPv6CP
+	LCPMaxFailure uint `usage:"max number of conf-nak sent before rejecting the options instead, 0 means 5"`
+	// LCPKeepAlive is the LCP keepalive mode
+	LCPKeepAlive lcp.KeepAliveMode `usage:"LCP keepalive mode, always|idle|disabled; idle only sends echo-request if nothing is received from peer during keepalive interval"`
+	// LCPKeepAliveInterval is the interval of sending LCP echo-request
+	LCPKeepAliveInterval time.Duration `usage:"LCP keepalive interval, 0 means 5s"`
+	// LCPMaxEchoMiss is the number of missed echo-reply tolerated before LCP goes down
+	LCPMaxEchoMiss uint `usage:"number of missed LCP echo-reply tolerated, LCP goes down if the following echo-request is also not replied"`
+	// LQRPeriod is the LQR Reporting-Period to request, RFC1989; peer's LQR request is also accepted if it is non-zero
+	LQRPeriod time.Duration `usage:"LQR reporting period to request, also accepts peer's LQR request; 0 disables LQR"`
+	// UserName for PAP/CHAP auth, also used as EAP identity
+	UserName string `alias:"u" usage:"PAP/CHAP username, EAP identity"`
+	// Password for PAP/CHAP/EAP-MD5 auth
+	Password string `alias:"p" usage:"PAP/CHAP/EAP-MD5 password"`
 	// the name of PPP interface created after successfully dialing
 	PPPIfName string `usage:"name of PPP interface created after successfully dialing, must contain @ID"`
 	// Run IPCP if true
@@ -541,6 +1009,22 @@ type Setup struct {
 	// run DHCPv6 over PPP if true
 	DHCPv6IANA bool `usage:"run DHCPv6 over PPP to get an IANA address"`
 	DHCPv6IAPD bool `usage:"run DHCPv6 over PPP to get an IAPD prefix"`
+	// Churn enables churn mode, keeps all sessions up, each session is torn down after hold time then re-dialed at rate of CPS
+	Churn bool `usage:"churn mode, tear down each session after hold time and re-dial at rate of cps, keep all sessions up"`
+	// HoldTime is the amount of time each session holds before being torn down in churn mode
+	HoldTime time.Duration `usage:"amount of time each session holds before tear down in churn mode"`
+	// HoldTimeMax, if bigger than HoldTime, hold time of each session is uniformly distributed between HoldTime and HoldTimeMax
+	HoldTimeMax time.Duration `usage:"if bigger than holdtime, hold time of each session is randomly distributed between holdtime and holdtimemax in churn mode"`
+	// CPS is the max re-dial rate in calls per second in churn mode, 0 means no limit
+	CPS float64 `usage:"max re-dial rate in calls per second in churn mode, 0 means no limit"`
+	// ChurnTeardown is the method to tear down a session in churn mode
+	ChurnTeardown ChurnTeardown `usage:"tear down method in churn mode, PADT or LCP"`
+	churnPacer    *callPacer
+	churnStats    *ChurnStats
+	// MetricsAddr is the listening address of Prometheus metrics HTTP endpoint, e.g. ":9100", disabled if empty
+	MetricsAddr string `usage:"listening address of Prometheus metrics HTTP endpoint /metrics, e.g. :9100, disabled if empty"`
+	// metrics is created by Init() if MetricsAddr is not empty
+	metrics *Metrics
 	// enable profiling for dev
 	Profiling bool `usage:"enable profiling, dev use only"`
 	// use XDP to forward packet
@@ -558,6 +1042,8 @@ func DefaultSetup() *Setup {
 	r := new(Setup)
 	r.resultCh = make(chan *DialResult, resultChannelDepth)
 	r.stopResultCh = make(chan struct{})
+	r.captures = make(map[string]*pcapng.Writer)
+	r.captureLock = new(sync.Mutex)
 	// r.logger, err = NewDefaultZouPPPLogger(LogLvlErr)
 	// if err != nil {
 	// 	return nil, err
@@ -574,10 +1060,27 @@ func DefaultSetup() *Setup {
 	r.NumOfClients = 1
 	// r.StartMAC = iff.HardwareAddr
 	r.Apply = true
-	r.AuthProto = lcp.ProtoCHAP
+	r.AuthProto = lcp.AuthCHAP
 	// r.UserName = uname
 	// r.Password = upass
 	r.PPPIfName = DefaultPPPIfNameTemplate
+	r.HistogramBuckets = append([]time.Duration{}, DefaultHistogramBuckets...)
+	r.RedialBackoff = DefaultRedialBackoff
+	r.RedialMaxBackoff = DefaultRedialMaxBackoff
+	r.RedialJitter = DefaultRedialJitter
+	r.PADOSelect = DefaultPADOSelect
+	r.PADIBackoff = pppoe.DefaultPADIBackoffFactor
+	r.PADRBackoff = pppoe.DefaultPADRBackoffFactor
+	r.RampSteps = DefaultRampSteps
+	r.HoldTime = DefaultHoldTime
+	r.CPS = DefaultCPS
+	r.LCPRestartTimer = lcp.DefaultRestartTimerDuration
+	r.LCPMaxConfigure = lcp.DefaultMaxConfigure
+	r.LCPMaxTerminate = lcp.DefaultMaxTerminate
+	r.LCPMaxFailure = lcp.DefaultMaxFailure
+	r.LCPKeepAlive = lcp.KeepAliveAlways
+	r.LCPKeepAliveInterval = lcp.DefaultKeepAliveInterval
+	r.LCPMaxEchoMiss = lcp.DefaultMaxEchoMiss
 	r.IPv4 = true
 	r.IPv6 = false
 	return r
@@ -605,9 +1108,132 @@ func (setup *Setup) Init() error {
 	if !strings.Contains(setup.PPPIfName, VarName) {
 		return fmt.Errorf("ppp interface name must contain %v", VarName)
 	}
+	if setup.RedialJitter < 0 || setup.RedialJitter > 1 {
+		return fmt.Errorf("re-dial jitter must be between 0 and 1")
+	}
+	if setup.EAPCertFile != "" {
+		setup.eapTLSConfig, err = eap.LoadTLSConfig(setup.EAPCertFile, setup.EAPKeyFile, setup.EAPCAFile)
+		if err != nil {
+			return err
+		}
+	}
+	if setup.PADIBackoff < 0 || setup.PADRBackoff < 0 {
+		return fmt.Errorf("backoff factor can't be negative")
+	}
+	if setup.MaxPayload > 0 && setup.MaxPayload < pppoe.DefaultMaxPayload {
+		return fmt.Errorf("max payload can't be less than %d", pppoe.DefaultMaxPayload)
+	}
+	if setup.LCPRestartTimer < 0 || setup.LCPKeepAliveInterval < 0 {
+		return fmt.Errorf("LCP timers can't be negative")
+	}
+	if setup.LQRPeriod != 0 && setup.LQRPeriod < 10*time.Millisecond {
+		return fmt.Errorf("LQR period must be 0 or at least 10ms")
+	}
+	setup.accessLineSpecs = nil
+	for _, s := range setup.AccessLine {
+		spec, err := parseAccessLineSpec(s)
+		if err != nil {
+			return err
+		}
+		setup.accessLineSpecs = append(setup.accessLineSpecs, spec)
+	}
+	setup.padoSelector, err = newPADOSelector(setup.PADOSelect)
+	if err != nil {
+		return err
+	}
+	if setup.LaunchRate < 0 {
+		return fmt.Errorf("launch rate can't be negative")
+	}
+	if setup.Churn {
+		if setup.HoldTime <= 0 {
+			return fmt.Errorf("hold time must be bigger than 0 in churn mode")
+		}
+		if setup.CPS < 0 {
+			return fmt.Errorf("cps can't be negative")
+		}
+		setup.churnPacer = newCallPacer(setup.CPS)
+		setup.churnStats = new(ChurnStats)
+	}
+	if setup.MetricsAddr != "" {
+		setup.metrics = NewMetrics(setup.HistogramBuckets)
+		setup.metrics.watchChurnStats(setup.churnStats)
+	}
 	return nil
 }
 
+// lcpModifiers returns the lcp.Modifier of timers, counters and keepalive policy, zero timers/counters mean lcp defaults
+func (setup *Setup) lcpModifiers() []lcp.Modifier {
+	r := []lcp.Modifier{}
+	if setup.LCPMaxFailure > 0 {
+		r = append(r, lcp.WithMaxFailure(uint32(setup.LCPMaxFailure)))
+	}
+	if setup.LCPRestartTimer > 0 {
+		r = append(r, lcp.WithRestartTimer(setup.LCPRestartTimer))
+	}
+	if setup.LCPMaxConfigure > 0 {
+		r = append(r, lcp.WithMaxConfigure(uint32(setup.LCPMaxConfigure)))
+	}
+	if setup.LCPMaxTerminate > 0 {
+		r = append(r, lcp.WithMaxTerminate(uint32(setup.LCPMaxTerminate)))
+	}
+	interval := setup.LCPKeepAliveInterval
+	if interval == 0 {
+		interval = lcp.DefaultKeepAliveInterval
+	}
+	return append(r, lcp.WithKeepAlive(setup.LCPKeepAlive, interval, uint32(setup.LCPMaxEchoMiss)))
+}
+
+// padiBackoff returns PADI retransmission timers, zero fields mean pppoe defaults
+func (setup *Setup) padiBackoff() pppoe.Backoff {
+	return newBackoff(setup.PADITimeout, setup.Timeout, setup.PADIBackoff, setup.PADIMaxTimeout, setup.PADIRetry, setup.Retry)
+}
+
+// padrBackoff returns PADR retransmission timers, zero fields mean pppoe defaults
+func (setup *Setup) padrBackoff() pppoe.Backoff {
+	return newBackoff(setup.PADRTimeout, setup.Timeout, setup.PADRBackoff, setup.PADRMaxTimeout, setup.PADRRetry, setup.Retry)
+}
+
+// newBackoff returns a pppoe.Backoff, timeout/retry fall back to defTimeout/defRetry if they are 0
+func newBackoff(timeout, defTimeout time.Duration, factor float64, maxTimeout time.Duration, retry, defRetry uint) pppoe.Backoff {
+	if timeout == 0 {
+		timeout = defTimeout
+	}
+	if retry == 0 {
+		retry = defRetry
+	}
+	return pppoe.Backoff{
+		Timeout:    timeout,
+		Factor:     factor,
+		MaxTimeout: maxTimeout,
+		Retry:      int(retry),
+	}
+}
+
+// DefaultPADOSelect is the default PADO selection policy
+const DefaultPADOSelect = "lowestdelay"
+
+// newPADOSelector returns a pppoe.PADOSelector according to policy, see Setup.PADOSelect
+func newPADOSelector(policy string) (pppoe.PADOSelector, error) {
+	name, val, _ := strings.Cut(strings.TrimSpace(policy), ":")
+	switch strings.ToLower(name) {
+	case "", "lowestdelay":
+		return pppoe.SelectLowestDelay(), nil
+	case "roundrobin":
+		return pppoe.NewRoundRobinSelector(), nil
+	case "acname":
+		return pppoe.SelectByACName(val), nil
+	case "svcname":
+		return pppoe.SelectByServiceName(val), nil
+	case "acmac":
+		mac, err := net.ParseMAC(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AC MAC in PADO selection policy %v, %w", policy, err)
+		}
+		return pppoe.SelectByACMAC(mac), nil
+	}
+	return nil, fmt.Errorf("unknown PADO selection policy %v", policy)
+}
+
 func (setup *Setup) excluded(vids []uint16) bool {
 	for _, vid := range vids {
 		for _, extv := range setup.ExcludedVLANs {
@@ -627,16 +1253,25 @@ func (setup *Setup) Logger() *zap.Logger {
 	return setup.logger
 }
 
+// Metrics returns the Metrics created by Init(), nil if MetricsAddr is empty
+func (setup *Setup) Metrics() *Metrics {
+	return setup.metrics
+}
+
 // Config hold client specific configuration
 type Config struct {
-	Mac       net.HardwareAddr
-	VLANs     etherconn.VLANs
-	setup     *Setup
-	RID       string
-	CID       string
-	UserName  string
-	Password  string
-	PPPIfName string
+	Mac         net.HardwareAddr
+	VLANs       etherconn.VLANs
+	setup       *Setup
+	RID         string
+	CID         string
+	ServiceName string
+	ACName      string
+	AccessLine  map[pppoe.BBFSubTagNum]uint32
+	UserName    string
+	Password    string
+	PPPIfName   string
+	Capture     string
 }
 
 // NewDefaultZouPPPLogger create a default logger with specified log level
@@ -721,9 +1356,16 @@ func GenClientConfigurations(setup *Setup) ([]*Config, error) {
 
 		ccfg.RID = genStrFunc(setup.RID, i)
 		ccfg.CID = genStrFunc(setup.CID, i)
+		ccfg.ServiceName = genStrFunc(setup.ServiceName, i)
+		ccfg.ACName = genStrFunc(setup.ACName, i)
+		ccfg.AccessLine, err = genAccessLine(setup.accessLineSpecs, i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate access-line sub-tags,%w", err)
+		}
 		ccfg.UserName = genStrFunc(setup.UserName, i)
 		ccfg.Password = genStrFunc(setup.Password, i)
 		ccfg.PPPIfName = genStrFunc(setup.PPPIfName, i)
+		ccfg.Capture = genStrFunc(setup.Capture, i)
 		if ccfg.PPPIfName == setup.PPPIfName {
 			return nil, fmt.Errorf("PPP interface name doesn't contain %v", VarName)
 		}
@@ -734,7 +1376,7 @@ func GenClientConfigurations(setup *Setup) ([]*Config, error) {
 
 // ResultSummary is the summary stats of dialup results
 type ResultSummary struct {
-	// Total is the total number of sessions
+	// Total is the total number of dial results, a session has a result per dial attempt if re-dial or churn mode is enabled
 	Total uint
 	// Success is the total number of sessions suceessfully finished dailup
 	Success uint
@@ -752,7 +1394,26 @@ type ResultSummary struct {
 	TotalTime time.Duration
 	// AvgSuccessTime is the average amount of time of a success session finish dialup
 	AvgSuccessTime time.Duration
-	setup          *Setup
+	// Latency is the latency distribution of success sessions finish dialup
+	Latency LatencyStats
+	// Phases is the summary stats of each dialing phase, indexed by Phase
+	Phases [NumOfPhases]PhaseSummary
+	// Causes is the number of failed sessions of each FailureCause, indexed by FailureCause
+	Causes [NumOfCauses]uint
+	// SelectedACs is the number of success sessions of each selected AC, key is "<AC-Name>(<AC MAC>)"
+	SelectedACs map[string]uint
+	// Results is the list of all collected dialup results
+	Results []*DialResult
+	setup   *Setup
+}
+
+// SetupRate returns the number of sessions successfully finish dialup per second, 0 if there is no success session
+func (rs ResultSummary) SetupRate() float64 {
+	totalSuccessSeconds := (float64(rs.SuccessTotalTime) / float64(time.Second))
+	if totalSuccessSeconds <= 0 {
+		return 0
+	}
+	return float64(rs.Success) / totalSuccessSeconds
 }
 
 func (rs ResultSummary) String() string {
@@ -762,35 +1423,66 @@ func (rs ResultSummary) String() string {
 	r += fmt.Sprintf("Failed:%d\n", rs.Failed)
 	r += fmt.Sprintf("Duration:%v\n", rs.TotalTime)
 	r += fmt.Sprintf("Interval:%v\n", rs.setup.Interval)
-	totalSuccessSeconds := (float64(rs.SuccessTotalTime) / float64(time.Second))
-	if totalSuccessSeconds == 0 {
+	if rate := rs.SetupRate(); rate == 0 {
 		r += fmt.Sprintln(`Setup rate: n\a`)
 	} else {
-		r += fmt.Sprintf("Setup rate:%v\n", float64(rs.Success)/totalSuccessSeconds)
+		r += fmt.Sprintf("Setup rate:%v\n", rate)
 	}
 
 	r += fmt.Sprintf("Fastest success:%v\n", rs.Shortest)
 	r += fmt.Sprintf("Success within 10 seconds:%v\n", rs.LessThanTenSecond)
 	r += fmt.Sprintf("Slowest success:%v\n", rs.Longest)
 	r += fmt.Sprintf("Avg success time:%v\n", rs.AvgSuccessTime)
+	r += fmt.Sprintf("Success latency:%v\n", rs.Latency.PercentileString())
+	r += fmt.Sprintf("Success histogram:%v\n", rs.Latency.HistogramString())
+	for p, ps := range rs.Phases {
+		if ps.Finished == 0 {
+			continue
+		}
+		r += fmt.Sprintf("Phase %v: finished:%d fastest:%v avg:%v slowest:%v\n",
+			Phase(p), ps.Finished, ps.Shortest, ps.Avg(), ps.Longest)
+		r += fmt.Sprintf("  latency:%v\n", ps.Latency.PercentileString())
+		r += fmt.Sprintf("  histogram:%v\n", ps.Latency.HistogramString())
+	}
+	for c, n := range rs.Causes {
+		if n == 0 {
+			continue
+		}
+		r += fmt.Sprintf("Failed by %v:%d\n", FailureCause(c), n)
+	}
+	acs := []string{}
+	for ac := range rs.SelectedACs {
+		acs = append(acs, ac)
+	}
+	sort.Strings(acs)
+	for _, ac := range acs {
+		r += fmt.Sprintf("Selected AC %v:%d\n", ac, rs.SelectedACs[ac])
+	}
 	return r
 }
 
 const maxDuration = time.Duration(int64(^uint64(0) >> 1))
 
-// CollectResults use setup.ResultCh to collect dialup results, and generate a ResultSummary in the end, send it via resultch
+// CollectResults use setup.ResultCh to collect dialup results, and generate a ResultSummary in the end, send it via resultch;
+// if re-dial or churn mode is enabled, every dial attempt has a result, CollectResults collects until setup is closed
 func CollectResults(setup *Setup, resultch chan *ResultSummary) {
 	summary := new(ResultSummary)
 	summary.setup = setup
+	summary.SelectedACs = make(map[string]uint)
 	totalSuccessTime := time.Duration(0)
 	summary.Shortest = maxDuration
 	summary.Longest = time.Duration(0)
-	var beginTime, endTime time.Time
+	var beginTime, endTime, lastFinishTime time.Time
 	beginTime = time.Now()
+	successTimes := []time.Duration{}
 L1:
 	for {
 		select {
 		case <-setup.stopResultCh:
+			if len(setup.resultCh) > 0 {
+				// collect results already sent
+				continue
+			}
 			break L1
 		case r := <-setup.resultCh:
 			completeTime := r.DialFinishTime.Sub(r.StartTime)
@@ -810,8 +1502,18 @@ L1:
 					summary.Shortest = completeTime
 				}
 				totalSuccessTime += completeTime
+				successTimes = append(successTimes, completeTime)
+				if r.SelectedOffer != nil {
+					summary.SelectedACs[fmt.Sprintf("%v(%v)", r.SelectedOffer.ACName, r.SelectedOffer.ACMAC)]++
+				}
 			case ResultFailure:
 				summary.Failed++
+				summary.Causes[r.Cause]++
+			}
+			for p, pt := range r.Phases {
+				if pt.Finished() {
+					summary.Phases[p].add(pt.Duration())
+				}
 			}
 			if r.StartTime.Before(beginTime) {
 				beginTime = r.StartTime
@@ -821,19 +1523,30 @@ L1:
 					endTime = r.DialFinishTime
 				}
 			}
+			if r.DialFinishTime.After(lastFinishTime) {
+				lastFinishTime = r.DialFinishTime
+			}
+			summary.Results = append(summary.Results, r)
 			summary.Total++
-			if summary.Total == setup.NumOfClients {
+			if summary.Total == setup.NumOfClients && !setup.redialEnabled() && !setup.churnEnabled() {
 				break L1
 			}
 
 		}
 	}
+	summary.Latency = newLatencyStats(successTimes, setup.HistogramBuckets)
+	for p := range summary.Phases {
+		summary.Phases[p].Latency = newLatencyStats(summary.Phases[p].samples, setup.HistogramBuckets)
+	}
 	if summary.Success != 0 {
 		summary.AvgSuccessTime = totalSuccessTime / time.Duration(summary.Success)
 	} else {
 		summary.AvgSuccessTime = 0
 	}
 	summary.SuccessTotalTime = endTime.Sub(beginTime)
+	if !lastFinishTime.IsZero() {
+		summary.TotalTime = lastFinishTime.Sub(beginTime)
+	}
 	if summary.Shortest == maxDuration {
 		summary.Shortest = 0
 	}
@@ -143,7 +143,7 @@ type testCase struct {
 //			setup: &Setup{
 //				rootLogger: rootlog,
 //				Timeout:    3 * time.Second,
-//				AuthProto:  lcp.ProtoPAP,
+//				AuthProto:  lcp.AuthPAP,
 //				IPv4:       true,
 //				IPv6:       true,
 //				Apply:      true,
@@ -174,7 +174,7 @@ func TestPPPoE(t *testing.T) {
 				setup: &Setup{
 					logger:    rootlog,
 					Timeout:   10 * time.Second,
-					AuthProto: lcp.ProtoPAP,
+					AuthProto: lcp.AuthPAP,
 					IPv4:      true,
 					IPv6:      false,
 					Apply:     true,
@@ -198,7 +198,7 @@ func TestPPPoE(t *testing.T) {
 				setup: &Setup{
 					logger:    rootlog,
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoCHAP,
+					AuthProto: lcp.AuthCHAP,
 					IPv4:      true,
 					IPv6:      false,
 				},
@@ -224,7 +224,7 @@ func TestPPPoE(t *testing.T) {
 					logger:     rootlog,
 					LogLevel:   LogLvlDebug,
 					Timeout:    3 * time.Second,
-					AuthProto:  lcp.ProtoPAP,
+					AuthProto:  lcp.AuthPAP,
 					IPv4:       false,
 					IPv6:       true,
 					DHCPv6IANA: true,
@@ -308,7 +308,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoCHAP,
+					AuthProto: lcp.AuthCHAP,
 					IPv4:      false,
 					IPv6:      true,
 				},
@@ -333,7 +333,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:    3 * time.Second,
-					AuthProto:  lcp.ProtoCHAP,
+					AuthProto:  lcp.AuthCHAP,
 					IPv4:       true,
 					IPv6:       true,
 					DHCPv6IAPD: true,
@@ -452,7 +452,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoPAP,
+					AuthProto: lcp.AuthPAP,
 					IPv4:      true,
 					IPv6:      true,
 				},
@@ -476,7 +476,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoPAP,
+					AuthProto: lcp.AuthPAP,
 					IPv4:      true,
 					IPv6:      false,
 				},
@@ -501,7 +501,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoCHAP,
+					AuthProto: lcp.AuthCHAP,
 					IPv4:      true,
 					IPv6:      false,
 				},
@@ -522,7 +522,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoPAP,
+					AuthProto: lcp.AuthPAP,
 					IPv4:      true,
 					IPv6:      false,
 				},
@@ -546,7 +546,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoPAP,
+					AuthProto: lcp.AuthPAP,
 					IPv4:      true,
 					IPv6:      false,
 				},
@@ -571,7 +571,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoPAP,
+					AuthProto: lcp.AuthPAP,
 					IPv4:      true,
 					IPv6:      false,
 				},
@@ -596,7 +596,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoCHAP,
+					AuthProto: lcp.AuthCHAP,
 					IPv4:      true,
 					IPv6:      false,
 				},
@@ -622,7 +622,7 @@ func TestPPPoE(t *testing.T) {
 					logger: rootlog,
 
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoCHAP,
+					AuthProto: lcp.AuthCHAP,
 					IPv4:      true,
 					IPv6:      true,
 				},
@@ -648,7 +648,7 @@ func TestPPPoE(t *testing.T) {
 				setup: &Setup{
 					logger:    rootlog,
 					Timeout:   3 * time.Second,
-					AuthProto: lcp.ProtoCHAP,
+					AuthProto: lcp.AuthCHAP,
 					IPv4:      true,
 					IPv6:      true,
 				},
@@ -732,17 +732,17 @@ func TestPPPoE(t *testing.T) {
 			return fmt.Errorf("datapath creation failed")
 		}
 		if z.cfg.setup.DHCPv6IANA {
-			if len(z.assignedIANAs) == 0 {
+			if len(z.current().assignedIANAs) == 0 {
 				return fmt.Errorf("failed to get IANA")
 			} else {
-				t.Logf("assigned IANAs: %+v\n", z.assignedIANAs)
+				t.Logf("assigned IANAs: %+v\n", z.current().assignedIANAs)
 			}
 		}
 		if z.cfg.setup.DHCPv6IAPD {
-			if len(z.assignedIAPDs) == 0 {
+			if len(z.current().assignedIAPDs) == 0 {
 				return fmt.Errorf("failed to get IANA")
 			} else {
-				t.Logf("assigned IAPDs: %+v\n", z.assignedIAPDs)
+				t.Logf("assigned IAPDs: %+v\n", z.current().assignedIAPDs)
 			}
 		}
 		return nil
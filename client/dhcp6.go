@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -12,6 +13,14 @@ import (
 	"github.com/insomniacslk/dhcp/iana"
 )
 
+// errors returned by DHCP6Clnt.Dial
+var (
+	// ErrDHCPv6NoAdvertise means no DHCPv6 advertise is received
+	ErrDHCPv6NoAdvertise = errors.New("no DHCPv6 advertise received")
+	// ErrDHCPv6NoReply means no DHCPv6 reply is received
+	ErrDHCPv6NoReply = errors.New("no DHCPv6 reply received")
+)
+
 // DHCP6Cfg hold configuration for DHCP6Clnt
 type DHCP6Cfg struct {
 	Mac            net.HardwareAddr
@@ -138,7 +147,7 @@ func (dc *DHCP6Clnt) Dial() error {
 		nclient6.AllDHCPRelayAgentsAndServers, solicitMsg,
 		nclient6.IsMessageType(dhcpv6.MessageTypeAdvertise))
 	if err != nil {
-		return fmt.Errorf("failed recv DHCPv6 advertisement for %v, %v", dc.cfg.Mac, err)
+		return fmt.Errorf("%w for %v, %v", ErrDHCPv6NoAdvertise, dc.cfg.Mac, err)
 	}
 	err = checkResp(adv, false)
 	if err != nil {
@@ -152,7 +161,7 @@ func (dc *DHCP6Clnt) Dial() error {
 		nclient6.AllDHCPRelayAgentsAndServers,
 		request, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
 	if err != nil {
-		return fmt.Errorf("failed to recv DHCPv6 reply for %v, %v", dc.cfg.Mac, err)
+		return fmt.Errorf("%w for %v, %v", ErrDHCPv6NoReply, dc.cfg.Mac, err)
 	}
 	err = checkResp(reply, true)
 	if err != nil {
@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRampSteps is the default number of steps of RampStep profile
+	DefaultRampSteps = 10
+	// maxLaunchPoll is the max amount of time to wait before re-checking tokens, since rate changes during ramp-up
+	maxLaunchPoll = 100 * time.Millisecond
+)
+
+// RampProfile is the ramp-up profile of session launch rate
+type RampProfile uint8
+
+const (
+	// RampNone launches at the target rate from the start
+	RampNone RampProfile = iota
+	// RampLinear increases launch rate linearly from 0 to the target rate during ramp time
+	RampLinear
+	// RampStep increases launch rate in equal steps to the target rate during ramp time
+	RampStep
+)
+
+func (rp RampProfile) MarshalText() (text []byte, err error) {
+	switch rp {
+	case RampNone:
+		return []byte("none"), nil
+	case RampLinear:
+		return []byte("linear"), nil
+	case RampStep:
+		return []byte("step"), nil
+	}
+	return nil, fmt.Errorf("unknown ramp profile %d", rp)
+}
+
+func (rp *RampProfile) UnmarshalText(text []byte) error {
+	input := strings.TrimSpace(strings.ToLower(string(text)))
+	switch input {
+	case "none":
+		*rp = RampNone
+	case "linear":
+		*rp = RampLinear
+	case "step":
+		*rp = RampStep
+	default:
+		return fmt.Errorf("unknown ramp profile, %s", string(text))
+	}
+	return nil
+}
+
+// LaunchScheduler controls the rate of launching sessions with a token bucket,
+// optionally ramping up the rate and capping the number of outstanding dials
+type LaunchScheduler struct {
+	rate        float64
+	profile     RampProfile
+	rampTime    time.Duration
+	rampSteps   uint
+	burst       float64
+	outstanding chan struct{}
+	lock        *sync.Mutex
+	start       time.Time
+	last        time.Time
+	tokens      float64
+}
+
+// LaunchModifier is a function provides addtional configuration for NewLaunchScheduler()
+type LaunchModifier func(ls *LaunchScheduler)
+
+// WithRamp specifies the ramp-up profile, the amount of time to reach the target rate,
+// and number of steps for RampStep profile
+func WithRamp(profile RampProfile, rampTime time.Duration, steps uint) LaunchModifier {
+	return func(ls *LaunchScheduler) {
+		ls.profile = profile
+		ls.rampTime = rampTime
+		if steps > 0 {
+			ls.rampSteps = steps
+		}
+	}
+}
+
+// WithMaxOutstanding specifies the max number of outstanding dials, 0 means no limit;
+// a dial is outstanding from Wait returns until Done is called
+func WithMaxOutstanding(n uint) LaunchModifier {
+	return func(ls *LaunchScheduler) {
+		if n > 0 {
+			ls.outstanding = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithBurst specifies the token bucket size, e.g. max number of sessions could be launched at once, default is 1
+func WithBurst(n uint) LaunchModifier {
+	return func(ls *LaunchScheduler) {
+		if n > 0 {
+			ls.burst = float64(n)
+		}
+	}
+}
+
+// NewLaunchScheduler returns a LaunchScheduler with target rate in sessions per second, 0 means no rate limit
+func NewLaunchScheduler(rate float64, options ...LaunchModifier) *LaunchScheduler {
+	r := &LaunchScheduler{
+		rate:      rate,
+		rampSteps: DefaultRampSteps,
+		burst:     1,
+		lock:      new(sync.Mutex),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// rateAt returns the launch rate at elapsed time since the 1st launch
+func (ls *LaunchScheduler) rateAt(elapsed time.Duration) float64 {
+	if ls.rampTime <= 0 || elapsed >= ls.rampTime {
+		return ls.rate
+	}
+	switch ls.profile {
+	case RampLinear:
+		return ls.rate * float64(elapsed) / float64(ls.rampTime)
+	case RampStep:
+		step := uint(elapsed*time.Duration(ls.rampSteps)/ls.rampTime) + 1
+		return ls.rate * float64(step) / float64(ls.rampSteps)
+	}
+	return ls.rate
+}
+
+// take takes a token if there is one, return 0;
+// otherwise return the amount of time to wait before trying again
+func (ls *LaunchScheduler) take() time.Duration {
+	if ls.rate <= 0 {
+		return 0
+	}
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	now := time.Now()
+	if ls.start.IsZero() {
+		ls.start = now
+		ls.last = now
+		ls.tokens = ls.burst
+	}
+	// rate changes during ramp-up, use the rate in the middle of the refill period
+	ls.tokens += ls.rateAt(ls.last.Sub(ls.start)+now.Sub(ls.last)/2) * now.Sub(ls.last).Seconds()
+	if ls.tokens > ls.burst {
+		ls.tokens = ls.burst
+	}
+	ls.last = now
+	if ls.tokens >= 1 {
+		ls.tokens--
+		return 0
+	}
+	rate := ls.rateAt(now.Sub(ls.start))
+	if rate <= 0 {
+		return maxLaunchPoll
+	}
+	d := time.Duration((1 - ls.tokens) / rate * float64(time.Second))
+	if d > maxLaunchPoll {
+		d = maxLaunchPoll
+	}
+	if d < time.Microsecond {
+		d = time.Microsecond
+	}
+	return d
+}
+
+// Wait blocks until next session could be launched, return error if ctx is done
+func (ls *LaunchScheduler) Wait(ctx context.Context) error {
+	if ls.outstanding != nil {
+		select {
+		case ls.outstanding <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for {
+		d := ls.take()
+		if d == 0 {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			ls.Done()
+			return ctx.Err()
+		}
+	}
+}
+
+// Done is called when a launched session finishes dialing, it frees an outstanding dial slot
+func (ls *LaunchScheduler) Done() {
+	if ls.outstanding == nil {
+		return
+	}
+	select {
+	case <-ls.outstanding:
+	default:
+	}
+}
+
+// NewLaunchScheduler returns a LaunchScheduler according to setup;
+// if LaunchRate is 0, the rate is derived from Interval
+func (setup *Setup) NewLaunchScheduler() *LaunchScheduler {
+	rate := setup.LaunchRate
+	if rate == 0 && setup.Interval > 0 {
+		rate = float64(time.Second) / float64(setup.Interval)
+	}
+	return NewLaunchScheduler(rate,
+		WithRamp(setup.RampProfile, setup.RampTime, setup.RampSteps),
+		WithMaxOutstanding(setup.MaxOutstanding),
+	)
+}
+
+// WithLaunchScheduler specifies the LaunchScheduler used to launch the ZouPPP,
+// its Done is called when ZouPPP finishes the initial dialing
+func WithLaunchScheduler(ls *LaunchScheduler) ZouPPPModifier {
+	return func(zou *ZouPPP) {
+		zou.launchScheduler = ls
+		zou.onceLaunchDone = new(sync.Once)
+	}
+}
+
+// launchDone calls LaunchScheduler.Done once
+func (zou *ZouPPP) launchDone() {
+	if zou.launchScheduler != nil {
+		zou.onceLaunchDone.Do(zou.launchScheduler.Done)
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLaunchScheduler(t *testing.T) {
+	ls := NewLaunchScheduler(100, WithRamp(RampStep, 10*time.Second, 4))
+	for elapsed, expected := range map[time.Duration]float64{
+		0:                25,
+		2 * time.Second:  25,
+		5 * time.Second:  75,
+		10 * time.Second: 100,
+	} {
+		if r := ls.rateAt(elapsed); r != expected {
+			t.Fatalf("step rate at %v, expect %v, got %v", elapsed, expected, r)
+		}
+	}
+	ls = NewLaunchScheduler(100, WithRamp(RampLinear, 10*time.Second, 0))
+	if r := ls.rateAt(2500 * time.Millisecond); r != 25 {
+		t.Fatalf("linear rate expect 25, got %v", r)
+	}
+	// rate
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ls = NewLaunchScheduler(200)
+	start := time.Now()
+	for i := 0; i < 21; i++ {
+		if err := ls.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 90*time.Millisecond || d > time.Second {
+		t.Fatalf("expect launching 21 sessions at 200/s takes about 100ms, took %v", d)
+	}
+	// max outstanding
+	ls = NewLaunchScheduler(0, WithMaxOutstanding(2))
+	for i := 0; i < 2; i++ {
+		if err := ls.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shortctx, shortcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortcancel()
+	if err := ls.Wait(shortctx); err == nil {
+		t.Fatal("expect blocking by max outstanding")
+	}
+	ls.Done()
+	if err := ls.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,426 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+	"github.com/hujun-open/zouppp/auth"
+	"github.com/hujun-open/zouppp/chap"
+	"github.com/hujun-open/zouppp/lcp"
+	"github.com/hujun-open/zouppp/loopback"
+	"github.com/hujun-open/zouppp/pppoe"
+	"go.uber.org/zap"
+)
+
+// testIPCPPeerRule is the IPCP PeerOptionRule of testBRAS, it NAKs peer's address with Addr, rejects other options
+type testIPCPPeerRule struct {
+	Addr    net.IP
+	current lcp.Options
+}
+
+func (rule *testIPCPPeerRule) GetOptions() lcp.Options {
+	return rule.current
+}
+
+func (rule *testIPCPPeerRule) HandlerConfReq(rcvd lcp.Options) (nak, reject lcp.Options) {
+	rule.current = rcvd
+	for _, o := range rcvd {
+		switch lcp.IPCPOptionType(o.Type()) {
+		case lcp.OpIPAddress:
+			if !o.(*lcp.IPv4AddrOption).Addr.Equal(rule.Addr) {
+				nak = append(nak, lcp.NewAddrOp(rule.Addr, lcp.OpIPAddress))
+			}
+		default:
+			reject = append(reject, o)
+		}
+	}
+	return
+}
+
+// testBRAS is an in-process PPPoE server for hermetic tests, it runs pppoe.AC, LCP, CHAP authenticator and IPCP;
+// the n-th accepted session is assigned with the n-th address of PoolStart
+type testBRAS struct {
+	Addr      net.IP
+	PoolStart net.IP
+	store     auth.CredentialStore
+	logger    *zap.Logger
+	wg        *sync.WaitGroup
+	// sessions receives accepted sessions if not nil
+	sessions chan *pppoe.ACSession
+}
+
+// serve accepts sessions on relay until ctx is cancelled
+func (bras *testBRAS) serve(ctx context.Context, t *testing.T, relay etherconn.PacketRelay, mac net.HardwareAddr) {
+	conn := etherconn.NewEtherConn(mac, relay,
+		etherconn.WithEtherTypes([]uint16{pppoe.EtherTypePPPoEDiscovery, pppoe.EtherTypePPPoESession}),
+		etherconn.WithRecvMulticast(true))
+	ac, err := pppoe.NewAC(conn, bras.logger.Named("ac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ac.Serve(ctx)
+	bras.wg = new(sync.WaitGroup)
+	go func() {
+		for i := 0; ; i++ {
+			s, err := ac.Accept(ctx)
+			if err != nil {
+				return
+			}
+			addr := make(net.IP, 4)
+			copy(addr, bras.PoolStart.To4())
+			addr[3] += byte(i)
+			if bras.sessions != nil {
+				bras.sessions <- s
+			}
+			bras.wg.Add(1)
+			go bras.runSession(ctx, s, addr)
+		}
+	}()
+}
+
+// runSession runs PPP over session s, assigns addr to the peer
+func (bras *testBRAS) runSession(ctx context.Context, s *pppoe.ACSession, addr net.IP) {
+	defer bras.wg.Done()
+	logger := bras.logger.Named(addr.String())
+	ppp := lcp.NewPPP(ctx, s, logger)
+	ipcpOwnRule := lcp.NewDefaultIPCPOwnRule()
+	ipcpOwnRule.Addr = bras.Addr
+	ipcpOwnRule.DNS, ipcpOwnRule.SecondaryDNS, ipcpOwnRule.NBNS, ipcpOwnRule.SecondaryNBNS = nil, nil, nil, nil
+	var lcpProto, ipcpProto *lcp.LCP
+	ipcpProto = lcp.NewLCP(ctx, lcp.ProtoIPCP, ppp, func(ctx context.Context, evt lcp.LayerNotifyEvent) {
+		logger.Sugar().Infof("IPCP layer %v", evt)
+	}, lcp.WithOwnOptionRule(ipcpOwnRule), lcp.WithPeerOptionRule(&testIPCPPeerRule{Addr: addr}),
+		// 1st conf-req might be sent before client starts IPCP
+		lcp.WithRestartTimer(time.Second))
+	lcpProto = lcp.NewLCP(ctx, lcp.ProtoLCP, ppp, func(ctx context.Context, evt lcp.LayerNotifyEvent) {
+		logger.Sugar().Infof("LCP layer %v", evt)
+		if evt != lcp.LCPLayerNotifyUp {
+			return
+		}
+		go func() {
+			peer, err := chap.NewAuthenticator("bras", bras.store, ppp).AuthPeer()
+			if err != nil {
+				logger.Sugar().Errorf("auth failed, %v", err)
+				lcpProto.Close(ctx)
+				return
+			}
+			logger.Sugar().Infof("%v authenticated", peer)
+			if err = ipcpProto.Open(ctx); err != nil {
+				logger.Sugar().Errorf("failed to open IPCP, %v", err)
+				return
+			}
+			ipcpProto.Up(ctx)
+		}()
+	}, lcp.WithOwnOptionRule(lcp.NewAuthenticatorOwnOptionRule(lcp.NewCHAPAuthOp())),
+		lcp.WithPeerOptionRule(&lcp.DefaultPeerOptionRule{}))
+	if err := lcpProto.Open(ctx); err != nil {
+		logger.Sugar().Errorf("failed to open LCP, %v", err)
+		return
+	}
+	lcpProto.Up(ctx)
+	<-ctx.Done()
+}
+
+// TestLoopback dials PPPoE, LCP, CHAP and IPCP against testBRAS over loopback relay, without privilege
+func TestLoopback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, brasRelay := loopback.NewRelayPair("clnt", "bras")
+	defer clntRelay.Stop()
+	defer brasRelay.Stop()
+	bras := &testBRAS{
+		Addr:      net.ParseIP("192.168.1.1").To4(),
+		PoolStart: net.ParseIP("192.168.1.100").To4(),
+		store:     auth.NewStaticStore(map[string]string{"user0": "passwd0", "user1": "passwd1"}),
+		logger:    logger.Named("bras"),
+	}
+	bras.serve(ctx, t, brasRelay, net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1})
+	setup := DefaultSetup()
+	setup.logger = logger.Named("clnt")
+	setup.NumOfClients = 2
+	setup.StartMAC = net.HardwareAddr{0x2, 0, 0, 0, 1, 0}
+	setup.MacStep = 1
+	setup.UserName = "user@ID"
+	setup.Password = "passwd@ID"
+	setup.Apply = false
+	setup.LCPRestartTimer = time.Second
+	cfglist, err := GenClientConfigurations(setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialwg := new(sync.WaitGroup)
+	dialwg.Add(len(cfglist))
+	clntList := []*ZouPPP{}
+	for _, cfg := range cfglist {
+		econn := etherconn.NewEtherConn(cfg.Mac, clntRelay,
+			etherconn.WithEtherTypes([]uint16{pppoe.EtherTypePPPoEDiscovery, pppoe.EtherTypePPPoESession}),
+			etherconn.WithVLANs(cfg.VLANs), etherconn.WithRecvMulticast(true))
+		z, err := NewZouPPP(econn, cfg, WithDialWG(dialwg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go z.Dial(ctx)
+		clntList = append(clntList, z)
+	}
+	dialwg.Wait()
+	assigned := map[string]bool{}
+	for range clntList {
+		select {
+		case r := <-setup.resultCh:
+			if r.R != ResultSuccess {
+				t.Fatalf("%v failed to dial, %v %v", r.PPPoEEP, r.Cause, r.Err)
+			}
+			if r.IPv4Addr == nil {
+				t.Fatalf("%v didn't get IPv4 address", r.PPPoEEP)
+			}
+			assigned[r.IPv4Addr.String()] = true
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for dial result")
+		}
+	}
+	for _, addr := range []string{"192.168.1.100", "192.168.1.101"} {
+		if !assigned[addr] {
+			t.Fatalf("%v is not assigned, got %v", addr, assigned)
+		}
+	}
+	for _, z := range clntList {
+		z.Close()
+	}
+}
+
+// TestLoopbackRedial kills the session from testBRAS and checks re-dial, then stops testBRAS and checks RedialMaxAttempts is honoured
+func TestLoopbackRedial(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, brasRelay := loopback.NewRelayPair("clnt", "bras")
+	defer clntRelay.Stop()
+	defer brasRelay.Stop()
+	bras := &testBRAS{
+		Addr:      net.ParseIP("192.168.1.1").To4(),
+		PoolStart: net.ParseIP("192.168.1.100").To4(),
+		store:     auth.NewStaticStore(map[string]string{"user0": "passwd0"}),
+		logger:    logger.Named("bras"),
+		sessions:  make(chan *pppoe.ACSession, 8),
+	}
+	brasCtx, brasCancel := context.WithCancel(ctx)
+	defer brasCancel()
+	bras.serve(brasCtx, t, brasRelay, net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1})
+	setup := DefaultSetup()
+	setup.logger = logger.Named("clnt")
+	setup.StartMAC = net.HardwareAddr{0x2, 0, 0, 0, 1, 0}
+	setup.UserName = "user@ID"
+	setup.Password = "passwd@ID"
+	setup.Apply = false
+	setup.LCPRestartTimer = time.Second
+	setup.Timeout = 200 * time.Millisecond
+	setup.Retry = 2
+	setup.PADIBackoff = 1
+	setup.RedialMaxAttempts = 2
+	setup.RedialBackoff = 100 * time.Millisecond
+	setup.RedialJitter = 0
+	cfglist, err := GenClientConfigurations(setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan *RedialEvent, 16)
+	econn := etherconn.NewEtherConn(cfglist[0].Mac, clntRelay,
+		etherconn.WithEtherTypes([]uint16{pppoe.EtherTypePPPoEDiscovery, pppoe.EtherTypePPPoESession}),
+		etherconn.WithRecvMulticast(true))
+	z, err := NewZouPPP(econn, cfglist[0], WithRedialHandler(func(evt *RedialEvent) { events <- evt }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	go z.Dial(ctx)
+	expectEvents := func(expected ...RedialEvent) {
+		t.Helper()
+		for _, e := range expected {
+			select {
+			case evt := <-events:
+				if evt.Type != e.Type || evt.Attempt != e.Attempt {
+					t.Fatalf("expect re-dial attempt %d %v, got %v", e.Attempt, e.Type, evt)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("timeout waiting for re-dial attempt %d %v", e.Attempt, e.Type)
+			}
+		}
+	}
+	waitSession := func() *pppoe.ACSession {
+		t.Helper()
+		select {
+		case s := <-bras.sessions:
+			return s
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for session")
+		}
+		return nil
+	}
+	// every dial attempt is reported
+	expectResult := func(expected Result) {
+		t.Helper()
+		select {
+		case r := <-setup.resultCh:
+			if r.R != expected {
+				t.Fatalf("expect dial result %v, got %v %v", expected, r.R, r.Cause)
+			}
+			if expected == ResultSuccess && !r.Phases[PhaseLCP].Finished() {
+				t.Fatalf("phase %v is not recorded", PhaseLCP)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for dial result %v", expected)
+		}
+	}
+	// BRAS terminates the session by PADT, client re-dials
+	s := waitSession()
+	expectResult(ResultSuccess)
+	s.Close()
+	waitSession()
+	expectEvents(
+		RedialEvent{Type: RedialScheduled, Attempt: 1},
+		RedialEvent{Type: RedialStarted, Attempt: 1},
+		RedialEvent{Type: RedialSucceeded, Attempt: 1},
+	)
+	expectResult(ResultSuccess)
+	// BRAS is gone, client gives up after RedialMaxAttempts
+	brasCancel()
+	expectEvents(
+		RedialEvent{Type: RedialScheduled, Attempt: 1},
+		RedialEvent{Type: RedialStarted, Attempt: 1},
+		RedialEvent{Type: RedialFailed, Attempt: 1},
+		RedialEvent{Type: RedialScheduled, Attempt: 2},
+		RedialEvent{Type: RedialStarted, Attempt: 2},
+		RedialEvent{Type: RedialFailed, Attempt: 2},
+		RedialEvent{Type: RedialExhausted, Attempt: 2},
+	)
+	if s := atomic.LoadUint32(z.state); s != StateClosed && s != StateClosing {
+		t.Fatalf("unexpected state %v after re-dial exhausted", stateStr(s))
+	}
+	expectResult(ResultFailure)
+	expectResult(ResultFailure)
+}
+
+// TestLoopbackChurn checks every churned call is reported
+func TestLoopbackChurn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, brasRelay := loopback.NewRelayPair("clnt", "bras")
+	defer clntRelay.Stop()
+	defer brasRelay.Stop()
+	bras := &testBRAS{
+		Addr:      net.ParseIP("192.168.1.1").To4(),
+		PoolStart: net.ParseIP("192.168.1.100").To4(),
+		store:     auth.NewStaticStore(map[string]string{"user0": "passwd0"}),
+		logger:    logger.Named("bras"),
+	}
+	bras.serve(ctx, t, brasRelay, net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1})
+	setup := DefaultSetup()
+	setup.logger = logger.Named("clnt")
+	setup.StartMAC = net.HardwareAddr{0x2, 0, 0, 0, 1, 0}
+	setup.UserName = "user@ID"
+	setup.Password = "passwd@ID"
+	setup.Apply = false
+	setup.LCPRestartTimer = time.Second
+	setup.Timeout = 200 * time.Millisecond
+	setup.Retry = 5
+	setup.Churn = true
+	setup.HoldTime = 100 * time.Millisecond
+	setup.churnPacer = newCallPacer(0)
+	setup.churnStats = new(ChurnStats)
+	cfglist, err := GenClientConfigurations(setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	econn := etherconn.NewEtherConn(cfglist[0].Mac, clntRelay,
+		etherconn.WithEtherTypes([]uint16{pppoe.EtherTypePPPoEDiscovery, pppoe.EtherTypePPPoESession}),
+		etherconn.WithRecvMulticast(true))
+	z, err := NewZouPPP(econn, cfglist[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	go z.Dial(ctx)
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-setup.resultCh:
+			if r.R != ResultSuccess {
+				t.Fatalf("call %d failed, %v %v", i, r.Cause, r.Err)
+			}
+			if !r.Phases[PhaseIPCP].Finished() {
+				t.Fatalf("phase %v of call %d is not recorded", PhaseIPCP, i)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for result of call %d", i)
+		}
+	}
+	if n := setup.churnStats.Redials.Load(); n < 2 {
+		t.Fatalf("expect at least 2 churned calls, got %d", n)
+	}
+}
+
+// TestLoopbackLoopedBack checks an open session is torn down by PADT when LCP detects a looped-back link
+func TestLoopbackLoopedBack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, brasRelay := loopback.NewRelayPair("clnt", "bras")
+	defer clntRelay.Stop()
+	defer brasRelay.Stop()
+	bras := &testBRAS{
+		Addr:      net.ParseIP("192.168.1.1").To4(),
+		PoolStart: net.ParseIP("192.168.1.100").To4(),
+		store:     auth.NewStaticStore(map[string]string{"user0": "passwd0"}),
+		logger:    logger.Named("bras"),
+		sessions:  make(chan *pppoe.ACSession, 1),
+	}
+	bras.serve(ctx, t, brasRelay, net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1})
+	setup := DefaultSetup()
+	setup.logger = logger.Named("clnt")
+	setup.StartMAC = net.HardwareAddr{0x2, 0, 0, 0, 1, 0}
+	setup.UserName = "user@ID"
+	setup.Password = "passwd@ID"
+	setup.Apply = false
+	setup.LCPRestartTimer = time.Second
+	cfglist, err := GenClientConfigurations(setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialwg := new(sync.WaitGroup)
+	dialwg.Add(1)
+	econn := etherconn.NewEtherConn(cfglist[0].Mac, clntRelay,
+		etherconn.WithEtherTypes([]uint16{pppoe.EtherTypePPPoEDiscovery, pppoe.EtherTypePPPoESession}),
+		etherconn.WithRecvMulticast(true))
+	z, err := NewZouPPP(econn, cfglist[0], WithDialWG(dialwg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	go z.Dial(ctx)
+	dialwg.Wait()
+	if r := <-setup.resultCh; r.R != ResultSuccess {
+		t.Fatalf("failed to dial, %v %v", r.Cause, r.Err)
+	}
+	s := <-bras.sessions
+	// LCP of the open session detects the link is looped-back
+	z.lcpEvtHandler(context.WithValue(ctx, attemptKey{}, z.current()), lcp.LCPLayerNotifyLoopedBack)
+	s.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		if _, _, err = s.ReadFrom(make([]byte, 1500)); err != nil {
+			break
+		}
+	}
+	if errors.Is(err, etherconn.ErrTimeOut) {
+		t.Fatal("session is not terminated by PADT")
+	}
+	if st := atomic.LoadUint32(z.state); st != StateClosing && st != StateClosed {
+		t.Fatalf("unexpected state %v after looped-back", stateStr(st))
+	}
+}
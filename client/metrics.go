@@ -0,0 +1,164 @@
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hujun-open/zouppp/pppoe"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultEchoRTTBuckets is the default upper bounds of LCP echo RTT histogram buckets, in seconds
+var DefaultEchoRTTBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Metrics is the set of Prometheus metrics of all ZouPPP sessions;
+// all methods are no-op if Metrics is nil
+type Metrics struct {
+	registry      *prometheus.Registry
+	sessions      *prometheus.GaugeVec
+	dialResults   *prometheus.CounterVec
+	dialFailures  *prometheus.CounterVec
+	authFailures  *prometheus.CounterVec
+	echoRTT       prometheus.Histogram
+	dialDuration  prometheus.Histogram
+	phaseDuration *prometheus.HistogramVec
+	pppoeCounters *pppoe.Counters
+}
+
+func durationBuckets(buckets []time.Duration) []float64 {
+	r := make([]float64, len(buckets))
+	for i, b := range buckets {
+		r[i] = b.Seconds()
+	}
+	return r
+}
+
+// uint64Counter returns a CounterFunc with value returned by c
+func uint64Counter(name, help string, c func() uint64) prometheus.CounterFunc {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help},
+		func() float64 { return float64(c()) })
+}
+
+// NewMetrics creates a new Metrics, latency histograms use buckets as upper bounds
+func NewMetrics(buckets []time.Duration) *Metrics {
+	r := &Metrics{
+		registry: prometheus.NewRegistry(),
+		sessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zouppp_sessions",
+			Help: "number of sessions by state"}, []string{"state"}),
+		dialResults: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "zouppp_dial_results_total",
+			Help: "number of finished dialing by result"}, []string{"result"}),
+		dialFailures: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "zouppp_dial_failures_total",
+			Help: "number of failed dialing by cause"}, []string{"cause"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "zouppp_auth_failures_total",
+			Help: "number of authentication failures by protocol"}, []string{"proto"}),
+		echoRTT: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "zouppp_lcp_echo_rtt_seconds",
+			Help: "LCP echo round trip time", Buckets: DefaultEchoRTTBuckets}),
+		dialDuration: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "zouppp_dial_duration_seconds",
+			Help: "amount of time to dial successfully", Buckets: durationBuckets(buckets)}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "zouppp_phase_duration_seconds",
+			Help: "amount of time to finish a dialing phase", Buckets: durationBuckets(buckets)}, []string{"phase"}),
+		pppoeCounters: new(pppoe.Counters),
+	}
+	for _, s := range []uint32{StateInitial, StateDialing, StateOpen, StateClosing, StateClosed} {
+		r.sessions.WithLabelValues(stateStr(s)).Set(0)
+	}
+	for _, res := range []string{ResultSuccess.String(), ResultFailure.String()} {
+		r.dialResults.WithLabelValues(res)
+	}
+	r.registry.MustRegister(
+		r.sessions,
+		r.dialResults,
+		r.dialFailures,
+		r.authFailures,
+		uint64Counter("zouppp_pppoe_padi_sent_total", "number of PADI sent", r.pppoeCounters.PADISent.Load),
+		uint64Counter("zouppp_pppoe_pado_received_total", "number of PADO received", r.pppoeCounters.PADORcvd.Load),
+		uint64Counter("zouppp_pppoe_padr_sent_total", "number of PADR sent", r.pppoeCounters.PADRSent.Load),
+		uint64Counter("zouppp_pppoe_pads_received_total", "number of PADS received", r.pppoeCounters.PADSRcvd.Load),
+		uint64Counter("zouppp_pppoe_padt_sent_total", "number of PADT sent", r.pppoeCounters.PADTSent.Load),
+		r.echoRTT,
+		r.dialDuration,
+		r.phaseDuration,
+	)
+	return r
+}
+
+// Registry returns the prometheus.Registry of all metrics
+func (m *Metrics) Registry() *prometheus.Registry {
+	if m == nil {
+		return nil
+	}
+	return m.registry
+}
+
+// Handler returns the http.Handler exposing all metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry(), promhttp.HandlerOpts{})
+}
+
+// PPPoECounters returns the PPPoE discovery pkt counters shared by all sessions
+func (m *Metrics) PPPoECounters() *pppoe.Counters {
+	if m == nil {
+		return nil
+	}
+	return m.pppoeCounters
+}
+
+// watchChurnStats exports cs as counters, no-op if cs is nil
+func (m *Metrics) watchChurnStats(cs *ChurnStats) {
+	if m == nil || cs == nil {
+		return
+	}
+	m.registry.MustRegister(
+		uint64Counter("zouppp_churn_redials_total", "number of re-dials in churn mode", cs.Redials.Load),
+		uint64Counter("zouppp_churn_succeeded_total", "number of dials reached open state in churn mode", cs.Succeeded.Load),
+		uint64Counter("zouppp_churn_failed_total", "number of failed dials in churn mode", cs.Failed.Load),
+		uint64Counter("zouppp_churn_teardowns_total", "number of sessions torn down after hold time in churn mode", cs.Teardowns.Load),
+	)
+}
+
+func (m *Metrics) sessionCreated(state uint32) {
+	if m == nil {
+		return
+	}
+	m.sessions.WithLabelValues(stateStr(state)).Inc()
+}
+
+func (m *Metrics) stateChanged(oldState, newState uint32) {
+	if m == nil || oldState == newState {
+		return
+	}
+	m.sessions.WithLabelValues(stateStr(oldState)).Dec()
+	m.sessions.WithLabelValues(stateStr(newState)).Inc()
+}
+
+func (m *Metrics) observeEchoRTT(rtt time.Duration) {
+	if m == nil {
+		return
+	}
+	m.echoRTT.Observe(rtt.Seconds())
+}
+
+func (m *Metrics) authFailed(proto string) {
+	if m == nil {
+		return
+	}
+	m.authFailures.WithLabelValues(proto).Inc()
+}
+
+func (m *Metrics) observeDialResult(r *DialResult) {
+	if m == nil {
+		return
+	}
+	m.dialResults.WithLabelValues(r.R.String()).Inc()
+	if r.R == ResultSuccess {
+		m.dialDuration.Observe(r.DialFinishTime.Sub(r.StartTime).Seconds())
+	} else {
+		m.dialFailures.WithLabelValues(r.Cause.String()).Inc()
+	}
+	for p, pt := range r.Phases {
+		if pt.Finished() {
+			m.phaseDuration.WithLabelValues(Phase(p).String()).Observe(pt.Duration().Seconds())
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	m := NewMetrics([]time.Duration{time.Second, 5 * time.Second})
+	m.sessionCreated(StateInitial)
+	m.stateChanged(StateInitial, StateDialing)
+	m.stateChanged(StateDialing, StateOpen)
+	m.authFailed("CHAP")
+	m.PPPoECounters().PADISent.Add(3)
+	now := time.Now()
+	m.observeDialResult(&DialResult{R: ResultSuccess, StartTime: now, DialFinishTime: now.Add(2 * time.Second)})
+	m.observeDialResult(&DialResult{R: ResultFailure, Cause: CausePADOTimeout})
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`zouppp_sessions{state="open"} 1`,
+		`zouppp_sessions{state="initial"} 0`,
+		`zouppp_dial_results_total{result="` + ResultSuccess.String() + `"} 1`,
+		`zouppp_dial_results_total{result="` + ResultFailure.String() + `"} 1`,
+		`zouppp_dial_failures_total{cause="` + CausePADOTimeout.String() + `"} 1`,
+		`zouppp_auth_failures_total{proto="CHAP"} 1`,
+		`zouppp_pppoe_padi_sent_total 3`,
+		`zouppp_dial_duration_seconds_bucket{le="1"} 0`,
+		`zouppp_dial_duration_seconds_bucket{le="5"} 1`,
+		`zouppp_dial_duration_seconds_count 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("%q not found in:\n%v", line, body)
+		}
+	}
+}
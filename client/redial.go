@@ -0,0 +1,195 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/hujun-open/zouppp/pppoe"
+)
+
+// default re-dial parameters
+const (
+	DefaultRedialBackoff    = time.Second
+	DefaultRedialMaxBackoff = 30 * time.Second
+	DefaultRedialJitter     = 0.2
+)
+
+// RedialEventType is the type of RedialEvent
+type RedialEventType uint8
+
+// list of RedialEventType
+const (
+	// RedialScheduled means a re-dial attempt is scheduled after a backoff delay
+	RedialScheduled RedialEventType = iota
+	// RedialStarted means a re-dial attempt starts dialing
+	RedialStarted
+	// RedialSucceeded means a re-dial attempt reaches open state
+	RedialSucceeded
+	// RedialFailed means a re-dial attempt fails
+	RedialFailed
+	// RedialExhausted means max attempts is reached, ZouPPP stops re-dialing
+	RedialExhausted
+)
+
+func (t RedialEventType) String() string {
+	switch t {
+	case RedialScheduled:
+		return "scheduled"
+	case RedialStarted:
+		return "started"
+	case RedialSucceeded:
+		return "succeeded"
+	case RedialFailed:
+		return "failed"
+	case RedialExhausted:
+		return "exhausted"
+	}
+	return fmt.Sprintf("unknown(%d)", t)
+}
+
+// RedialEvent is an event of re-dial
+type RedialEvent struct {
+	Type RedialEventType
+	// Attempt is the sequence number of the re-dial attempt, starts from 1, reset after a successful re-dial
+	Attempt int
+	// Delay is the backoff delay before the attempt
+	Delay time.Duration
+	// Time is when the event happens
+	Time time.Time
+	// DownTime is when the session went down, or the initial dialing failed
+	DownTime time.Time
+	// PPPoEEP is the PPPoE endpoint of the ZouPPP
+	PPPoEEP *pppoe.Endpoint
+}
+
+// RecoveryTime returns the duration between DownTime and the event
+func (evt *RedialEvent) RecoveryTime() time.Duration {
+	return evt.Time.Sub(evt.DownTime)
+}
+
+func (evt *RedialEvent) String() string {
+	r := fmt.Sprintf("re-dial attempt %d %v", evt.Attempt, evt.Type)
+	switch evt.Type {
+	case RedialScheduled:
+		r += fmt.Sprintf(", delay %v", evt.Delay)
+	case RedialSucceeded:
+		r += fmt.Sprintf(", recovered in %v", evt.RecoveryTime())
+	}
+	return r
+}
+
+// RedialHandler is the handler function for RedialEvent
+type RedialHandler func(evt *RedialEvent)
+
+// WithRedialHandler specifies a handler which is called for every RedialEvent;
+// by default the event is logged
+func WithRedialHandler(h RedialHandler) ZouPPPModifier {
+	return func(zou *ZouPPP) {
+		zou.redialHandler = h
+	}
+}
+
+func (setup *Setup) redialEnabled() bool {
+	return setup.RedialMaxAttempts > 0
+}
+
+// redialDelay returns the backoff delay before the specified re-dial attempt
+func (setup *Setup) redialDelay(attempt int) time.Duration {
+	d := setup.RedialBackoff
+	for i := 1; i < attempt && d < setup.RedialMaxBackoff; i++ {
+		d *= 2
+	}
+	if setup.RedialMaxBackoff > 0 && d > setup.RedialMaxBackoff {
+		d = setup.RedialMaxBackoff
+	}
+	if setup.RedialJitter > 0 {
+		d += time.Duration(float64(d) * setup.RedialJitter * (2*rand.Float64() - 1))
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+func (zou *ZouPPP) emitRedialEvent(t RedialEventType, delay time.Duration) {
+	evt := &RedialEvent{
+		Type:     t,
+		Attempt:  zou.redialAttempt,
+		Delay:    delay,
+		Time:     time.Now(),
+		DownTime: zou.downTime,
+		PPPoEEP:  zou.current().pppoeProto.LocalAddr().(*pppoe.Endpoint),
+	}
+	if zou.redialHandler != nil {
+		zou.redialHandler(evt)
+		return
+	}
+	zou.logger.Sugar().Info(evt)
+}
+
+// scheduleRedial schedules next re-dial attempt, wasOpen is true if the session went down from open state;
+// return false if no more re-dial will be done
+func (zou *ZouPPP) scheduleRedial(wasOpen bool) bool {
+	setup := zou.cfg.setup
+	if !setup.redialEnabled() {
+		return false
+	}
+	zou.redialLock.Lock()
+	defer zou.redialLock.Unlock()
+	if zou.redialAttempt > 0 {
+		zou.emitRedialEvent(RedialFailed, 0)
+	}
+	if atomic.LoadUint32(zou.closed) != 0 || zou.parentCtx == nil || zou.parentCtx.Err() != nil {
+		return false
+	}
+	if wasOpen || zou.downTime.IsZero() {
+		zou.downTime = time.Now()
+	}
+	if zou.redialAttempt >= int(setup.RedialMaxAttempts) {
+		zou.emitRedialEvent(RedialExhausted, 0)
+		return false
+	}
+	zou.redialAttempt++
+	delay := setup.redialDelay(zou.redialAttempt)
+	zou.emitRedialEvent(RedialScheduled, delay)
+	go zou.redial(delay)
+	return true
+}
+
+// redial waits for the delay then dial again
+func (zou *ZouPPP) redial(delay time.Duration) {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-zou.parentCtx.Done():
+		zou.releaseSessionWG()
+		return
+	case <-zou.closeChan:
+		zou.releaseSessionWG()
+		return
+	}
+	if atomic.LoadUint32(zou.closed) != 0 {
+		zou.releaseSessionWG()
+		return
+	}
+	// send PADT to terminate the previous PPPoE session if it is still open
+	zou.current().pppoeProto.Close()
+	zou.redialLock.Lock()
+	zou.emitRedialEvent(RedialStarted, 0)
+	zou.redialLock.Unlock()
+	zou.dial(zou.parentCtx)
+}
+
+// redialSucceed is called when reaching open state
+func (zou *ZouPPP) redialSucceed() {
+	zou.redialLock.Lock()
+	defer zou.redialLock.Unlock()
+	if zou.redialAttempt > 0 {
+		zou.emitRedialEvent(RedialSucceeded, 0)
+	}
+	zou.redialAttempt = 0
+	zou.downTime = time.Time{}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRedialDelay(t *testing.T) {
+	setup := DefaultSetup()
+	setup.RedialBackoff = time.Second
+	setup.RedialMaxBackoff = 10 * time.Second
+	setup.RedialJitter = 0
+	for attempt, expected := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if d := setup.redialDelay(attempt); d != expected {
+			t.Fatalf("attempt %d, expect delay %v, got %v", attempt, expected, d)
+		}
+	}
+	setup.RedialJitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := setup.redialDelay(2)
+		if d < time.Second || d > 3*time.Second {
+			t.Fatalf("delay %v is out of jitter range", d)
+		}
+	}
+}
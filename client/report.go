@@ -0,0 +1,376 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report is the result report, including summary and a record for each session
+type Report struct {
+	Summary  *SummaryRecord   `json:"summary"`
+	Sessions []*SessionRecord `json:"sessions"`
+}
+
+// BucketRecord is the record of a HistogramBucket
+type BucketRecord struct {
+	UpperBoundMS float64 `json:"le_ms"`
+	Count        uint    `json:"count"`
+}
+
+// LatencyRecord is the record of LatencyStats
+type LatencyRecord struct {
+	Count     uint           `json:"count"`
+	P50MS     float64        `json:"p50_ms"`
+	P90MS     float64        `json:"p90_ms"`
+	P99MS     float64        `json:"p99_ms"`
+	P999MS    float64        `json:"p99.9_ms"`
+	Histogram []BucketRecord `json:"histogram"`
+	Overflow  uint           `json:"overflow"`
+}
+
+// PhaseSummaryRecord is the record of PhaseSummary
+type PhaseSummaryRecord struct {
+	Finished  uint          `json:"finished"`
+	FastestMS float64       `json:"fastest_ms"`
+	AvgMS     float64       `json:"avg_ms"`
+	SlowestMS float64       `json:"slowest_ms"`
+	Latency   LatencyRecord `json:"latency"`
+}
+
+// SummaryRecord is the record of ResultSummary
+type SummaryRecord struct {
+	Total            uint                          `json:"total"`
+	Success          uint                          `json:"success"`
+	Failed           uint                          `json:"failed"`
+	SuccessWithin10s uint                          `json:"success_within_10s"`
+	DurationMS       float64                       `json:"duration_ms"`
+	SetupRate        float64                       `json:"setup_rate"`
+	FastestSuccessMS float64                       `json:"fastest_success_ms"`
+	SlowestSuccessMS float64                       `json:"slowest_success_ms"`
+	AvgSuccessMS     float64                       `json:"avg_success_ms"`
+	Latency          LatencyRecord                 `json:"latency"`
+	Phases           map[string]PhaseSummaryRecord `json:"phases"`
+	FailureCauses    map[string]uint               `json:"failure_causes"`
+	SelectedACs      map[string]uint               `json:"selected_acs,omitempty"`
+}
+
+// PhaseRecord is the record of PhaseTiming
+type PhaseRecord struct {
+	Start      time.Time  `json:"start"`
+	End        *time.Time `json:"end,omitempty"`
+	DurationMS float64    `json:"duration_ms"`
+}
+
+// OfferRecord is the record of a pppoe.Offer
+type OfferRecord struct {
+	ACName  string  `json:"ac_name"`
+	ACMAC   string  `json:"ac_mac"`
+	DelayMS float64 `json:"delay_ms"`
+}
+
+func (or OfferRecord) String() string {
+	return fmt.Sprintf("%v@%v:%v", or.ACName, or.ACMAC, fmtMS(or.DelayMS))
+}
+
+// SessionRecord is the record of a session's DialResult
+type SessionRecord struct {
+	Endpoint   string                 `json:"endpoint"`
+	MAC        string                 `json:"mac"`
+	VLANs      []uint16               `json:"vlans"`
+	SessionID  uint16                 `json:"session_id"`
+	IPv4       string                 `json:"ipv4,omitempty"`
+	IANAs      []string               `json:"iana,omitempty"`
+	IAPDs      []string               `json:"iapd,omitempty"`
+	Result     string                 `json:"result"`
+	Cause      string                 `json:"cause,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	StartTime  time.Time              `json:"start"`
+	FinishTime time.Time              `json:"finish"`
+	DialMS     float64                `json:"dial_ms"`
+	Phases     map[string]PhaseRecord `json:"phases"`
+	ACName     string                 `json:"ac_name,omitempty"`
+	ACMAC      string                 `json:"ac_mac,omitempty"`
+	Offers     []OfferRecord          `json:"offers,omitempty"`
+}
+
+func toMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func newLatencyRecord(ls LatencyStats) LatencyRecord {
+	r := LatencyRecord{
+		Count:     ls.Count,
+		P50MS:     toMS(ls.P50),
+		P90MS:     toMS(ls.P90),
+		P99MS:     toMS(ls.P99),
+		P999MS:    toMS(ls.P999),
+		Histogram: []BucketRecord{},
+		Overflow:  ls.Overflow,
+	}
+	for _, b := range ls.Histogram {
+		r.Histogram = append(r.Histogram, BucketRecord{UpperBoundMS: toMS(b.UpperBound), Count: b.Count})
+	}
+	return r
+}
+
+// newSummaryRecord returns a SummaryRecord of rs
+func newSummaryRecord(rs *ResultSummary) *SummaryRecord {
+	r := &SummaryRecord{
+		Total:            rs.Total,
+		Success:          rs.Success,
+		Failed:           rs.Failed,
+		SuccessWithin10s: rs.LessThanTenSecond,
+		DurationMS:       toMS(rs.TotalTime),
+		SetupRate:        rs.SetupRate(),
+		FastestSuccessMS: toMS(rs.Shortest),
+		SlowestSuccessMS: toMS(rs.Longest),
+		AvgSuccessMS:     toMS(rs.AvgSuccessTime),
+		Latency:          newLatencyRecord(rs.Latency),
+		Phases:           make(map[string]PhaseSummaryRecord),
+		FailureCauses:    make(map[string]uint),
+		SelectedACs:      rs.SelectedACs,
+	}
+	for p, ps := range rs.Phases {
+		if ps.Finished == 0 {
+			continue
+		}
+		r.Phases[Phase(p).String()] = PhaseSummaryRecord{
+			Finished:  ps.Finished,
+			FastestMS: toMS(ps.Shortest),
+			AvgMS:     toMS(ps.Avg()),
+			SlowestMS: toMS(ps.Longest),
+			Latency:   newLatencyRecord(ps.Latency),
+		}
+	}
+	for c, n := range rs.Causes {
+		if n > 0 {
+			r.FailureCauses[FailureCause(c).String()] = n
+		}
+	}
+	return r
+}
+
+// newSessionRecord returns a SessionRecord of dr
+func newSessionRecord(dr *DialResult) *SessionRecord {
+	r := &SessionRecord{
+		Result:     dr.R.String(),
+		StartTime:  dr.StartTime,
+		FinishTime: dr.DialFinishTime,
+		DialMS:     toMS(dr.DialFinishTime.Sub(dr.StartTime)),
+		Phases:     make(map[string]PhaseRecord),
+		VLANs:      []uint16{},
+	}
+	if dr.PPPoEEP != nil {
+		r.Endpoint = dr.PPPoEEP.String()
+		r.SessionID = dr.PPPoEEP.SessionID
+		if dr.PPPoEEP.L2EP != nil {
+			r.MAC = dr.PPPoEEP.L2EP.HwAddr.String()
+			r.VLANs = append(r.VLANs, dr.PPPoEEP.L2EP.VLANs...)
+		}
+	}
+	if dr.R != ResultSuccess {
+		r.Cause = dr.Cause.String()
+		if dr.Err != nil {
+			r.Error = dr.Err.Error()
+		}
+	}
+	if dr.IPv4Addr != nil {
+		r.IPv4 = dr.IPv4Addr.String()
+	}
+	for _, addr := range dr.IANAs {
+		r.IANAs = append(r.IANAs, addr.String())
+	}
+	for _, prefix := range dr.IAPDs {
+		r.IAPDs = append(r.IAPDs, prefix.String())
+	}
+	if dr.SelectedOffer != nil {
+		r.ACName = dr.SelectedOffer.ACName
+		r.ACMAC = dr.SelectedOffer.ACMAC.String()
+	}
+	for _, offer := range dr.Offers {
+		r.Offers = append(r.Offers, OfferRecord{
+			ACName:  offer.ACName,
+			ACMAC:   offer.ACMAC.String(),
+			DelayMS: toMS(offer.Delay),
+		})
+	}
+	for p, pt := range dr.Phases {
+		if pt.Start.IsZero() {
+			continue
+		}
+		pr := PhaseRecord{
+			Start:      pt.Start,
+			DurationMS: toMS(pt.Duration()),
+		}
+		if pt.Finished() {
+			end := pt.End
+			pr.End = &end
+		}
+		r.Phases[Phase(p).String()] = pr
+	}
+	return r
+}
+
+// NewReport returns a Report of rs
+func NewReport(rs *ResultSummary) *Report {
+	r := &Report{
+		Summary:  newSummaryRecord(rs),
+		Sessions: []*SessionRecord{},
+	}
+	for _, dr := range rs.Results {
+		r.Sessions = append(r.Sessions, newSessionRecord(dr))
+	}
+	return r
+}
+
+// WriteJSON writes the report in JSON to w
+func (rpt *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rpt)
+}
+
+func fmtMS(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+// WriteSessionCSV writes session records in CSV to w, one row for each session
+func (rpt *Report) WriteSessionCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"endpoint", "mac", "vlans", "session_id", "ipv4", "iana", "iapd",
+		"result", "cause", "error", "start", "dial_ms", "ac_name", "ac_mac", "offers"}
+	for p := Phase(0); p < NumOfPhases; p++ {
+		header = append(header, strings.ToLower(p.String())+"_ms")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, s := range rpt.Sessions {
+		vlans := []string{}
+		for _, v := range s.VLANs {
+			vlans = append(vlans, strconv.Itoa(int(v)))
+		}
+		offers := []string{}
+		for _, offer := range s.Offers {
+			offers = append(offers, offer.String())
+		}
+		row := []string{s.Endpoint, s.MAC, strings.Join(vlans, "|"), fmt.Sprintf("%d", s.SessionID),
+			s.IPv4, strings.Join(s.IANAs, "|"), strings.Join(s.IAPDs, "|"),
+			s.Result, s.Cause, s.Error, s.StartTime.Format(time.RFC3339Nano), fmtMS(s.DialMS),
+			s.ACName, s.ACMAC, strings.Join(offers, "|")}
+		for p := Phase(0); p < NumOfPhases; p++ {
+			pr, ok := s.Phases[p.String()]
+			if !ok || pr.End == nil {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, fmtMS(pr.DurationMS))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteSummaryCSV writes the summary in CSV to w, each row is a metric name and its value
+func (rpt *Report) WriteSummaryCSV(w io.Writer) error {
+	sum := rpt.Summary
+	rows := [][]string{
+		{"metric", "value"},
+		{"total", fmt.Sprintf("%d", sum.Total)},
+		{"success", fmt.Sprintf("%d", sum.Success)},
+		{"failed", fmt.Sprintf("%d", sum.Failed)},
+		{"success_within_10s", fmt.Sprintf("%d", sum.SuccessWithin10s)},
+		{"duration_ms", fmtMS(sum.DurationMS)},
+		{"setup_rate", strconv.FormatFloat(sum.SetupRate, 'f', -1, 64)},
+		{"fastest_success_ms", fmtMS(sum.FastestSuccessMS)},
+		{"slowest_success_ms", fmtMS(sum.SlowestSuccessMS)},
+		{"avg_success_ms", fmtMS(sum.AvgSuccessMS)},
+	}
+	latencyRows := func(prefix string, lr LatencyRecord) {
+		rows = append(rows,
+			[]string{prefix + "p50_ms", fmtMS(lr.P50MS)},
+			[]string{prefix + "p90_ms", fmtMS(lr.P90MS)},
+			[]string{prefix + "p99_ms", fmtMS(lr.P99MS)},
+			[]string{prefix + "p99.9_ms", fmtMS(lr.P999MS)},
+		)
+		for _, b := range lr.Histogram {
+			rows = append(rows, []string{fmt.Sprintf("%shistogram_le_%v_ms", prefix, b.UpperBoundMS), fmt.Sprintf("%d", b.Count)})
+		}
+		rows = append(rows, []string{prefix + "histogram_overflow", fmt.Sprintf("%d", lr.Overflow)})
+	}
+	latencyRows("", sum.Latency)
+	for p := Phase(0); p < NumOfPhases; p++ {
+		ps, ok := sum.Phases[p.String()]
+		if !ok {
+			continue
+		}
+		prefix := strings.ToLower(p.String()) + "_"
+		rows = append(rows,
+			[]string{prefix + "finished", fmt.Sprintf("%d", ps.Finished)},
+			[]string{prefix + "fastest_ms", fmtMS(ps.FastestMS)},
+			[]string{prefix + "avg_ms", fmtMS(ps.AvgMS)},
+			[]string{prefix + "slowest_ms", fmtMS(ps.SlowestMS)},
+		)
+		latencyRows(prefix, ps.Latency)
+	}
+	for c := FailureCause(0); c < NumOfCauses; c++ {
+		if n, ok := sum.FailureCauses[c.String()]; ok {
+			rows = append(rows, []string{"failed_by_" + strings.ReplaceAll(c.String(), " ", "_"), fmt.Sprintf("%d", n)})
+		}
+	}
+	acs := []string{}
+	for ac := range sum.SelectedACs {
+		acs = append(acs, ac)
+	}
+	sort.Strings(acs)
+	for _, ac := range acs {
+		rows = append(rows, []string{"selected_ac_" + ac, fmt.Sprintf("%d", sum.SelectedACs[ac])})
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// summaryCSVPath returns the path of summary CSV file for the session CSV file path
+func summaryCSVPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_summary" + ext
+}
+
+// WriteReport writes the report of rs to file path;
+// if file extension of path is .csv, session records are written to path in CSV,
+// and summary is written to <path_without_ext>_summary.csv; otherwise the report is written to path in JSON
+func WriteReport(path string, rs *ResultSummary) error {
+	rpt := NewReport(rs)
+	writeFile := func(path string, writef func(w io.Writer) error) error {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create report file %v, %w", path, err)
+		}
+		defer f.Close()
+		if err = writef(f); err != nil {
+			return fmt.Errorf("failed to write report file %v, %w", path, err)
+		}
+		return nil
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		if err := writeFile(path, rpt.WriteSessionCSV); err != nil {
+			return err
+		}
+		return writeFile(summaryCSVPath(path), rpt.WriteSummaryCSV)
+	}
+	return writeFile(path, rpt.WriteJSON)
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+	"github.com/hujun-open/zouppp/pppoe"
+)
+
+func TestReport(t *testing.T) {
+	setup := DefaultSetup()
+	setup.NumOfClients = 2
+	start := time.Now()
+	ok := &DialResult{
+		R: ResultSuccess,
+		PPPoEEP: &pppoe.Endpoint{
+			L2EP:      &etherconn.L2Endpoint{HwAddr: net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1}, VLANs: []uint16{100, 200}},
+			SessionID: 0x10,
+		},
+		StartTime:      start,
+		DialFinishTime: start.Add(time.Second),
+		IPv4Addr:       net.ParseIP("10.0.0.1"),
+		IANAs:          []net.IP{net.ParseIP("2001:db8::1")},
+	}
+	ok.Phases[PhasePADO] = PhaseTiming{Start: start, End: start.Add(100 * time.Millisecond)}
+	ok.Offers = []*pppoe.Offer{
+		{ACName: "ac1", ACMAC: net.HardwareAddr{0x2, 0, 0, 0, 1, 0x1}, Delay: 10 * time.Millisecond},
+		{ACName: "ac2", ACMAC: net.HardwareAddr{0x2, 0, 0, 0, 1, 0x2}, Delay: 20 * time.Millisecond},
+	}
+	ok.SelectedOffer = ok.Offers[1]
+	failed := &DialResult{
+		R: ResultFailure,
+		PPPoEEP: &pppoe.Endpoint{
+			L2EP: &etherconn.L2Endpoint{HwAddr: net.HardwareAddr{0x2, 0, 0, 0, 0, 0x2}},
+		},
+		StartTime:      start,
+		DialFinishTime: start.Add(9 * time.Second),
+		Cause:          CausePADOTimeout,
+	}
+	failed.Phases[PhasePADO] = PhaseTiming{Start: start}
+	setup.resultCh <- ok
+	setup.resultCh <- failed
+	ch := make(chan *ResultSummary, 1)
+	CollectResults(setup, ch)
+	summary := <-ch
+	dir := t.TempDir()
+	// JSON
+	jpath := filepath.Join(dir, "report.json")
+	if err := WriteReport(jpath, summary); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(jpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpt := new(Report)
+	if err = json.Unmarshal(buf, rpt); err != nil {
+		t.Fatal(err)
+	}
+	if rpt.Summary.Total != 2 || rpt.Summary.FailureCauses[CausePADOTimeout.String()] != 1 || len(rpt.Sessions) != 2 {
+		t.Fatalf("unexpected report:\n%v", string(buf))
+	}
+	s := rpt.Sessions[0]
+	if s.MAC != "02:00:00:00:00:01" || s.SessionID != 0x10 || s.IPv4 != "10.0.0.1" ||
+		len(s.VLANs) != 2 || s.DialMS != 1000 || s.Phases["PADO"].DurationMS != 100 ||
+		s.ACName != "ac2" || len(s.Offers) != 2 || rpt.Summary.SelectedACs["ac2(02:00:00:00:01:02)"] != 1 {
+		t.Fatalf("unexpected session record %+v", s)
+	}
+	if rpt.Sessions[1].Cause != CausePADOTimeout.String() || rpt.Sessions[1].Phases["PADO"].End != nil {
+		t.Fatalf("unexpected session record %+v", rpt.Sessions[1])
+	}
+	// CSV
+	cpath := filepath.Join(dir, "report.csv")
+	if err = WriteReport(cpath, summary); err != nil {
+		t.Fatal(err)
+	}
+	for path, rows := range map[string]int{cpath: 3, filepath.Join(dir, "report_summary.csv"): 0} {
+		buf, err = os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(bytes.NewReader(buf)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows > 0 && len(records) != rows {
+			t.Fatalf("expect %d rows in %v, got %d", rows, path, len(records))
+		}
+		if len(records) < 2 {
+			t.Fatalf("%v is empty", path)
+		}
+	}
+}
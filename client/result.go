@@ -0,0 +1,318 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hujun-open/zouppp/pppoe"
+)
+
+// Phase is a phase of dialing
+type Phase uint8
+
+// list of dialing phases
+const (
+	// PhasePADO is from sending PADI to receiving PADO
+	PhasePADO Phase = iota
+	// PhasePADS is from receiving PADO to receiving PADS
+	PhasePADS
+	// PhaseLCP is from PPPoE session established to LCP opened
+	PhaseLCP
+	// PhaseAuth is from LCP opened to authentication succeeded
+	PhaseAuth
+	// PhaseIPCP is from authentication succeeded to IPCP opened
+	PhaseIPCP
+	// PhaseIPv6CP is from authentication succeeded to IPv6CP opened
+	PhaseIPv6CP
+	// PhaseDHCPv6 is from IPv6CP opened to DHCPv6 finished
+	PhaseDHCPv6
+	// NumOfPhases is the number of phases
+	NumOfPhases
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhasePADO:
+		return "PADO"
+	case PhasePADS:
+		return "PADS"
+	case PhaseLCP:
+		return "LCP"
+	case PhaseAuth:
+		return "Auth"
+	case PhaseIPCP:
+		return "IPCP"
+	case PhaseIPv6CP:
+		return "IPv6CP"
+	case PhaseDHCPv6:
+		return "DHCPv6"
+	}
+	return fmt.Sprintf("unknown phase(%d)", p)
+}
+
+// PhaseTiming records start and end time of a dialing phase
+type PhaseTiming struct {
+	// Start is when the phase starts, zero if the phase is not started
+	Start time.Time
+	// End is when the phase finishes successfully, zero if the phase doesn't finish
+	End time.Time
+}
+
+// Finished returns true if the phase finishes successfully
+func (pt PhaseTiming) Finished() bool {
+	return !pt.Start.IsZero() && !pt.End.IsZero()
+}
+
+// Duration returns the amount of time the phase takes, 0 if the phase doesn't finish
+func (pt PhaseTiming) Duration() time.Duration {
+	if !pt.Finished() || pt.End.Before(pt.Start) {
+		return 0
+	}
+	return pt.End.Sub(pt.Start)
+}
+
+// FailureCause is the cause of dialing failure
+type FailureCause uint8
+
+// list of FailureCause
+const (
+	// CauseNone means dialing succeed
+	CauseNone FailureCause = iota
+	// CausePADOTimeout means no PADO received
+	CausePADOTimeout
+	// CausePADSTimeout means no PADS received
+	CausePADSTimeout
+	// CausePADSRejected means AC rejected PADR without error tag
+	CausePADSRejected
+	// CauseServiceNameError means AC rejected PADR with Service-Name-Error tag
+	CauseServiceNameError
+	// CauseACSystemError means AC rejected PADR with AC-System-Error tag
+	CauseACSystemError
+	// CauseGenericError means AC rejected PADR with Generic-Error tag
+	CauseGenericError
+	// CauseLCPTimeout means LCP failed to open
+	CauseLCPTimeout
+	// CauseLCPDown means LCP went down before dialing finishes
+	CauseLCPDown
+	// CauseLCPLoopedBack means the link is detected as looped-back by LCP magic number
+	CauseLCPLoopedBack
+	// CausePADTReceived means AC terminated the session by PADT before dialing finishes
+	CausePADTReceived
+	// CauseAuthFailed means authentication failed
+	CauseAuthFailed
+	// CauseIPCPFailed means IPCP failed to open
+	CauseIPCPFailed
+	// CauseIPv6CPFailed means IPv6CP failed to open
+	CauseIPv6CPFailed
+	// CauseDHCPv6NoAdvertise means no DHCPv6 advertise received
+	CauseDHCPv6NoAdvertise
+	// CauseDHCPv6Failed means DHCPv6 failed other than no advertise
+	CauseDHCPv6Failed
+	// CauseCancelled means dialing is cancelled
+	CauseCancelled
+	// CauseOther means other failures
+	CauseOther
+	// NumOfCauses is the number of causes
+	NumOfCauses
+)
+
+func (c FailureCause) String() string {
+	switch c {
+	case CauseNone:
+		return "none"
+	case CausePADOTimeout:
+		return "PADO timeout"
+	case CausePADSTimeout:
+		return "PADS timeout"
+	case CausePADSRejected:
+		return "PADS rejected"
+	case CauseServiceNameError:
+		return "Service-Name error"
+	case CauseACSystemError:
+		return "AC-System error"
+	case CauseGenericError:
+		return "Generic error"
+	case CauseLCPTimeout:
+		return "LCP timeout"
+	case CauseLCPDown:
+		return "LCP down"
+	case CauseLCPLoopedBack:
+		return "LCP looped-back"
+	case CausePADTReceived:
+		return "PADT received"
+	case CauseAuthFailed:
+		return "auth failed"
+	case CauseIPCPFailed:
+		return "IPCP failed"
+	case CauseIPv6CPFailed:
+		return "IPv6CP failed"
+	case CauseDHCPv6NoAdvertise:
+		return "DHCPv6 no advertise"
+	case CauseDHCPv6Failed:
+		return "DHCPv6 failed"
+	case CauseCancelled:
+		return "cancelled"
+	case CauseOther:
+		return "other"
+	}
+	return fmt.Sprintf("unknown cause(%d)", c)
+}
+
+// pppoeFailureCause returns the FailureCause of error returned by pppoe.Dial
+func pppoeFailureCause(err error) FailureCause {
+	var terr *pppoe.TagError
+	if errors.As(err, &terr) {
+		switch terr.Type {
+		case pppoe.TagTypeServiceNameError:
+			return CauseServiceNameError
+		case pppoe.TagTypeACSystemError:
+			return CauseACSystemError
+		case pppoe.TagTypeGenericError:
+			return CauseGenericError
+		}
+	}
+	switch {
+	case errors.Is(err, pppoe.ErrPADOTimeout):
+		return CausePADOTimeout
+	case errors.Is(err, pppoe.ErrPADSTimeout):
+		return CausePADSTimeout
+	case errors.Is(err, pppoe.ErrPADSRejected):
+		return CausePADSRejected
+	}
+	return CauseOther
+}
+
+// dhcp6FailureCause returns the FailureCause of error returned by DHCP6Clnt.Dial
+func dhcp6FailureCause(err error) FailureCause {
+	if errors.Is(err, ErrDHCPv6NoAdvertise) {
+		return CauseDHCPv6NoAdvertise
+	}
+	return CauseDHCPv6Failed
+}
+
+// PhaseSummary is the summary stats of a dialing phase
+type PhaseSummary struct {
+	// Finished is the number of sessions finished the phase
+	Finished uint
+	// Shortest is the shortest amount of time to finish the phase
+	Shortest time.Duration
+	// Longest is the longest amount of time to finish the phase
+	Longest time.Duration
+	// Total is the total amount of time of all sessions finished the phase
+	Total time.Duration
+	// Latency is the latency distribution of the phase
+	Latency LatencyStats
+	samples []time.Duration
+}
+
+// Avg returns the average amount of time to finish the phase
+func (ps PhaseSummary) Avg() time.Duration {
+	if ps.Finished == 0 {
+		return 0
+	}
+	return ps.Total / time.Duration(ps.Finished)
+}
+
+func (ps *PhaseSummary) add(d time.Duration) {
+	if ps.Finished == 0 || d < ps.Shortest {
+		ps.Shortest = d
+	}
+	if d > ps.Longest {
+		ps.Longest = d
+	}
+	ps.Total += d
+	ps.Finished++
+	ps.samples = append(ps.samples, d)
+}
+
+// DefaultHistogramBuckets is the default upper bounds of latency histogram buckets
+var DefaultHistogramBuckets = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// HistogramBucket is a bucket of latency histogram
+type HistogramBucket struct {
+	// UpperBound is the inclusive upper bound of the bucket
+	UpperBound time.Duration
+	// Count is the number of samples greater than previous bucket's UpperBound and no greater than UpperBound
+	Count uint
+}
+
+// LatencyStats is the latency distribution of a set of samples
+type LatencyStats struct {
+	// Count is the number of samples
+	Count uint
+	// P50, P90, P99 and P999 are the 50th, 90th, 99th and 99.9th percentile
+	P50, P90, P99, P999 time.Duration
+	// Histogram is the list of buckets, in the order of UpperBound
+	Histogram []HistogramBucket
+	// Overflow is the number of samples greater than UpperBound of the last bucket
+	Overflow uint
+}
+
+// percentile returns the permille-th (0 < permille <= 1000) percentile of sorted samples, using nearest-rank method
+func percentile(sorted []time.Duration, permille int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (permille*len(sorted) + 999) / 1000
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// newLatencyStats returns a LatencyStats of samples, with histogram buckets specified by upper bounds in buckets
+func newLatencyStats(samples []time.Duration, buckets []time.Duration) LatencyStats {
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	bounds := make([]time.Duration, len(buckets))
+	copy(bounds, buckets)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	r := LatencyStats{
+		Count:     uint(len(sorted)),
+		P50:       percentile(sorted, 500),
+		P90:       percentile(sorted, 900),
+		P99:       percentile(sorted, 990),
+		P999:      percentile(sorted, 999),
+		Histogram: make([]HistogramBucket, len(bounds)),
+	}
+	for i, b := range bounds {
+		r.Histogram[i].UpperBound = b
+	}
+	for _, d := range sorted {
+		i := sort.Search(len(bounds), func(i int) bool { return bounds[i] >= d })
+		if i == len(bounds) {
+			r.Overflow++
+			continue
+		}
+		r.Histogram[i].Count++
+	}
+	return r
+}
+
+// PercentileString returns the percentiles in a single line
+func (ls LatencyStats) PercentileString() string {
+	return fmt.Sprintf("p50:%v p90:%v p99:%v p99.9:%v", ls.P50, ls.P90, ls.P99, ls.P999)
+}
+
+// HistogramString returns the histogram in a single line
+func (ls LatencyStats) HistogramString() string {
+	r := ""
+	for _, b := range ls.Histogram {
+		r += fmt.Sprintf("<=%v:%d ", b.UpperBound, b.Count)
+	}
+	if len(ls.Histogram) > 0 {
+		r += fmt.Sprintf(">%v:%d", ls.Histogram[len(ls.Histogram)-1].UpperBound, ls.Overflow)
+	}
+	return r
+}
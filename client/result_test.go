@@ -0,0 +1,101 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollectResults(t *testing.T) {
+	setup := DefaultSetup()
+	setup.NumOfClients = 3
+	start := time.Now()
+	phases := func(ds ...time.Duration) (r [NumOfPhases]PhaseTiming) {
+		t := start
+		for i, d := range ds {
+			r[i] = PhaseTiming{Start: t, End: t.Add(d)}
+			t = t.Add(d)
+		}
+		return
+	}
+	setup.resultCh <- &DialResult{
+		R:              ResultSuccess,
+		StartTime:      start,
+		DialFinishTime: start.Add(time.Second),
+		Phases:         phases(100*time.Millisecond, 200*time.Millisecond, 300*time.Millisecond),
+	}
+	setup.resultCh <- &DialResult{
+		R:              ResultSuccess,
+		StartTime:      start,
+		DialFinishTime: start.Add(2 * time.Second),
+		Phases:         phases(300*time.Millisecond, 400*time.Millisecond),
+	}
+	failed := &DialResult{
+		R:              ResultFailure,
+		StartTime:      start,
+		DialFinishTime: start.Add(3 * time.Second),
+		Cause:          CausePADSTimeout,
+	}
+	failed.Phases[PhasePADO] = PhaseTiming{Start: start, End: start.Add(200 * time.Millisecond)}
+	failed.Phases[PhasePADS] = PhaseTiming{Start: start.Add(200 * time.Millisecond)}
+	setup.resultCh <- failed
+	ch := make(chan *ResultSummary, 1)
+	CollectResults(setup, ch)
+	summary := <-ch
+	if summary.Success != 2 || summary.Failed != 1 || summary.Causes[CausePADSTimeout] != 1 {
+		t.Fatalf("unexpected summary:\n%v", summary)
+	}
+	pado := summary.Phases[PhasePADO]
+	if pado.Finished != 3 || pado.Shortest != 100*time.Millisecond ||
+		pado.Longest != 300*time.Millisecond || pado.Avg() != 200*time.Millisecond {
+		t.Fatalf("unexpected PADO phase summary %+v", pado)
+	}
+	if summary.Phases[PhasePADS].Finished != 2 || summary.Phases[PhaseLCP].Finished != 1 {
+		t.Fatalf("unexpected phase summary %+v", summary.Phases)
+	}
+	if summary.Latency.P50 != time.Second || summary.Latency.P99 != 2*time.Second {
+		t.Fatalf("unexpected latency %v", summary.Latency.PercentileString())
+	}
+	if summary.Phases[PhasePADO].Latency.P90 != 300*time.Millisecond {
+		t.Fatalf("unexpected PADO latency %v", summary.Phases[PhasePADO].Latency.PercentileString())
+	}
+	// with re-dial, results are collected until setup is closed
+	setup = DefaultSetup()
+	setup.NumOfClients = 1
+	setup.RedialMaxAttempts = 1
+	setup.resultCh <- failed
+	setup.resultCh <- &DialResult{R: ResultSuccess, StartTime: start, DialFinishTime: start.Add(time.Second)}
+	setup.Close()
+	CollectResults(setup, ch)
+	if summary = <-ch; summary.Total != 2 || summary.Success != 1 || summary.Failed != 1 {
+		t.Fatalf("unexpected re-dial summary:\n%v", summary)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	samples := []time.Duration{}
+	for i := 1000; i > 0; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	ls := newLatencyStats(samples, []time.Duration{time.Second, 100 * time.Millisecond, 500 * time.Millisecond})
+	if ls.Count != 1000 || ls.P50 != 500*time.Millisecond || ls.P90 != 900*time.Millisecond ||
+		ls.P99 != 990*time.Millisecond || ls.P999 != 999*time.Millisecond {
+		t.Fatalf("unexpected percentiles %v", ls.PercentileString())
+	}
+	expected := []HistogramBucket{
+		{UpperBound: 100 * time.Millisecond, Count: 100},
+		{UpperBound: 500 * time.Millisecond, Count: 400},
+		{UpperBound: time.Second, Count: 500},
+	}
+	for i, b := range expected {
+		if ls.Histogram[i] != b {
+			t.Fatalf("unexpected histogram %v", ls.HistogramString())
+		}
+	}
+	if ls.Overflow != 0 {
+		t.Fatalf("unexpected overflow %d", ls.Overflow)
+	}
+	ls = newLatencyStats([]time.Duration{2 * time.Second}, []time.Duration{time.Second})
+	if ls.P50 != 2*time.Second || ls.P999 != 2*time.Second || ls.Overflow != 1 {
+		t.Fatalf("unexpected stats %+v", ls)
+	}
+}
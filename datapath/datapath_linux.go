@@ -31,7 +31,7 @@ type TUNIF struct {
 const DefaultMaxFrameSize = 1500
 
 // NewTUNIf creates a new TUN interface the pppproto, using name as interface name, add ifv4addr to the TUN interface;
-// also creates an IPv6 link local address via v6ifid, set MTU to peermru;
+// also creates an IPv6 link local address via v6ifid, set MTU to peermru, which could be bigger than 1500 with RFC4638;
 func NewTUNIf(ctx context.Context, pppproto *lcp.PPP, name string, assignedAddrs []net.IP, v6ifid []byte, peermru uint16) (*TUNIF, error) {
 	var err error
 	r := new(TUNIF)
@@ -111,12 +111,21 @@ func NewTUNIf(ctx context.Context, pppproto *lcp.PPP, name string, assignedAddrs
 	netlink.LinkSetMTU(r.nlink, mtu)
 
 	r.maxFrameSize = DefaultMaxFrameSize
+	if mtu > r.maxFrameSize {
+		// e.g. baby jumbo frame with RFC4638 PPP-Max-Payload
+		r.maxFrameSize = mtu
+	}
 	r.logger = pppproto.GetLogger().Named("datapath")
 	go r.send(ctx)
 	go r.recv(ctx)
 	return r, nil
 }
 
+// Close closes the TUN interface, which also removes it
+func (tif *TUNIF) Close() error {
+	return tif.intf.Close()
+}
+
 const minimalIPPktSize = 20 //ipv4 header
 
 // send pkt to outside network
@@ -0,0 +1,261 @@
+// Package eap implements the peer side of EAP as specified in RFC3748, with EAP-MD5 and EAP-TLS (RFC5216) methods
+package eap
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/hujun-open/zouppp/lcp"
+
+	"go.uber.org/zap"
+)
+
+// EAP is the peer side of EAP protocol
+type EAP struct {
+	identity  string
+	passwd    string
+	tlsConfig *tls.Config
+	fragSize  int
+	sendChan  chan []byte
+	recvChan  chan []byte
+	logger    *zap.Logger
+	timeout   time.Duration
+	lastReqID uint8
+	lastResp  *Pkt
+	tls       *tlsPeer
+}
+
+// tlsPeer is the state of an ongoing EAP-TLS exchange
+type tlsPeer struct {
+	conn        *tlsConn
+	hsDone      chan error
+	reassembler *tlsReassembler
+	outFrags    [][]byte
+	done        bool
+}
+
+// DefaultTimeout is the default timeout for EAP authentication
+const DefaultTimeout = 30 * time.Second
+
+// Modifier is a function to provide custom configuration when creating new EAP instances
+type Modifier func(eap *EAP)
+
+// WithTLSConfig specifies the tls.Config used by EAP-TLS, e.g. created by LoadTLSConfig;
+// EAP-TLS is not supported if this is not specified
+func WithTLSConfig(cfg *tls.Config) Modifier {
+	return func(eap *EAP) {
+		eap.tlsConfig = cfg
+	}
+}
+
+// WithTimeout specifies the timeout of whole EAP authentication
+func WithTimeout(t time.Duration) Modifier {
+	return func(eap *EAP) {
+		eap.timeout = t
+	}
+}
+
+// NewEAP creates a new EAP instance with specified identity, passwd is used by EAP-MD5;
+// using pppProto as underlying PPP protocol;
+// optionally Modifer could provide custom configurations;
+func NewEAP(identity, passwd string, pppProto *lcp.PPP, options ...Modifier) *EAP {
+	r := new(EAP)
+	r.identity = identity
+	r.passwd = passwd
+	r.sendChan, r.recvChan = pppProto.Register(lcp.ProtoEAP)
+	r.logger = pppProto.GetLogger().Named("EAP")
+	r.timeout = DefaultTimeout
+	r.fragSize = DefaultTLSFragmentSize
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+func (eap *EAP) send(pkt *Pkt) error {
+	b, err := pkt.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize EAP %v,%w", pkt.Code, err)
+	}
+	eap.sendChan <- lcp.NewPPPPkt(b, lcp.ProtoEAP).Serialize()
+	eap.logger.Sugar().Debugf("send EAP pkt:\n%v", pkt)
+	return nil
+}
+
+// AuthSelf authenticates self to the peer, return nil if auth succeeds
+func (eap *EAP) AuthSelf() error {
+	defer eap.stopTLS()
+	t := time.NewTimer(eap.timeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			return fmt.Errorf("EAP authentication failed, timeout")
+		case b := <-eap.recvChan:
+			pkt := new(Pkt)
+			if err := pkt.Parse(b); err != nil {
+				eap.logger.Sugar().Warnf("got an invalid EAP pkt,%v", err)
+				continue
+			}
+			eap.logger.Sugar().Debugf("got EAP pkt:\n%v", pkt)
+			switch pkt.Code {
+			case CodeSuccess:
+				if eap.tls != nil && !eap.tls.done {
+					return fmt.Errorf("got EAP success before EAP-TLS handshake finishes")
+				}
+				return nil
+			case CodeFailure:
+				return fmt.Errorf("gateway returned failed")
+			case CodeRequest:
+				if eap.lastResp != nil && pkt.ID == eap.lastReqID {
+					// retransmitted request
+					if err := eap.send(eap.lastResp); err != nil {
+						return err
+					}
+					continue
+				}
+				resp, err := eap.handleRequest(pkt)
+				if err != nil {
+					return err
+				}
+				eap.lastReqID = pkt.ID
+				eap.lastResp = resp
+				if err = eap.send(resp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (eap *EAP) supportedTypes() []byte {
+	r := []byte{}
+	if eap.tlsConfig != nil {
+		r = append(r, byte(TypeTLS))
+	}
+	return append(r, byte(TypeMD5Challenge))
+}
+
+func (eap *EAP) handleRequest(req *Pkt) (*Pkt, error) {
+	resp := new(Pkt)
+	resp.Code = CodeResponse
+	resp.ID = req.ID
+	resp.Type = req.Type
+	switch req.Type {
+	case TypeIdentity:
+		resp.Data = []byte(eap.identity)
+	case TypeNotification:
+		eap.logger.Sugar().Infof("got EAP notification: %v", string(req.Data))
+	case TypeMD5Challenge:
+		if len(req.Data) < 1 || int(req.Data[0]) > len(req.Data)-1 {
+			return nil, fmt.Errorf("invalid EAP-MD5 challenge")
+		}
+		h := md5.New()
+		h.Write([]byte{req.ID})
+		h.Write([]byte(eap.passwd))
+		h.Write(req.Data[1 : 1+req.Data[0]])
+		resp.Data = append([]byte{md5.Size}, h.Sum(nil)...)
+		resp.Data = append(resp.Data, []byte(eap.identity)...)
+	case TypeTLS:
+		if eap.tlsConfig == nil {
+			resp.Type = TypeNak
+			resp.Data = eap.supportedTypes()
+			break
+		}
+		data, err := eap.handleTLS(req.Data)
+		if err != nil {
+			return nil, fmt.Errorf("EAP-TLS failed, %w", err)
+		}
+		resp.Data = data
+	default:
+		eap.logger.Sugar().Infof("EAP method %v is not supported, send NAK", req.Type)
+		resp.Type = TypeNak
+		resp.Data = eap.supportedTypes()
+	}
+	return resp, nil
+}
+
+func (eap *EAP) stopTLS() {
+	if eap.tls != nil {
+		eap.tls.conn.Close()
+	}
+}
+
+// handleTLS handles a received EAP-TLS request type-data and returns the type-data of the response
+func (eap *EAP) handleTLS(data []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("invalid EAP-TLS data length")
+	}
+	if data[0]&tlsFlagStart != 0 {
+		eap.stopTLS()
+		eap.tls = &tlsPeer{
+			conn:        newTLSConn(),
+			hsDone:      make(chan error, 1),
+			reassembler: new(tlsReassembler),
+		}
+		go func(p *tlsPeer) {
+			p.hsDone <- tls.Client(p.conn, eap.tlsConfig).Handshake()
+		}(eap.tls)
+		return eap.waitTLS()
+	}
+	if eap.tls == nil {
+		return nil, fmt.Errorf("EAP-TLS not started")
+	}
+	if len(eap.tls.outFrags) > 0 {
+		// this is an ack of previous fragment
+		return eap.nextTLSFrag(), nil
+	}
+	flags, complete, err := eap.tls.reassembler.add(data)
+	if err != nil {
+		return nil, err
+	}
+	if flags&tlsFlagMore != 0 {
+		// ack the fragment
+		return []byte{0}, nil
+	}
+	if eap.tls.done {
+		return []byte{0}, nil
+	}
+	select {
+	case eap.tls.conn.inChan <- complete:
+	case err = <-eap.tls.hsDone:
+		if err != nil {
+			return nil, fmt.Errorf("TLS handshake stopped, %w", err)
+		}
+		// handshake finished without consuming the data, hand the result over to waitTLS
+		eap.tls.hsDone <- nil
+	}
+	return eap.waitTLS()
+}
+
+// waitTLS waits for TLS layer output after it is started or fed with data
+func (eap *EAP) waitTLS() ([]byte, error) {
+	t := time.NewTimer(eap.timeout)
+	defer t.Stop()
+	var out []byte
+	select {
+	case out = <-eap.tls.conn.outChan:
+	case err := <-eap.tls.hsDone:
+		if err != nil {
+			return nil, fmt.Errorf("TLS handshake failed, %w", err)
+		}
+		eap.logger.Info("EAP-TLS handshake finished")
+		eap.tls.done = true
+		out = eap.tls.conn.pending()
+	case <-t.C:
+		return nil, fmt.Errorf("TLS handshake timeout")
+	}
+	if len(out) == 0 {
+		return []byte{0}, nil
+	}
+	eap.tls.outFrags = tlsFragments(out, eap.fragSize)
+	return eap.nextTLSFrag(), nil
+}
+
+func (eap *EAP) nextTLSFrag() []byte {
+	r := eap.tls.outFrags[0]
+	eap.tls.outFrags = eap.tls.outFrags[1:]
+	return r
+}
@@ -0,0 +1,273 @@
+// eap_test
+package eap
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/zouppp/lcp"
+	"github.com/hujun-open/zouppp/loopback"
+	"go.uber.org/zap"
+)
+
+// testServer is a minimal EAP authenticator
+type testServer struct {
+	sendChan, recvChan chan []byte
+	reqID              uint8
+}
+
+func (srv *testServer) send(code Code, t Type, data []byte) {
+	srv.reqID++
+	pkt := &Pkt{Code: code, ID: srv.reqID, Type: t, Data: data}
+	b, _ := pkt.Serialize()
+	srv.sendChan <- lcp.NewPPPPkt(b, lcp.ProtoEAP).Serialize()
+}
+
+func (srv *testServer) recv() (*Pkt, error) {
+	select {
+	case b := <-srv.recvChan:
+		pkt := new(Pkt)
+		if err := pkt.Parse(b); err != nil {
+			return nil, err
+		}
+		if pkt.Code != CodeResponse || pkt.ID != srv.reqID {
+			return nil, fmt.Errorf("unexpected response:\n%v", pkt)
+		}
+		return pkt, nil
+	case <-time.After(5 * time.Second):
+		return nil, fmt.Errorf("timeout")
+	}
+}
+
+func (srv *testServer) identity() (string, error) {
+	srv.send(CodeRequest, TypeIdentity, nil)
+	resp, err := srv.recv()
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Data), nil
+}
+
+func (srv *testServer) authMD5(passwd string) error {
+	challenge := []byte("0123456789abcdef")
+	srv.send(CodeRequest, TypeMD5Challenge, append([]byte{byte(len(challenge))}, challenge...))
+	resp, err := srv.recv()
+	if err != nil {
+		return err
+	}
+	h := md5.New()
+	h.Write([]byte{srv.reqID})
+	h.Write([]byte(passwd))
+	h.Write(challenge)
+	if len(resp.Data) < 17 || !bytes.Equal(resp.Data[1:17], h.Sum(nil)) {
+		srv.send(CodeFailure, 0, nil)
+		return fmt.Errorf("wrong MD5 response")
+	}
+	srv.send(CodeSuccess, 0, nil)
+	return nil
+}
+
+func (srv *testServer) authTLS(cfg *tls.Config) error {
+	// use small fragment size to test fragmentation
+	const fragSize = 256
+	srv.send(CodeRequest, TypeTLS, []byte{tlsFlagStart})
+	conn := newTLSConn()
+	defer conn.Close()
+	hsDone := make(chan error, 1)
+	go func() {
+		hsDone <- tls.Server(conn, cfg).Handshake()
+	}()
+	// initial flush before server reads ClientHello
+	<-conn.outChan
+	ra := new(tlsReassembler)
+	finished := false
+	for {
+		resp, err := srv.recv()
+		if err != nil {
+			return err
+		}
+		if resp.Type != TypeTLS {
+			return fmt.Errorf("unexpected response type %v", resp.Type)
+		}
+		flags, complete, err := ra.add(resp.Data)
+		if err != nil {
+			return err
+		}
+		if flags&tlsFlagMore != 0 {
+			srv.send(CodeRequest, TypeTLS, []byte{0})
+			continue
+		}
+		if finished {
+			break
+		}
+		var out []byte
+		select {
+		case conn.inChan <- complete:
+		case err = <-hsDone:
+			return err
+		}
+		select {
+		case out = <-conn.outChan:
+		case err = <-hsDone:
+			if err != nil {
+				srv.send(CodeFailure, 0, nil)
+				return err
+			}
+			finished = true
+			out = conn.pending()
+		}
+		frags := tlsFragments(out, fragSize)
+		for i, f := range frags {
+			srv.send(CodeRequest, TypeTLS, f)
+			if i < len(frags)-1 {
+				if ack, err := srv.recv(); err != nil || !bytes.Equal(ack.Data, []byte{0}) {
+					return fmt.Errorf("invalid fragment ack, %v", err)
+				}
+			}
+		}
+	}
+	srv.send(CodeSuccess, 0, nil)
+	return nil
+}
+
+func genCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:              []string{cn},
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder})
+}
+
+func TestEAP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	// generate certificates
+	dir := t.TempDir()
+	ca, cakey, capem, _ := genCert(t, "ca", true, nil, nil)
+	_, _, srvpem, srvkeypem := genCert(t, "bras", false, ca, cakey)
+	_, _, clntpem, clntkeypem := genCert(t, "user1", false, ca, cakey)
+	for fname, b := range map[string][]byte{"ca.pem": capem, "clnt.pem": clntpem, "clnt.key": clntkeypem} {
+		if err := os.WriteFile(filepath.Join(dir, fname), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clntTLSCfg, err := LoadTLSConfig(filepath.Join(dir, "clnt.pem"), filepath.Join(dir, "clnt.key"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clntTLSCfg.ServerName = "bras"
+	srvCert, err := tls.X509KeyPair(srvpem, srvkeypem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvTLSCfg := &tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    x509.NewCertPool(),
+		MaxVersion:   tls.VersionTLS12,
+	}
+	srvTLSCfg.ClientCAs.AddCert(ca)
+	testList := []struct {
+		method     Type
+		passwd     string
+		shouldFail bool
+	}{
+		{method: TypeMD5Challenge, passwd: "passwd1"},
+		{method: TypeMD5Challenge, passwd: "wrongpasswd", shouldFail: true},
+		{method: TypeTLS},
+	}
+	for i, c := range testList {
+		clntConn, srvConn := loopback.NewPacketConnPair()
+		clnt := NewEAP("user1", c.passwd, lcp.NewPPP(ctx, clntConn, logger.Named("clnt")), WithTLSConfig(clntTLSCfg))
+		srv := new(testServer)
+		srv.sendChan, srv.recvChan = lcp.NewPPP(ctx, srvConn, logger.Named("srv")).Register(lcp.ProtoEAP)
+		clntErrCh := make(chan error, 1)
+		go func() {
+			clntErrCh <- clnt.AuthSelf()
+		}()
+		id, srvErr := srv.identity()
+		if srvErr == nil && id != "user1" {
+			t.Fatalf("case %d got unexpected identity %v", i, id)
+		}
+		if srvErr == nil {
+			switch c.method {
+			case TypeMD5Challenge:
+				srvErr = srv.authMD5("passwd1")
+			case TypeTLS:
+				srvErr = srv.authTLS(srvTLSCfg)
+			}
+		}
+		clntErr := <-clntErrCh
+		if c.shouldFail {
+			if srvErr == nil || clntErr == nil {
+				t.Fatalf("case %d should fail but succeed", i)
+			}
+			continue
+		}
+		if srvErr != nil || clntErr != nil {
+			t.Fatalf("case %d failed, authenticator: %v, client: %v", i, srvErr, clntErr)
+		}
+	}
+}
+
+func TestTLSHandshakeDoneBeforeData(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	e := &EAP{logger: logger, timeout: time.Second, fragSize: DefaultTLSFragmentSize}
+	e.tls = &tlsPeer{
+		conn:        newTLSConn(),
+		hsDone:      make(chan error, 1),
+		reassembler: new(tlsReassembler),
+	}
+	// handshake finishes successfully while nobody reads the fed data
+	e.tls.hsDone <- nil
+	out, err := e.handleTLS([]byte{0, 0x17})
+	if err != nil {
+		t.Fatalf("successful handshake treated as failure, %v", err)
+	}
+	if !e.tls.done || !bytes.Equal(out, []byte{0}) {
+		t.Fatalf("unexpected state after handshake, done %v, out %x", e.tls.done, out)
+	}
+}
@@ -0,0 +1,74 @@
+package eap
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Pkt represents an EAP packet
+type Pkt struct {
+	Code Code
+	ID   uint8
+	Len  uint16
+	// Type is only valid for Request and Response
+	Type Type
+	// Data is the type-data, only valid for Request and Response
+	Data []byte
+}
+
+// Parse buf into ep
+func (ep *Pkt) Parse(buf []byte) error {
+	if len(buf) < 4 {
+		return fmt.Errorf("invalid EAP packet length %d", len(buf))
+	}
+	ep.Code = Code(buf[0])
+	ep.ID = buf[1]
+	ep.Len = binary.BigEndian.Uint16(buf[2:4])
+	if ep.Len < 4 || int(ep.Len) > len(buf) {
+		return fmt.Errorf("invalid EAP packet length field %d", ep.Len)
+	}
+	switch ep.Code {
+	case CodeRequest, CodeResponse:
+		if ep.Len < 5 {
+			return fmt.Errorf("invalid EAP %v length %d", ep.Code, ep.Len)
+		}
+		ep.Type = Type(buf[4])
+		ep.Data = buf[5:ep.Len]
+	}
+	return nil
+}
+
+// Serialize ep into byte slice
+func (ep *Pkt) Serialize() ([]byte, error) {
+	buf := make([]byte, 4)
+	buf[0] = uint8(ep.Code)
+	buf[1] = ep.ID
+	switch ep.Code {
+	case CodeRequest, CodeResponse:
+		buf = append(buf, byte(ep.Type))
+		buf = append(buf, ep.Data...)
+	}
+	if len(buf) > 65535 {
+		return nil, fmt.Errorf("result pkt too big")
+	}
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	return buf, nil
+}
+
+// String returns a string representation of ep
+func (ep Pkt) String() string {
+	s := fmt.Sprintf("Code:%v\n", ep.Code)
+	s += fmt.Sprintf("ID:%d\n", ep.ID)
+	s += fmt.Sprintf("Len:%d\n", ep.Len)
+	switch ep.Code {
+	case CodeRequest, CodeResponse:
+		s += fmt.Sprintf("Type:%v\n", ep.Type)
+		switch ep.Type {
+		case TypeIdentity, TypeNotification:
+			s += fmt.Sprintf("Data:%s\n", string(ep.Data))
+		default:
+			s += fmt.Sprintf("Data:%x\n", ep.Data)
+		}
+	}
+	return s
+}
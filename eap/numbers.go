@@ -0,0 +1,60 @@
+package eap
+
+import (
+	"fmt"
+)
+
+// Code is the code of EAP pkt
+type Code uint8
+
+// list of EAP code
+const (
+	CodeRequest  Code = 1
+	CodeResponse Code = 2
+	CodeSuccess  Code = 3
+	CodeFailure  Code = 4
+)
+
+// String returns a string representation of c
+func (c Code) String() string {
+	switch c {
+	case CodeRequest:
+		return "Request"
+	case CodeResponse:
+		return "Response"
+	case CodeSuccess:
+		return "Success"
+	case CodeFailure:
+		return "Failure"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(c))
+}
+
+// Type is the type of EAP request/response
+type Type uint8
+
+// list of EAP type
+const (
+	TypeIdentity     Type = 1
+	TypeNotification Type = 2
+	TypeNak          Type = 3
+	TypeMD5Challenge Type = 4
+	TypeTLS          Type = 13
+)
+
+// String returns a string representation of t
+func (t Type) String() string {
+	switch t {
+	case TypeIdentity:
+		return "Identity"
+	case TypeNotification:
+		return "Notification"
+	case TypeNak:
+		return "Nak"
+	case TypeMD5Challenge:
+		return "MD5-Challenge"
+	case TypeTLS:
+		return "EAP-TLS"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(t))
+}
@@ -0,0 +1,189 @@
+package eap
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// EAP-TLS as specified in RFC5216
+
+// EAP-TLS flags
+const (
+	tlsFlagLength = 0x80
+	tlsFlagMore   = 0x40
+	tlsFlagStart  = 0x20
+)
+
+// DefaultTLSFragmentSize is the default max size of TLS data in a single EAP-TLS pkt
+const DefaultTLSFragmentSize = 1024
+
+// LoadTLSConfig returns a tls.Config for EAP-TLS with client certificate loaded from certFile and keyFile;
+// if caFile is not empty, then the authenticator's certificate is verified against CA certificate(s) in caFile,
+// otherwise the authenticator's certificate is not verified
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate, %w", err)
+	}
+	r := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// EAP-TLS with TLS1.3 (RFC9190) is not supported
+		MaxVersion: tls.VersionTLS12,
+	}
+	if caFile == "" {
+		r.InsecureSkipVerify = true
+		return r, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate, %w", err)
+	}
+	r.RootCAs = x509.NewCertPool()
+	if !r.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid CA certificate found in %v", caFile)
+	}
+	return r, nil
+}
+
+// tlsConn is a net.Conn that carries TLS records over EAP-TLS;
+// TLS data written by TLS layer is buffered, and emitted via outChan when TLS layer waits for peer's data;
+// peer's reassembled TLS data is fed via inChan;
+type tlsConn struct {
+	inChan  chan []byte
+	outChan chan []byte
+	inBuf   *bytes.Buffer
+	outBuf  *bytes.Buffer
+	closed  chan struct{}
+}
+
+func newTLSConn() *tlsConn {
+	return &tlsConn{
+		inChan:  make(chan []byte),
+		outChan: make(chan []byte),
+		inBuf:   new(bytes.Buffer),
+		outBuf:  new(bytes.Buffer),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Read implements net.Conn interface
+func (tc *tlsConn) Read(p []byte) (int, error) {
+	if tc.inBuf.Len() == 0 {
+		out := make([]byte, tc.outBuf.Len())
+		copy(out, tc.outBuf.Bytes())
+		tc.outBuf.Reset()
+		select {
+		case tc.outChan <- out:
+		case <-tc.closed:
+			return 0, io.EOF
+		}
+		select {
+		case in := <-tc.inChan:
+			tc.inBuf.Write(in)
+		case <-tc.closed:
+			return 0, io.EOF
+		}
+	}
+	return tc.inBuf.Read(p)
+}
+
+// Write implements net.Conn interface
+func (tc *tlsConn) Write(p []byte) (int, error) {
+	return tc.outBuf.Write(p)
+}
+
+// pending returns buffered TLS data that has not been emitted, must only be called after TLS layer stops
+func (tc *tlsConn) pending() []byte {
+	out := make([]byte, tc.outBuf.Len())
+	copy(out, tc.outBuf.Bytes())
+	tc.outBuf.Reset()
+	return out
+}
+
+// Close implements net.Conn interface
+func (tc *tlsConn) Close() error {
+	select {
+	case <-tc.closed:
+	default:
+		close(tc.closed)
+	}
+	return nil
+}
+
+// LocalAddr implements net.Conn interface
+func (tc *tlsConn) LocalAddr() net.Addr { return nil }
+
+// RemoteAddr implements net.Conn interface
+func (tc *tlsConn) RemoteAddr() net.Addr { return nil }
+
+// SetDeadline implements net.Conn interface, not supported
+func (tc *tlsConn) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline implements net.Conn interface, not supported
+func (tc *tlsConn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline implements net.Conn interface, not supported
+func (tc *tlsConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// tlsFragments splits data into a list of EAP-TLS type-data, each one includes flags and at most maxSize of TLS data
+func tlsFragments(data []byte, maxSize int) [][]byte {
+	if len(data) <= maxSize {
+		return [][]byte{append([]byte{0}, data...)}
+	}
+	r := [][]byte{}
+	for pos := 0; pos < len(data); pos += maxSize {
+		end := pos + maxSize
+		var frag []byte
+		switch {
+		case pos == 0:
+			frag = make([]byte, 5)
+			frag[0] = tlsFlagLength | tlsFlagMore
+			binary.BigEndian.PutUint32(frag[1:5], uint32(len(data)))
+		case end < len(data):
+			frag = []byte{tlsFlagMore}
+		default:
+			frag = []byte{0}
+			end = len(data)
+		}
+		r = append(r, append(frag, data[pos:end]...))
+	}
+	return r
+}
+
+// tlsReassembler reassembles EAP-TLS fragments
+type tlsReassembler struct {
+	buf *bytes.Buffer
+}
+
+// add adds a received EAP-TLS type-data, return the reassembled TLS data if it is the last fragment
+func (ra *tlsReassembler) add(data []byte) (flags byte, complete []byte, err error) {
+	if len(data) < 1 {
+		return 0, nil, fmt.Errorf("invalid EAP-TLS data length %d", len(data))
+	}
+	flags = data[0]
+	data = data[1:]
+	if flags&tlsFlagLength != 0 {
+		if len(data) < 4 {
+			return flags, nil, fmt.Errorf("invalid EAP-TLS data, missing TLS message length")
+		}
+		data = data[4:]
+	}
+	if ra.buf == nil {
+		ra.buf = new(bytes.Buffer)
+	}
+	ra.buf.Write(data)
+	if flags&tlsFlagMore != 0 {
+		return flags, nil, nil
+	}
+	complete = make([]byte, ra.buf.Len())
+	copy(complete, ra.buf.Bytes())
+	ra.buf.Reset()
+	return flags, complete, nil
+}
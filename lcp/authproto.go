@@ -0,0 +1,73 @@
+package lcp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AuthProtocol is the authentication protocol to use, it determines the LCP auth protocol option, see Op()
+type AuthProtocol uint8
+
+// list of AuthProtocol
+const (
+	// AuthPAP is PAP, RFC1334
+	AuthPAP AuthProtocol = iota + 1
+	// AuthCHAP is CHAP with MD5, RFC1994
+	AuthCHAP
+	// AuthMSCHAPv2 is CHAP with MS-CHAPv2, RFC2759
+	AuthMSCHAPv2
+	// AuthEAP is EAP, RFC3748
+	AuthEAP
+)
+
+func (authp AuthProtocol) String() string {
+	switch authp {
+	case AuthPAP:
+		return "PAP"
+	case AuthCHAP:
+		return "CHAP"
+	case AuthMSCHAPv2:
+		return "MSCHAPv2"
+	case AuthEAP:
+		return "EAP"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(authp))
+}
+
+// MarshalText implements encoding.TextMarshaler interface
+func (authp AuthProtocol) MarshalText() ([]byte, error) {
+	return []byte(authp.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler interface,
+// supported values are "PAP", "CHAP", "MSCHAPv2" and "EAP", case insensitive
+func (authp *AuthProtocol) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "pap":
+		*authp = AuthPAP
+	case "chap":
+		*authp = AuthCHAP
+	case "mschapv2", "ms-chapv2":
+		*authp = AuthMSCHAPv2
+	case "eap":
+		*authp = AuthEAP
+	default:
+		return fmt.Errorf("unknown auth protocol %v", string(text))
+	}
+	return nil
+}
+
+// Op returns the LCPOpAuthProto requesting authp
+func (authp AuthProtocol) Op() (*LCPOpAuthProto, error) {
+	switch authp {
+	case AuthPAP:
+		return NewPAPAuthOp(), nil
+	case AuthCHAP:
+		return NewCHAPAuthOp(), nil
+	case AuthMSCHAPv2:
+		return NewMSCHAPv2AuthOp(), nil
+	case AuthEAP:
+		return NewEAPAuthOp(), nil
+	}
+	return nil, fmt.Errorf("unsupported auth protocol: %v", authp)
+}
@@ -0,0 +1,109 @@
+package lcp
+
+import (
+	"context"
+	"fmt"
+)
+
+// LCPOpPFC is the LCP Protocol-Field-Compression option, RFC1661 section 6.5;
+// own PFC means peer could send pkt with compressed protocol field, peer's PFC means own side could
+type LCPOpPFC struct{}
+
+// Type implements Option interface
+func (pfc LCPOpPFC) Type() uint8 {
+	return uint8(OpTypeProtocolFieldCompression)
+}
+
+// Serialize implements Option interface
+func (pfc LCPOpPFC) Serialize() ([]byte, error) {
+	return []byte{byte(OpTypeProtocolFieldCompression), 2}, nil
+}
+
+// GetPayload implements Option interface
+func (pfc LCPOpPFC) GetPayload() []byte {
+	return []byte{}
+}
+
+// Equal implements Option interface
+func (pfc LCPOpPFC) Equal(b Option) bool {
+	return b.Type() == pfc.Type()
+}
+
+// Parse implements Option interface
+func (pfc *LCPOpPFC) Parse(buf []byte) (int, error) {
+	if len(buf) < 2 || buf[0] != byte(OpTypeProtocolFieldCompression) || buf[1] != 2 {
+		return 0, fmt.Errorf("not a valid %v option", OpTypeProtocolFieldCompression)
+	}
+	return 2, nil
+}
+
+// String implements Option interface
+func (pfc LCPOpPFC) String() string {
+	return OpTypeProtocolFieldCompression.String()
+}
+
+// LCPOpACFC is the LCP Address-and-Control-Field-Compression option, RFC1661 section 6.6;
+// note address and control field is never included over PPPoE (RFC2516), so it doesn't change PPPoE framing
+type LCPOpACFC struct{}
+
+// Type implements Option interface
+func (acfc LCPOpACFC) Type() uint8 {
+	return uint8(OpTypeAddressandControlFieldCompression)
+}
+
+// Serialize implements Option interface
+func (acfc LCPOpACFC) Serialize() ([]byte, error) {
+	return []byte{byte(OpTypeAddressandControlFieldCompression), 2}, nil
+}
+
+// GetPayload implements Option interface
+func (acfc LCPOpACFC) GetPayload() []byte {
+	return []byte{}
+}
+
+// Equal implements Option interface
+func (acfc LCPOpACFC) Equal(b Option) bool {
+	return b.Type() == acfc.Type()
+}
+
+// Parse implements Option interface
+func (acfc *LCPOpACFC) Parse(buf []byte) (int, error) {
+	if len(buf) < 2 || buf[0] != byte(OpTypeAddressandControlFieldCompression) || buf[1] != 2 {
+		return 0, fmt.Errorf("not a valid %v option", OpTypeAddressandControlFieldCompression)
+	}
+	return 2, nil
+}
+
+// String implements Option interface
+func (acfc LCPOpACFC) String() string {
+	return OpTypeAddressandControlFieldCompression.String()
+}
+
+// NewDefaultOwnOptionRuleWithCompression returns a new DefaultOwnOptionRule with MRU option set to mru,
+// and additionally requests PFC and/or ACFC if pfc and/or acfc is true
+func NewDefaultOwnOptionRuleWithCompression(mru uint16, pfc, acfc bool) *DefaultOwnOptionRule {
+	r := NewDefaultOwnOptionRuleWithMRU(mru)
+	if pfc {
+		r.ownOptions = append(r.ownOptions, new(LCPOpPFC))
+	}
+	if acfc {
+		r.ownOptions = append(r.ownOptions, new(LCPOpACFC))
+	}
+	return r
+}
+
+// compressionNotify wraps h, enables PPP protocol field compression for sending upon LCP layer up if peer's PFC is acked,
+// and disables it upon LCP layer down
+func (lcp *LCP) compressionNotify(h LayerNotifyHandler) LayerNotifyHandler {
+	return func(ctx context.Context, evt LayerNotifyEvent) {
+		switch evt {
+		case LCPLayerNotifyUp:
+			pfc := lcp.PeerRule.GetOptions().GetFirst(uint8(OpTypeProtocolFieldCompression)) != nil
+			lcp.ppp.txPFC.Store(pfc)
+			lcp.logger.Sugar().Debugf("protocol field compression for sending: %v", pfc)
+		case LCPLayerNotifyDown, LCPLayerNotifyFinished:
+			lcp.ppp.txPFC.Store(false)
+		}
+		h(ctx, evt)
+	}
+}
@@ -0,0 +1,119 @@
+package lcp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EchoStats is the statistics of LCP echo-request sent and matching echo-reply received
+type EchoStats struct {
+	// Sent is the number of echo-request sent
+	Sent uint64
+	// Received is the number of echo-reply received within restart timer, matching a sent echo-request by ID
+	Received uint64
+	// Lost is the number of echo-request not replied within restart timer
+	Lost uint64
+	// MinRTT, AvgRTT and MaxRTT are round trip time of received echo-reply
+	MinRTT, AvgRTT, MaxRTT time.Duration
+	// Jitter is the smoothed mean deviation of RTT difference between consecutive echo-reply, as interarrival jitter in RFC3550
+	Jitter time.Duration
+}
+
+func (stats EchoStats) String() string {
+	return fmt.Sprintf("sent %d, received %d, lost %d, rtt min/avg/max/jitter %v/%v/%v/%v",
+		stats.Sent, stats.Received, stats.Lost, stats.MinRTT, stats.AvgRTT, stats.MaxRTT, stats.Jitter)
+}
+
+// echoTracker matches echo-reply to sent echo-request by ID and collects EchoStats, it is safe for concurrent use
+type echoTracker struct {
+	lock *sync.Mutex
+	// pending is the sending time of echo-request not replied yet, key is ID
+	pending  map[uint8]time.Time
+	stats    EchoStats
+	totalRTT time.Duration
+	lastRTT  time.Duration
+	jitter   float64
+}
+
+func newEchoTracker() *echoTracker {
+	return &echoTracker{
+		lock:    new(sync.Mutex),
+		pending: make(map[uint8]time.Time),
+	}
+}
+
+// sent records echo-request id is sent at t, pending echo-request older than timeout is counted as lost
+func (tracker *echoTracker) sent(id uint8, t time.Time, timeout time.Duration) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.expire(t, timeout)
+	if _, ok := tracker.pending[id]; ok {
+		// ID wraps around before previous one is replied
+		tracker.stats.Lost++
+	}
+	tracker.pending[id] = t
+	tracker.stats.Sent++
+}
+
+// rcvd records echo-reply id is received at t, returns RTT and true if it matches a pending echo-request sent within timeout
+func (tracker *echoTracker) rcvd(id uint8, t time.Time, timeout time.Duration) (time.Duration, bool) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	sentTime, ok := tracker.pending[id]
+	if !ok {
+		return 0, false
+	}
+	delete(tracker.pending, id)
+	rtt := t.Sub(sentTime)
+	if rtt > timeout {
+		tracker.stats.Lost++
+		return 0, false
+	}
+	stats := &tracker.stats
+	if stats.Received == 0 || rtt < stats.MinRTT {
+		stats.MinRTT = rtt
+	}
+	if rtt > stats.MaxRTT {
+		stats.MaxRTT = rtt
+	}
+	if stats.Received > 0 {
+		d := rtt - tracker.lastRTT
+		if d < 0 {
+			d = -d
+		}
+		tracker.jitter += (float64(d) - tracker.jitter) / 16
+	}
+	stats.Received++
+	tracker.totalRTT += rtt
+	tracker.lastRTT = rtt
+	return rtt, true
+}
+
+// expire counts pending echo-request older than timeout as lost, must be called with lock held
+func (tracker *echoTracker) expire(now time.Time, timeout time.Duration) {
+	for id, t := range tracker.pending {
+		if now.Sub(t) > timeout {
+			delete(tracker.pending, id)
+			tracker.stats.Lost++
+		}
+	}
+}
+
+// get returns a snapshot of EchoStats at now
+func (tracker *echoTracker) get(now time.Time, timeout time.Duration) EchoStats {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.expire(now, timeout)
+	r := tracker.stats
+	if r.Received > 0 {
+		r.AvgRTT = tracker.totalRTT / time.Duration(r.Received)
+	}
+	r.Jitter = time.Duration(tracker.jitter)
+	return r
+}
+
+// EchoStats returns the statistics of echo-request sent by lcp
+func (lcp *LCP) EchoStats() EchoStats {
+	return lcp.echo.get(time.Now(), lcp.restartTimerDuration)
+}
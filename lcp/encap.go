@@ -96,6 +96,12 @@ func (p *Pkt) Parse(buf []byte) error {
 					return new(LCPOpMagicNum)
 				case OpTypeMaximumReceiveUnit:
 					return new(LCPOpMRU)
+				case OpTypeProtocolFieldCompression:
+					return new(LCPOpPFC)
+				case OpTypeAddressandControlFieldCompression:
+					return new(LCPOpACFC)
+				case OpTypeQualityProtocol:
+					return new(LCPOpQualityProto)
 				default:
 					return newLCPGenericOption()
 				}
@@ -249,6 +255,16 @@ func NewCHAPAuthOp() *LCPOpAuthProto {
 	return &LCPOpAuthProto{Proto: ProtoCHAP, CHAPAlg: AlgCHAPwithMD5}
 }
 
+// NewEAPAuthOp returns a new EAP LCPOpAuthProto
+func NewEAPAuthOp() *LCPOpAuthProto {
+	return &LCPOpAuthProto{Proto: ProtoEAP}
+}
+
+// NewMSCHAPv2AuthOp returns a new CHAP LCPOpAuthProto with MS-CHAPv2
+func NewMSCHAPv2AuthOp() *LCPOpAuthProto {
+	return &LCPOpAuthProto{Proto: ProtoCHAP, CHAPAlg: AlgMSCHAP2}
+}
+
 // Type implements Option interface
 func (authp *LCPOpAuthProto) Type() uint8 {
 	return uint8(OpTypeAuthenticationProtocol)
@@ -4,6 +4,7 @@ package lcp
 import (
 	"encoding/hex"
 	"testing"
+	"time"
 )
 
 func TestLCP(t *testing.T) {
@@ -38,3 +39,97 @@ func TestLCP(t *testing.T) {
 	}
 	t.Logf("\n%v", l)
 }
+
+func TestPPPPkt(t *testing.T) {
+	testList := []struct {
+		pkt      PPPPkt
+		expected string
+	}{
+		{pkt: PPPPkt{Proto: ProtoIPv4, Payload: []byte{0x45}}, expected: "002145"},
+		{pkt: PPPPkt{Proto: ProtoIPv4, Payload: []byte{0x45}, PFC: true}, expected: "2145"},
+		{pkt: PPPPkt{Proto: ProtoIPv6, Payload: []byte{0x60}, PFC: true}, expected: "5760"},
+		// LCP is never compressed
+		{pkt: PPPPkt{Proto: ProtoLCP, Payload: []byte{0x01}, PFC: true}, expected: "c02101"},
+	}
+	for i, c := range testList {
+		buf := c.pkt.Serialize()
+		if hex.EncodeToString(buf) != c.expected {
+			t.Fatalf("case %d: expect %v, got %x", i, c.expected, buf)
+		}
+		rcvd := new(PPPPkt)
+		if err := rcvd.Parse(buf); err != nil {
+			t.Fatalf("case %d: failed to parse, %v", i, err)
+		}
+		if rcvd.Proto != c.pkt.Proto || hex.EncodeToString(rcvd.Payload) != hex.EncodeToString(c.pkt.Payload) || rcvd.PFC != (c.pkt.PFC && c.pkt.Proto < 0x100) {
+			t.Fatalf("case %d: expect %+v, got %+v", i, c.pkt, rcvd)
+		}
+	}
+	// address and control field is skipped
+	rcvd := new(PPPPkt)
+	if err := rcvd.Parse([]byte{0xff, 0x03, 0x21, 0x45}); err != nil || rcvd.Proto != ProtoIPv4 || len(rcvd.Payload) != 1 {
+		t.Fatalf("failed to parse pkt with address and control field, %+v %v", rcvd, err)
+	}
+}
+
+func TestLQRPkt(t *testing.T) {
+	pkt := LQRPkt{MagicNum: 0x01020304, LastOutLQRs: 1, PeerInOctets: 0xfffffffe, PeerOutOctets: 50}
+	buf := pkt.Serialize()
+	if len(buf) != lqrPktLen || hex.EncodeToString(buf[:8]) != "0102030400000001" {
+		t.Fatalf("unexpected LQR pkt %x", buf)
+	}
+	rcvd := new(LQRPkt)
+	if err := rcvd.Parse(buf); err != nil {
+		t.Fatal(err)
+	}
+	if *rcvd != pkt {
+		t.Fatalf("expect %+v, got %+v", pkt, *rcvd)
+	}
+	if err := rcvd.Parse(buf[:lqrPktLen-1]); err == nil {
+		t.Fatal("truncated LQR pkt should fail to parse")
+	}
+	// Quality-Protocol option
+	op := NewLQROp(1500 * time.Millisecond)
+	opbuf, err := op.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(opbuf) != "0408c02500000096" {
+		t.Fatalf("unexpected LQR option %x", opbuf)
+	}
+	rcvdOp := new(LCPOpQualityProto)
+	if _, err := rcvdOp.Parse(opbuf); err != nil {
+		t.Fatal(err)
+	}
+	if !rcvdOp.Equal(op) || rcvdOp.ReportingPeriod() != 1500*time.Millisecond {
+		t.Fatalf("expect %v, got %v", op, rcvdOp)
+	}
+}
+
+func TestAuthProtocol(t *testing.T) {
+	testList := []struct {
+		text     string
+		expected *LCPOpAuthProto
+	}{
+		{text: "PAP", expected: NewPAPAuthOp()},
+		{text: "chap", expected: NewCHAPAuthOp()},
+		{text: "MSCHAPv2", expected: NewMSCHAPv2AuthOp()},
+		{text: "eap", expected: NewEAPAuthOp()},
+	}
+	for _, c := range testList {
+		var authp AuthProtocol
+		if err := authp.UnmarshalText([]byte(c.text)); err != nil {
+			t.Fatal(err)
+		}
+		op, err := authp.Op()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !op.Equal(c.expected) {
+			t.Fatalf("%v: expect %v, got %v", c.text, c.expected, op)
+		}
+	}
+	var authp AuthProtocol
+	if err := authp.UnmarshalText([]byte("MD5")); err == nil {
+		t.Fatal("unknown auth protocol should fail")
+	}
+}
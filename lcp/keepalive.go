@@ -0,0 +1,113 @@
+package lcp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// KeepAliveMode specifies when LCP sends echo-request after reaching opened state
+type KeepAliveMode uint8
+
+// list of KeepAliveMode
+const (
+	// KeepAliveAlways sends echo-request every keepalive interval
+	KeepAliveAlways KeepAliveMode = iota
+	// KeepAliveIdle sends echo-request only if no pkt is received from peer during last keepalive interval
+	KeepAliveIdle
+	// KeepAliveDisabled doesn't send echo-request
+	KeepAliveDisabled
+)
+
+const (
+	// DefaultMaxConfigure is the default Max-Configure, the number of conf-req sent without receiving a response
+	DefaultMaxConfigure = DefaultRestartCounter
+	// DefaultMaxTerminate is the default Max-Terminate, the number of term-req sent without receiving a response
+	DefaultMaxTerminate = DefaultRestartCounter
+	// DefaultMaxFailure is the default Max-Failure as recommended by RFC1661,
+	// the number of conf-nak sent before starting to reject options instead
+	DefaultMaxFailure = 5
+	// DefaultMaxEchoMiss is the default number of missed echo-reply tolerated,
+	// LCP layer goes down if next echo-request is also not replied
+	DefaultMaxEchoMiss = DefaultRestartCounter
+)
+
+func (mode KeepAliveMode) String() string {
+	switch mode {
+	case KeepAliveAlways:
+		return "always"
+	case KeepAliveIdle:
+		return "idle"
+	case KeepAliveDisabled:
+		return "disabled"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(mode))
+}
+
+// MarshalText implements encoding.TextMarshaler interface
+func (mode KeepAliveMode) MarshalText() ([]byte, error) {
+	return []byte(mode.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler interface,
+// supported values are "always", "idle" and "disabled"
+func (mode *KeepAliveMode) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "", "always":
+		*mode = KeepAliveAlways
+	case "idle":
+		*mode = KeepAliveIdle
+	case "disabled", "none":
+		*mode = KeepAliveDisabled
+	default:
+		return fmt.Errorf("unsupported keepalive mode %v", string(text))
+	}
+	return nil
+}
+
+// WithRestartTimer specifies the restart timer, which is the amount of time to wait for response of conf-req, term-req or echo-request
+func WithRestartTimer(d time.Duration) Modifier {
+	return func(lcp *LCP) {
+		lcp.restartTimerDuration = d
+	}
+}
+
+// WithMaxConfigure specifies Max-Configure, the number of conf-req sent without receiving a response before layer finishes
+func WithMaxConfigure(n uint32) Modifier {
+	return func(lcp *LCP) {
+		lcp.maxConfigure = n
+	}
+}
+
+// WithMaxTerminate specifies Max-Terminate, the number of term-req sent without receiving a term-ack before layer finishes
+func WithMaxTerminate(n uint32) Modifier {
+	return func(lcp *LCP) {
+		lcp.maxTerminate = n
+	}
+}
+
+// WithMaxFailure specifies Max-Failure, the number of conf-nak sent before options to be NAKed are rejected instead
+func WithMaxFailure(n uint32) Modifier {
+	return func(lcp *LCP) {
+		lcp.maxFailure = n
+	}
+}
+
+// WithKeepAlive specifies the keepalive mode and interval, and maxMiss is the number of missed echo-reply tolerated,
+// e.g. 0 means LCP layer goes down if the 1st echo-request is not replied within restart timer
+func WithKeepAlive(mode KeepAliveMode, interval time.Duration, maxMiss uint32) Modifier {
+	return func(lcp *LCP) {
+		lcp.keepAliveMode = mode
+		lcp.keepAliveInterval = interval
+		lcp.maxEchoMiss = maxMiss
+	}
+}
+
+// idleFor returns the amount of time no pkt is received from peer
+func (lcp *LCP) idleFor() time.Duration {
+	last := lcp.ppp.LastRecv()
+	if last.IsZero() {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Since(last)
+}
@@ -21,11 +21,16 @@ type LCP struct {
 	protoType             PPPProtocolNumber //since lcp could be also used by IPCP
 	state                 *uint32
 	restartCount          *uint32
-	maxRestart            uint32
+	maxConfigure          uint32
+	maxTerminate          uint32
+	maxFailure            uint32
+	failureCount          *uint32
+	maxEchoMiss           uint32
 	restartTimerDuration  time.Duration
 	restartTimer          *time.Timer
 	keepAliveTimer        *time.Timer
 	keepAliveInterval     time.Duration
+	keepAliveMode         KeepAliveMode
 	cancellRestartTimer   context.CancelFunc
 	cancellkeepAliveTimer context.CancelFunc
 	sendChan              chan []byte
@@ -34,13 +39,24 @@ type LCP struct {
 	requestID             uint8
 	reqiestIDLock         *sync.RWMutex
 	logger                *zap.Logger
+	ppp                   *PPP
 	// OwnRule is the OwnOptionRule to handle own options
 	OwnRule OwnOptionRule
 	// PeerRule is the PeerOptionRule to handle peer's options
 	PeerRule    PeerOptionRule
 	layerNotify LayerNotifyHandler
+	echoRTT     EchoRTTHandler
+	echo        *echoTracker
+	// nakedMagicNum is the magic number in last sent conf-nak due to own magic number received, 0 if not sent
+	nakedMagicNum *uint32
+	isLoopedBack  *uint32
+	// lqr is the lqrMonitor of last time LCP is opened, nil if LQR is never negotiated
+	lqr atomic.Pointer[lqrMonitor]
 }
 
+// EchoRTTHandler is the handler function called with round trip time whenever an echo-reply matching a sent echo-request is received
+type EchoRTTHandler func(rtt time.Duration)
+
 const (
 	// DefaultRestartCounter is the default LCP restart counter value
 	DefaultRestartCounter = 3
@@ -75,9 +91,14 @@ func NewLCP(ctx context.Context, proto PPPProtocolNumber, pppProto *PPP, h Layer
 	lcp.protoType = proto
 	lcp.state = new(uint32)
 	atomic.StoreUint32(lcp.state, uint32(StateInitial))
-	lcp.maxRestart = DefaultRestartCounter
+	lcp.maxConfigure = DefaultMaxConfigure
+	lcp.maxTerminate = DefaultMaxTerminate
+	lcp.maxFailure = DefaultMaxFailure
+	lcp.maxEchoMiss = DefaultMaxEchoMiss
 	lcp.restartCount = new(uint32)
-	atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+	atomic.StoreUint32(lcp.restartCount, lcp.maxConfigure)
+	lcp.failureCount = new(uint32)
+	atomic.StoreUint32(lcp.failureCount, lcp.maxFailure)
 	// lcp.currentOwnOptions = newDefaultOptions()
 	lcp.OwnRule = NewDefaultOwnOptionRule()
 	lcp.restartTimerDuration = DefaultRestartTimerDuration
@@ -86,8 +107,15 @@ func NewLCP(ctx context.Context, proto PPPProtocolNumber, pppProto *PPP, h Layer
 	lcp.requestIDChan = make(chan uint8)
 	lcp.reqiestIDLock = new(sync.RWMutex)
 	lcp.logger = pppProto.GetLogger().Named(lcp.protoType.String())
+	lcp.ppp = pppProto
 	lcp.sendChan, lcp.recvChan = pppProto.Register(lcp.protoType)
 	lcp.layerNotify = h
+	if proto == ProtoLCP {
+		lcp.layerNotify = lcp.lqrNotify(lcp.compressionNotify(h))
+	}
+	lcp.echo = newEchoTracker()
+	lcp.nakedMagicNum = new(uint32)
+	lcp.isLoopedBack = new(uint32)
 	for _, mod := range mods {
 		mod(lcp)
 	}
@@ -125,6 +153,22 @@ func NewDefaultOwnOptionRule() *DefaultOwnOptionRule {
 	}
 }
 
+// NewDefaultOwnOptionRuleWithMRU returns a new DefaultOwnOptionRule with MRU option set to mru
+func NewDefaultOwnOptionRuleWithMRU(mru uint16) *DefaultOwnOptionRule {
+	r := NewDefaultOwnOptionRule()
+	mruop := LCPOpMRU(mru)
+	r.ownOptions.Replace(Options{&mruop})
+	return r
+}
+
+// NewAuthenticatorOwnOptionRule returns a new DefaultOwnOptionRule for the authenticator side,
+// which additionally requests peer to authenticate using authop
+func NewAuthenticatorOwnOptionRule(authop *LCPOpAuthProto) *DefaultOwnOptionRule {
+	r := NewDefaultOwnOptionRule()
+	r.ownOptions = append(r.ownOptions, authop)
+	return r
+}
+
 // GetOptions implements OwnOptionRule
 func (own *DefaultOwnOptionRule) GetOptions() Options {
 	own.mux.RLock()
@@ -171,8 +215,14 @@ type PeerOptionRule interface {
 
 // DefaultPeerOptionRule is the default PeerOptionRule implementation.
 type DefaultPeerOptionRule struct {
-	// AuthOp is the required Auth Protocol Option (PAP or CHAP)
-	AuthOp         *LCPOpAuthProto
+	// AuthOp is the required Auth Protocol Option (PAP, CHAP with MD5, CHAP with MS-CHAPv2 or EAP)
+	AuthOp *LCPOpAuthProto
+	// MaxMRU is the max MRU peer could use, a bigger MRU in conf-req will be NAKed; 0 means no limit
+	MaxMRU uint16
+	// PFC/ACFC means accepting peer's PFC/ACFC option, otherwise it will be rejected
+	PFC, ACFC bool
+	// LQR means accepting peer's Quality-Protocol option of LQR, otherwise it will be rejected
+	LQR            bool
 	currentOptions Options
 }
 
@@ -184,12 +234,20 @@ func NewDefaultPeerOptionRule(authp PPPProtocolNumber) (*DefaultPeerOptionRule,
 		op = NewCHAPAuthOp()
 	case ProtoPAP:
 		op = NewPAPAuthOp()
+	case ProtoEAP:
+		op = NewEAPAuthOp()
 	default:
 		return nil, fmt.Errorf("unsupported auth protocol: %v", authp)
 	}
+	return NewDefaultPeerOptionRuleWithAuthOp(op), nil
+}
+
+// NewDefaultPeerOptionRuleWithAuthOp create a new DefaultPeerOptionRule instance with specified auth option,
+// e.g. created by AuthProtocol.Op
+func NewDefaultPeerOptionRuleWithAuthOp(op *LCPOpAuthProto) *DefaultPeerOptionRule {
 	r := new(DefaultPeerOptionRule)
 	r.AuthOp = op
-	return r, nil
+	return r
 }
 
 // GetOptions implements PeerOptionRule.
@@ -198,6 +256,9 @@ func (rule *DefaultPeerOptionRule) GetOptions() Options {
 }
 
 // HandlerConfReq implements PeerOptionRule, if config-request include an auth-proto option that is different from required one, it will be NAKed;
+// MRU bigger than MaxMRU will be NAKed with MaxMRU;
+// PFC and ACFC are rejected unless rule.PFC and rule.ACFC are true;
+// Quality-Protocol is rejected unless rule.LQR is true, in which case a non-LQR one will be NAKed with LQR;
 // Option in conf-req other than auth-proto, magic number and MRU will be rejected.
 func (rule *DefaultPeerOptionRule) HandlerConfReq(rcvd Options) (nak, reject Options) {
 	rule.currentOptions = rcvd
@@ -207,7 +268,26 @@ func (rule *DefaultPeerOptionRule) HandlerConfReq(rcvd Options) (nak, reject Opt
 			if !o.Equal(rule.AuthOp) {
 				nak = append(nak, rule.AuthOp)
 			}
-		case OpTypeMagicNumber, OpTypeMaximumReceiveUnit:
+		case OpTypeMaximumReceiveUnit:
+			if rule.MaxMRU > 0 && uint16(*o.(*LCPOpMRU)) > rule.MaxMRU {
+				maxmru := LCPOpMRU(rule.MaxMRU)
+				nak = append(nak, &maxmru)
+			}
+		case OpTypeMagicNumber:
+		case OpTypeProtocolFieldCompression:
+			if !rule.PFC {
+				reject = append(reject, o)
+			}
+		case OpTypeAddressandControlFieldCompression:
+			if !rule.ACFC {
+				reject = append(reject, o)
+			}
+		case OpTypeQualityProtocol:
+			if !rule.LQR {
+				reject = append(reject, o)
+			} else if o.(*LCPOpQualityProto).Proto != ProtoLinkQualityReport {
+				nak = append(nak, NewLQROp(0))
+			}
 		default:
 			reject = append(reject, o)
 		}
@@ -272,6 +352,12 @@ func (lcp *LCP) send(p []byte) (err error) {
 }
 
 func (lcp *LCP) sendConfReq(ctx context.Context) error {
+	switch lcp.getState() {
+	case StateReqSent, StateAckRcvd, StateAckSent:
+	default:
+		// starting a new negotiation
+		atomic.StoreUint32(lcp.failureCount, lcp.maxFailure)
+	}
 	lcppkt := NewPkt(lcp.protoType)
 	lcppkt.Code = CodeConfigureRequest
 	lcppkt.ID = <-lcp.requestIDChan
@@ -370,7 +456,7 @@ func (lcp *LCP) sendConfACK(req *Pkt) error {
 
 func (lcp *LCP) resetKeepAliveTimer(ctx context.Context) {
 	lcp.logger.Debug("reset keepalive timer")
-	if lcp.protoType != ProtoLCP {
+	if lcp.protoType != ProtoLCP || lcp.keepAliveMode == KeepAliveDisabled {
 		return
 	}
 	if lcp.keepAliveTimer == nil {
@@ -411,10 +497,26 @@ func (lcp *LCP) resetTimer(ctx context.Context) {
 	}(childctx)
 }
 
+// stopTimer stops the restart timer
+func (lcp *LCP) stopTimer() {
+	lcp.logger.Debug("stop timer")
+	if lcp.restartTimer == nil {
+		return
+	}
+	lcp.restartTimer.Stop()
+	lcp.cancellRestartTimer()
+}
+
 // Keepalive Timeout event, called by lcp.resetKeepAliveTimer()
 func (lcp *LCP) keepAliveTimeout(ctx context.Context) {
 	switch State(atomic.LoadUint32(lcp.state)) {
 	case StateOpened:
+		if lcp.keepAliveMode == KeepAliveIdle && lcp.idleFor() < lcp.keepAliveInterval {
+			// peer is alive, no need to send echo-request
+			lcp.resetKeepAliveTimer(ctx)
+			return
+		}
+		atomic.StoreUint32(lcp.restartCount, lcp.maxEchoMiss)
 		err := lcp.sendEchoRequest(ctx)
 		if err != nil {
 			lcp.logger.Error(err.Error())
@@ -505,6 +607,9 @@ func (lcp *LCP) processRecvByte(ctx context.Context, pktbytes []byte) {
 		}
 	case CodeConfigureRequest:
 		nak, reject := lcp.PeerRule.HandlerConfReq(pkt.Options)
+		if mn := lcp.checkConfReqMagicNum(pkt); mn != nil {
+			nak = append(nak, mn)
+		}
 		if len(nak) == 0 && len(reject) == 0 {
 			err = lcp.rcrPlus(ctx, pkt)
 			if err != nil {
@@ -517,6 +622,11 @@ func (lcp *LCP) processRecvByte(ctx context.Context, pktbytes []byte) {
 			lcp.logger.Sugar().Errorf("failed to process RCR event,%v", err)
 		}
 	case CodeEchoReply, CodeEchoRequest, CodeDiscardRequest:
+		if pkt.Code != CodeDiscardRequest && lcp.checkEchoMagicNum(pkt) {
+			lcp.logger.Sugar().Warnf("rcvd %v with own magic number", pkt.Code)
+			lcp.loopedBack(ctx)
+			return
+		}
 		err = lcp.rxr(ctx, pkt)
 		if err != nil {
 			lcp.logger.Sugar().Errorf("failed to process RXR event,%v", err)
@@ -532,6 +642,10 @@ func (lcp *LCP) processRecvByte(ctx context.Context, pktbytes []byte) {
 			lcp.logger.Sugar().Errorf("failed to process RTA event,%v", err)
 		}
 	case CodeConfigureNak, CodeConfigureReject:
+		if pkt.Code == CodeConfigureNak && lcp.checkConfNAKMagicNum(pkt) {
+			lcp.logger.Warn("rcvd conf-nak with the magic number in last sent conf-nak")
+			lcp.loopedBack(ctx)
+		}
 		err = lcp.rcn(ctx, pkt)
 		if err != nil {
 			lcp.logger.Sugar().Errorf("failed to process RCN event,%v", err)
@@ -564,6 +678,11 @@ func (lcp *LCP) recv(ctx context.Context) {
 	}
 }
 
+// initRestartCount is the Initialize-Restart-Count action, n is Max-Configure or Max-Terminate
+func (lcp *LCP) initRestartCount(n uint32) {
+	atomic.StoreUint32(lcp.restartCount, n)
+}
+
 func (lcp *LCP) rcrPlus(ctx context.Context, req *Pkt) (err error) {
 	switch lcp.getState() {
 	case StateClosed:
@@ -580,7 +699,7 @@ func (lcp *LCP) rcrPlus(ctx context.Context, req *Pkt) (err error) {
 		if err != nil {
 			return
 		}
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxConfigure)
 		lcp.setState(StateAckSent)
 	case StateReqSent:
 		// send conf-ack
@@ -620,6 +739,18 @@ func (lcp *LCP) rcrPlus(ctx context.Context, req *Pkt) (err error) {
 }
 
 func (lcp *LCP) rcrMinus(ctx context.Context, req *Pkt, nak, reject Options) (err error) {
+	if len(nak) > 0 {
+		if atomic.LoadUint32(lcp.failureCount) == 0 {
+			// Max-Failure reached, reject options instead of NAK, see RFC1661 section 4.6
+			lcp.logger.Info("max-failure reached, rejecting options instead of NAK")
+			for _, o := range nak {
+				reject = append(reject, Options(req.Options).Get(o.Type())...)
+			}
+			nak = nil
+		} else {
+			atomic.AddUint32(lcp.failureCount, ^uint32(0))
+		}
+	}
 	switch lcp.getState() {
 	case StateClosed:
 		//send term-ack
@@ -635,7 +766,7 @@ func (lcp *LCP) rcrMinus(ctx context.Context, req *Pkt, nak, reject Options) (er
 		if err != nil {
 			return
 		}
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxConfigure)
 		lcp.setState(StateReqSent)
 	case StateReqSent, StateAckRcvd:
 		// send conf-nak
@@ -672,7 +803,7 @@ func (lcp *LCP) rca(ctx context.Context, req *Pkt) (err error) {
 		//send term-ack
 		err = lcp.sendTermACK(req)
 	case StateReqSent:
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxConfigure)
 		lcp.setState(StateAckRcvd)
 	case StateAckRcvd:
 		//send conf req
@@ -682,7 +813,7 @@ func (lcp *LCP) rca(ctx context.Context, req *Pkt) (err error) {
 		}
 		lcp.setState(StateReqSent)
 	case StateAckSent:
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxConfigure)
 		lcp.layerNotify(ctx, LCPLayerNotifyUp)
 		lcp.setState(StateOpened)
 		lcp.resetKeepAliveTimer(ctx)
@@ -716,7 +847,7 @@ func (lcp *LCP) rcn(ctx context.Context, req *Pkt) error {
 		if err != nil {
 			return err
 		}
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxConfigure)
 	case StateAckRcvd:
 		//send cfg-req
 		err := lcp.sendConfReq(ctx)
@@ -730,7 +861,7 @@ func (lcp *LCP) rcn(ctx context.Context, req *Pkt) error {
 		if err != nil {
 			return err
 		}
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxConfigure)
 	case StateOpened, StateEchoReqSent:
 		//send cfg-req
 		err := lcp.sendConfReq(ctx)
@@ -848,7 +979,7 @@ func (lcp *LCP) rxjMinus(ctx context.Context, req *Pkt) error {
 			return err
 		}
 		lcp.layerNotify(ctx, LCPLayerNotifyDown)
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxTerminate)
 	}
 	return nil
 }
@@ -866,6 +997,7 @@ func (lcp *LCP) sendEchoRequest(ctx context.Context) error {
 	}
 	lcp.logger.Info("sending echo-request")
 	lcp.logger.Debug("\n" + lcppkt.String())
+	lcp.echo.sent(lcppkt.ID, time.Now(), lcp.restartTimerDuration)
 	defer lcp.resetTimer(ctx)
 	return lcp.send(lcpbytes)
 
@@ -898,9 +1030,11 @@ func (lcp *LCP) rxr(ctx context.Context, req *Pkt) error {
 			return lcp.sendEchoReply(req)
 		}
 	case CodeEchoReply:
+		lcp.echoReplyRcvd(req)
 		switch lcp.getState() {
 		case StateEchoReqSent:
-			atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+			lcp.stopTimer()
+			atomic.StoreUint32(lcp.restartCount, lcp.maxEchoMiss)
 			lcp.setState(StateOpened)
 			lcp.resetKeepAliveTimer(ctx)
 		}
@@ -908,6 +1042,15 @@ func (lcp *LCP) rxr(ctx context.Context, req *Pkt) error {
 	return nil
 }
 
+// echoReplyRcvd updates echo stats and calls lcp.echoRTT if rcvd echo-reply matches a sent echo-request
+func (lcp *LCP) echoReplyRcvd(reply *Pkt) {
+	rtt, ok := lcp.echo.rcvd(reply.ID, time.Now(), lcp.restartTimerDuration)
+	if !ok || lcp.echoRTT == nil {
+		return
+	}
+	lcp.echoRTT(rtt)
+}
+
 // Up is lower layer up event, as defined in RFC1661
 func (lcp *LCP) Up(ctx context.Context) (err error) {
 	switch lcp.getState() {
@@ -918,7 +1061,7 @@ func (lcp *LCP) Up(ctx context.Context) (err error) {
 		if err != nil {
 			return
 		}
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxConfigure)
 		lcp.resetTimer(ctx)
 		lcp.setState(StateReqSent)
 	}
@@ -950,7 +1093,7 @@ func (lcp *LCP) Open(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxConfigure)
 		lcp.setState(StateReqSent)
 	case StateClosing:
 		lcp.setState(StateStopping)
@@ -975,7 +1118,7 @@ func (lcp *LCP) Close(ctx context.Context) {
 			lcp.logger.Sugar().Errorf("failed to process TO+ event,err", err)
 			return
 		}
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxTerminate)
 
 		lcp.setState(StateClosing)
 	case StateOpened, StateEchoReqSent:
@@ -986,7 +1129,7 @@ func (lcp *LCP) Close(ctx context.Context) {
 			return
 		}
 		lcp.layerNotify(ctx, LCPLayerNotifyDown)
-		atomic.StoreUint32(lcp.restartCount, lcp.maxRestart)
+		lcp.initRestartCount(lcp.maxTerminate)
 
 		lcp.setState(StateClosing)
 	}
@@ -1009,6 +1152,13 @@ func WithOwnOptionRule(r OwnOptionRule) Modifier {
 	}
 }
 
+// WithEchoRTTHandler specifies h as the EchoRTTHandler
+func WithEchoRTTHandler(h EchoRTTHandler) Modifier {
+	return func(lcp *LCP) {
+		lcp.echoRTT = h
+	}
+}
+
 // Options is a slice of LCPOption
 type Options []Option
 
@@ -0,0 +1,450 @@
+package lcp
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/zouppp/loopback"
+	"go.uber.org/zap"
+)
+
+// chanConn is a loopback.PacketConn, pkt written into it is dropped if drop is set or its PPP protocol is dropProto,
+// or read from itself if loop is set; number of LCP echo-request written is counted
+type chanConn struct {
+	*loopback.PacketConn
+	drop      atomic.Bool
+	loop      atomic.Bool
+	echoCount atomic.Int32
+	// dropProto is the PPP protocol of pkts to drop, 0 means none
+	dropProto atomic.Uint32
+}
+
+func newChanConnPair() (*chanConn, *chanConn) {
+	a, b := loopback.NewPacketConnPair()
+	ca, cb := &chanConn{PacketConn: a}, &chanConn{PacketConn: b}
+	a.SetFilter(ca.filter)
+	b.SetFilter(cb.filter)
+	return ca, cb
+}
+
+func (cc *chanConn) filter(p []byte) loopback.Verdict {
+	var proto uint32
+	if len(p) > 2 {
+		proto = uint32(p[0])<<8 | uint32(p[1])
+	}
+	if PPPProtocolNumber(proto) == ProtoLCP && MsgCode(p[2]) == CodeEchoRequest {
+		cc.echoCount.Add(1)
+	}
+	switch {
+	case cc.drop.Load() || (proto != 0 && proto == cc.dropProto.Load()):
+		return loopback.Drop
+	case cc.loop.Load():
+		return loopback.Loop
+	}
+	return loopback.Pass
+}
+
+// lcpPair is a pair of LCP connected by chanConn
+type lcpPair struct {
+	clnt, srv         *LCP
+	clntConn, srvConn *chanConn
+	// clntEvts is client's layer events after LCP is up
+	clntEvts chan LayerNotifyEvent
+}
+
+// openLCPPair opens LCP between a client using clntMods and a server using srvMods, returns after LCP is up;
+// server requests CHAP and accepts default options unless overridden by srvMods
+func openLCPPair(ctx context.Context, t *testing.T, clntMods, srvMods []Modifier) *lcpPair {
+	logger, _ := zap.NewDevelopment()
+	clntConn, srvConn := newChanConnPair()
+	clntEvts := make(chan LayerNotifyEvent, 8)
+	srvUp := make(chan struct{}, 1)
+	clnt := NewLCP(ctx, ProtoLCP, NewPPP(ctx, clntConn, logger.Named("clnt")), func(ctx context.Context, evt LayerNotifyEvent) {
+		clntEvts <- evt
+	}, clntMods...)
+	srvMods = append([]Modifier{WithOwnOptionRule(NewAuthenticatorOwnOptionRule(NewCHAPAuthOp())),
+		WithPeerOptionRule(&DefaultPeerOptionRule{})}, srvMods...)
+	srv := NewLCP(ctx, ProtoLCP, NewPPP(ctx, srvConn, logger.Named("srv")), func(ctx context.Context, evt LayerNotifyEvent) {
+		if evt == LCPLayerNotifyUp {
+			srvUp <- struct{}{}
+		}
+	}, srvMods...)
+	for _, p := range []*LCP{clnt, srv} {
+		if err := p.Open(ctx); err != nil {
+			t.Fatal(err)
+		}
+		p.Up(ctx)
+	}
+	for up := false; !up; {
+		select {
+		case evt := <-clntEvts:
+			up = evt == LCPLayerNotifyUp
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for client LCP up")
+		}
+	}
+	select {
+	case <-srvUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for server LCP up")
+	}
+	return &lcpPair{clnt: clnt, srv: srv, clntConn: clntConn, srvConn: srvConn, clntEvts: clntEvts}
+}
+
+func TestKeepAliveMiss(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interval := 100 * time.Millisecond
+	var maxMiss uint32 = 2
+	pair := openLCPPair(ctx, t,
+		[]Modifier{WithRestartTimer(interval), WithKeepAlive(KeepAliveAlways, interval, maxMiss)},
+		[]Modifier{WithKeepAlive(KeepAliveDisabled, 0, 0)})
+	clntConn, srvConn, clntEvts := pair.clntConn, pair.srvConn, pair.clntEvts
+	// the peer is alive
+	time.Sleep(5 * interval)
+	select {
+	case evt := <-clntEvts:
+		t.Fatalf("unexpected LCP event %v", evt)
+	default:
+	}
+	if clntConn.echoCount.Load() == 0 {
+		t.Fatal("no echo-request sent")
+	}
+	// the peer is dead
+	srvConn.drop.Store(true)
+	time.Sleep(interval / 2)
+	sent := clntConn.echoCount.Load()
+	select {
+	case evt := <-clntEvts:
+		if evt != LCPLayerNotifyFinished {
+			t.Fatalf("expect LCP finished, got %v", evt)
+		}
+	case <-time.After(time.Duration(maxMiss+3) * 2 * interval):
+		t.Fatal("LCP is not down after missing echo-reply")
+	}
+	// echo-request is sent maxMiss+1 times without reply before LCP goes down, including the one might be already sent
+	if n := clntConn.echoCount.Load() - sent; n < int32(maxMiss) || n > int32(maxMiss)+1 {
+		t.Fatalf("expect %d echo-request sent after peer is dead, got %d", maxMiss+1, n)
+	}
+}
+
+func TestKeepAliveIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interval := 200 * time.Millisecond
+	// server keeps sending echo-request, so client is never idle
+	pair := openLCPPair(ctx, t,
+		[]Modifier{WithKeepAlive(KeepAliveIdle, interval, 0)},
+		[]Modifier{WithKeepAlive(KeepAliveAlways, interval/4, DefaultMaxEchoMiss)})
+	clntConn, clntEvts := pair.clntConn, pair.clntEvts
+	time.Sleep(5 * interval)
+	if n := clntConn.echoCount.Load(); n != 0 {
+		t.Fatalf("expect no echo-request sent in idle mode while receiving pkts, got %d", n)
+	}
+	select {
+	case evt := <-clntEvts:
+		t.Fatalf("unexpected LCP event %v", evt)
+	default:
+	}
+}
+
+func TestEchoStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interval := 50 * time.Millisecond
+	pair := openLCPPair(ctx, t,
+		[]Modifier{WithRestartTimer(interval), WithKeepAlive(KeepAliveAlways, interval, 100)},
+		[]Modifier{WithKeepAlive(KeepAliveDisabled, 0, 0)})
+	clnt, srvConn := pair.clnt, pair.srvConn
+	time.Sleep(10 * interval)
+	stats := clnt.EchoStats()
+	t.Logf("echo stats: %v", stats)
+	if stats.Sent == 0 || stats.Received == 0 || stats.Lost != 0 {
+		t.Fatalf("unexpected echo stats with live peer, %v", stats)
+	}
+	if stats.MinRTT <= 0 || stats.MinRTT > stats.AvgRTT || stats.AvgRTT > stats.MaxRTT || stats.MaxRTT > interval {
+		t.Fatalf("invalid echo RTT, %v", stats)
+	}
+	// the peer is dead
+	srvConn.drop.Store(true)
+	time.Sleep(10 * interval)
+	stats = clnt.EchoStats()
+	t.Logf("echo stats: %v", stats)
+	if stats.Lost == 0 || stats.Sent < stats.Received+stats.Lost || stats.Sent > stats.Received+stats.Lost+1 {
+		t.Fatalf("unexpected echo stats with dead peer, %v", stats)
+	}
+}
+
+func TestEchoTracker(t *testing.T) {
+	timeout := time.Second
+	tracker := newEchoTracker()
+	start := time.Now()
+	for i, rtt := range []time.Duration{10, 30, 20} {
+		sent := start.Add(time.Duration(i) * timeout)
+		tracker.sent(uint8(i), sent, timeout)
+		if _, ok := tracker.rcvd(uint8(i), sent.Add(rtt*time.Millisecond), timeout); !ok {
+			t.Fatalf("echo-reply %d doesn't match", i)
+		}
+	}
+	// not matching any echo-request
+	if _, ok := tracker.rcvd(100, start, timeout); ok {
+		t.Fatal("unexpected match of echo-reply 100")
+	}
+	// replied after timeout
+	tracker.sent(3, start, timeout)
+	if _, ok := tracker.rcvd(3, start.Add(2*timeout), timeout); ok {
+		t.Fatal("unexpected match of late echo-reply 3")
+	}
+	// not replied
+	tracker.sent(4, start, timeout)
+	stats := tracker.get(start.Add(2*timeout), timeout)
+	expected := EchoStats{
+		Sent:     5,
+		Received: 3,
+		Lost:     2,
+		MinRTT:   10 * time.Millisecond,
+		AvgRTT:   20 * time.Millisecond,
+		MaxRTT:   30 * time.Millisecond,
+		// 20ms/16, then 10ms/16 + (1-1/16)*20ms/16
+		Jitter: time.Duration(float64(20*time.Millisecond)/16*(15.0/16) + float64(10*time.Millisecond)/16),
+	}
+	if stats != expected {
+		t.Fatalf("expect %v, got %v", expected, stats)
+	}
+}
+
+// waitForEvent waits for LayerNotifyEvent expected in evts, other events are ignored
+func waitForEvent(t *testing.T, evts chan LayerNotifyEvent, expected LayerNotifyEvent, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case evt := <-evts:
+			if evt == expected {
+				return
+			}
+		case <-timer.C:
+			t.Fatalf("timeout waiting for LCP %v", expected)
+		}
+	}
+}
+
+func TestLoopedBack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	// looped-back during negotiation
+	conn, _ := newChanConnPair()
+	conn.loop.Store(true)
+	evts := make(chan LayerNotifyEvent, 8)
+	p := NewLCP(ctx, ProtoLCP, NewPPP(ctx, conn, logger.Named("looped")), func(ctx context.Context, evt LayerNotifyEvent) {
+		evts <- evt
+	}, WithRestartTimer(time.Second))
+	if err := p.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p.Up(ctx)
+	waitForEvent(t, evts, LCPLayerNotifyLoopedBack, 3*time.Second)
+	// looped-back after LCP is up, detected by echo-request
+	interval := 100 * time.Millisecond
+	pair := openLCPPair(ctx, t,
+		[]Modifier{WithRestartTimer(interval), WithKeepAlive(KeepAliveAlways, interval, DefaultMaxEchoMiss)},
+		[]Modifier{WithKeepAlive(KeepAliveDisabled, 0, 0)})
+	clntConn, clntEvts := pair.clntConn, pair.clntEvts
+	time.Sleep(3 * interval)
+	select {
+	case evt := <-clntEvts:
+		t.Fatalf("unexpected LCP event %v", evt)
+	default:
+	}
+	clntConn.loop.Store(true)
+	waitForEvent(t, clntEvts, LCPLayerNotifyLoopedBack, 3*interval)
+}
+
+func TestPFC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testList := []struct {
+		clntPFC, srvPFC bool
+	}{
+		{clntPFC: true, srvPFC: true},
+		{clntPFC: true},
+		{srvPFC: true},
+	}
+	for i, c := range testList {
+		clntPeerRule := NewDefaultPeerOptionRuleWithAuthOp(NewCHAPAuthOp())
+		clntPeerRule.PFC = c.clntPFC
+		srvOwnRule := NewAuthenticatorOwnOptionRule(NewCHAPAuthOp())
+		if c.srvPFC {
+			srvOwnRule.ownOptions = append(srvOwnRule.ownOptions, new(LCPOpPFC), new(LCPOpACFC))
+		}
+		pair := openLCPPair(ctx, t,
+			[]Modifier{WithOwnOptionRule(NewDefaultOwnOptionRuleWithCompression(DefaultMRU, c.clntPFC, c.clntPFC)),
+				WithPeerOptionRule(clntPeerRule)},
+			[]Modifier{WithOwnOptionRule(srvOwnRule), WithPeerOptionRule(&DefaultPeerOptionRule{PFC: c.srvPFC, ACFC: c.srvPFC})})
+		// PFC is used for sending only if peer requests it and it is accepted
+		if pair.clnt.ppp.txPFC.Load() != (c.clntPFC && c.srvPFC) || pair.srv.ppp.txPFC.Load() != (c.clntPFC && c.srvPFC) {
+			t.Fatalf("case %d: unexpected PFC state, client %v, server %v", i,
+				pair.clnt.ppp.txPFC.Load(), pair.srv.ppp.txPFC.Load())
+		}
+		// IPv4 pkt sent by server is received by client
+		_, recvChan := pair.clnt.ppp.Register(ProtoIPv4)
+		sendChan, _ := pair.srv.ppp.Register(ProtoIPv4)
+		payload := []byte{0x45, 0, 0, 20}
+		sendChan <- NewPPPPkt(payload, ProtoIPv4).Serialize()
+		select {
+		case b := <-recvChan:
+			if !bytes.Equal(b, payload) {
+				t.Fatalf("case %d: expect %x, got %x", i, payload, b)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("case %d: timeout waiting for IPv4 pkt", i)
+		}
+	}
+}
+
+func TestLQR(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	period := 100 * time.Millisecond
+	clntOwnRule := NewDefaultOwnOptionRule()
+	clntOwnRule.SetLQR(period)
+	clntPeerRule := NewDefaultPeerOptionRuleWithAuthOp(NewCHAPAuthOp())
+	clntPeerRule.LQR = true
+	srvOwnRule := NewAuthenticatorOwnOptionRule(NewCHAPAuthOp())
+	srvOwnRule.SetLQR(period)
+	pair := openLCPPair(ctx, t,
+		[]Modifier{WithOwnOptionRule(clntOwnRule), WithPeerOptionRule(clntPeerRule), WithKeepAlive(KeepAliveDisabled, 0, 0)},
+		[]Modifier{WithOwnOptionRule(srvOwnRule), WithPeerOptionRule(&DefaultPeerOptionRule{LQR: true}), WithKeepAlive(KeepAliveDisabled, 0, 0)})
+	time.Sleep(5 * period)
+	for i, p := range []*LCP{pair.clnt, pair.srv} {
+		stats := p.LQRStats()
+		t.Logf("%d LQR stats: %v", i, stats)
+		if stats.Sent < 3 || stats.Received < 3 {
+			t.Fatalf("not enough LQR exchanged, %v", stats)
+		}
+		if stats.InboundTotal.LostPackets != 0 || stats.OutboundTotal.LostPackets != 0 {
+			t.Fatalf("unexpected loss on a lossless link, %v", stats)
+		}
+	}
+	// IPv4 pkts sent by server are lost
+	pair.srvConn.dropProto.Store(uint32(ProtoIPv4))
+	sendChan, _ := pair.srv.ppp.Register(ProtoIPv4)
+	const lostPkts = 10
+	for i := 0; i < lostPkts; i++ {
+		sendChan <- NewPPPPkt([]byte{0x45, 0, 0, 20}, ProtoIPv4).Serialize()
+	}
+	time.Sleep(5 * period)
+	if lost := pair.clnt.LQRStats().InboundTotal.LostPackets; lost != lostPkts {
+		t.Fatalf("client expect %d inbound lost pkts, got %d", lostPkts, lost)
+	}
+	if lost := pair.srv.LQRStats().OutboundTotal.LostPackets; lost != lostPkts {
+		t.Fatalf("server expect %d outbound lost pkts, got %d", lostPkts, lost)
+	}
+	if q := pair.clnt.LQRStats().InboundTotal.Quality(); q >= 1 {
+		t.Fatalf("client inbound quality should be degraded, got %v", q)
+	}
+}
+
+func TestProtocolReject(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	conn, peer := loopback.NewPacketConnPair()
+	ppp := NewPPP(ctx, conn, logger.Named("ppp"))
+	_, recvChan := ppp.Register(ProtoEAP)
+	buf := make([]byte, 128)
+	// pkt of a registered protocol is only delivered, not rejected
+	peer.WriteTo(NewPPPPkt([]byte{1, 2}, ProtoEAP).Serialize(), nil)
+	select {
+	case b := <-recvChan:
+		if !bytes.Equal(b, []byte{1, 2}) {
+			t.Fatalf("unexpected payload %x", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for EAP pkt")
+	}
+	peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := peer.ReadFrom(buf); err == nil {
+		t.Fatalf("unexpected pkt %x sent for a registered protocol", buf[:n])
+	}
+	// pkt of an unknown protocol is rejected
+	peer.WriteTo(NewPPPPkt([]byte{3, 4}, ProtoLinkQualityReport).Serialize(), nil)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := peer.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rej := new(PPPPkt)
+	if err = rej.Parse(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if rej.Proto != ProtoLCP || MsgCode(rej.Payload[0]) != CodeProtocolReject ||
+		PPPProtocolNumber(uint16(rej.Payload[4])<<8|uint16(rej.Payload[5])) != ProtoLinkQualityReport {
+		t.Fatalf("expect protocol-reject of %v, got %x", ProtoLinkQualityReport, buf[:n])
+	}
+}
+
+func TestMaxFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	conn, peer := loopback.NewPacketConnPair()
+	p := NewLCP(ctx, ProtoLCP, NewPPP(ctx, conn, logger.Named("lcp")), func(ctx context.Context, evt LayerNotifyEvent) {},
+		WithMaxFailure(2), WithRestartTimer(10*time.Second), WithPeerOptionRule(&DefaultPeerOptionRule{MaxMRU: 1400}))
+	if err := p.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p.Up(ctx)
+	send := func(code MsgCode, id uint8, mru uint16) {
+		pkt := NewPkt(ProtoLCP)
+		pkt.Code, pkt.ID = code, id
+		op := LCPOpMRU(mru)
+		pkt.Options = []Option{&op}
+		buf, err := pkt.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer.WriteTo(NewPPPPkt(buf, ProtoLCP).Serialize(), nil)
+	}
+	// next returns the next pkt from p with one of codes, pkts with other codes are kept for later calls
+	pending := []*Pkt{}
+	next := func(codes ...MsgCode) *Pkt {
+		buf := make([]byte, 128)
+		for {
+			for i, pkt := range pending {
+				for _, c := range codes {
+					if pkt.Code == c {
+						pending = append(pending[:i], pending[i+1:]...)
+						return pkt
+					}
+				}
+			}
+			peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+			n, _, err := peer.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("failed to read %v, %v", codes, err)
+			}
+			ppkt := new(PPPPkt)
+			if err = ppkt.Parse(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			pkt := NewPkt(ProtoLCP)
+			if err = pkt.Parse(ppkt.Payload); err != nil {
+				t.Fatal(err)
+			}
+			pending = append(pending, pkt)
+		}
+	}
+	for i, expected := range []MsgCode{CodeConfigureNak, CodeConfigureNak, CodeConfigureReject} {
+		send(CodeConfigureRequest, uint8(i+1), 1500)
+		if rsp := next(CodeConfigureNak, CodeConfigureReject); rsp.Code != expected {
+			t.Fatalf("conf-req %d expect %v, got %v", i+1, expected, rsp.Code)
+		}
+		// NAK p's conf-req, p sends a new one after Initialize-Restart-Count, which must not reset the Max-Failure counter
+		send(CodeConfigureNak, next(CodeConfigureRequest).ID, 1400)
+	}
+}
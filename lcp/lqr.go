@@ -0,0 +1,380 @@
+package lcp
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Link Quality Monitoring using Link-Quality-Report (LQR), RFC1989
+
+// lqrPeriodUnit is the unit of LQR Reporting-Period, hundredths of a second
+const lqrPeriodUnit = 10 * time.Millisecond
+
+// LCPOpQualityProto is the LCP Quality-Protocol option, only LQR is supported
+type LCPOpQualityProto struct {
+	Proto PPPProtocolNumber
+	// Payload is the data field after protocol, it is the Reporting-Period if Proto is LQR
+	Payload []byte
+}
+
+// NewLQROp returns a new LCPOpQualityProto of LQR with Reporting-Period period, which is the max time between LQRs sent by peer;
+// period is rounded down to hundredths of a second, 0 means peer only sends LQR upon receiving one
+func NewLQROp(period time.Duration) *LCPOpQualityProto {
+	r := &LCPOpQualityProto{Proto: ProtoLinkQualityReport, Payload: make([]byte, 4)}
+	binary.BigEndian.PutUint32(r.Payload, uint32(period/lqrPeriodUnit))
+	return r
+}
+
+// ReportingPeriod returns the LQR Reporting-Period, 0 if Proto is not LQR
+func (qp LCPOpQualityProto) ReportingPeriod() time.Duration {
+	if qp.Proto != ProtoLinkQualityReport || len(qp.Payload) < 4 {
+		return 0
+	}
+	return time.Duration(binary.BigEndian.Uint32(qp.Payload[:4])) * lqrPeriodUnit
+}
+
+// Type implements Option interface
+func (qp LCPOpQualityProto) Type() uint8 {
+	return uint8(OpTypeQualityProtocol)
+}
+
+// Serialize implements Option interface
+func (qp LCPOpQualityProto) Serialize() ([]byte, error) {
+	if len(qp.Payload) > 251 {
+		return nil, fmt.Errorf("%v option payload is too long", OpTypeQualityProtocol)
+	}
+	buf := make([]byte, 4)
+	buf[0] = byte(OpTypeQualityProtocol)
+	buf[1] = byte(4 + len(qp.Payload))
+	binary.BigEndian.PutUint16(buf[2:4], uint16(qp.Proto))
+	return append(buf, qp.Payload...), nil
+}
+
+// GetPayload implements Option interface
+func (qp LCPOpQualityProto) GetPayload() []byte {
+	return qp.Payload
+}
+
+// Equal implements Option interface
+func (qp LCPOpQualityProto) Equal(b Option) bool {
+	other, ok := b.(*LCPOpQualityProto)
+	if !ok {
+		return false
+	}
+	return qp.Proto == other.Proto && string(qp.Payload) == string(other.Payload)
+}
+
+// Parse implements Option interface
+func (qp *LCPOpQualityProto) Parse(buf []byte) (int, error) {
+	if len(buf) < 4 || buf[0] != byte(OpTypeQualityProtocol) || buf[1] < 4 || int(buf[1]) > len(buf) {
+		return 0, fmt.Errorf("not a valid %v option", OpTypeQualityProtocol)
+	}
+	qp.Proto = PPPProtocolNumber(binary.BigEndian.Uint16(buf[2:4]))
+	qp.Payload = buf[4:buf[1]]
+	return int(buf[1]), nil
+}
+
+// String implements Option interface
+func (qp LCPOpQualityProto) String() string {
+	if qp.Proto == ProtoLinkQualityReport {
+		return fmt.Sprintf("%v:%v period:%v", OpTypeQualityProtocol, qp.Proto, qp.ReportingPeriod())
+	}
+	return fmt.Sprintf("%v:%v (%d)", OpTypeQualityProtocol, qp.Proto, len(qp.Payload))
+}
+
+// SetLQR requests peer to send LQR with Reporting-Period period, see NewLQROp
+func (own *DefaultOwnOptionRule) SetLQR(period time.Duration) {
+	own.mux.Lock()
+	defer own.mux.Unlock()
+	own.ownOptions.Del(uint8(OpTypeQualityProtocol))
+	own.ownOptions = append(own.ownOptions, NewLQROp(period))
+}
+
+// LQRPkt is the Link-Quality-Report pkt, RFC1989 section 2.6
+type LQRPkt struct {
+	MagicNum                                                              uint32
+	LastOutLQRs, LastOutPackets, LastOutOctets                            uint32
+	PeerInLQRs, PeerInPackets, PeerInDiscards, PeerInErrors, PeerInOctets uint32
+	PeerOutLQRs, PeerOutPackets, PeerOutOctets                            uint32
+}
+
+const lqrPktLen = 48
+
+func (pkt *LQRPkt) fields() []*uint32 {
+	return []*uint32{&pkt.MagicNum,
+		&pkt.LastOutLQRs, &pkt.LastOutPackets, &pkt.LastOutOctets,
+		&pkt.PeerInLQRs, &pkt.PeerInPackets, &pkt.PeerInDiscards, &pkt.PeerInErrors, &pkt.PeerInOctets,
+		&pkt.PeerOutLQRs, &pkt.PeerOutPackets, &pkt.PeerOutOctets}
+}
+
+// Serialize into bytes
+func (pkt *LQRPkt) Serialize() []byte {
+	buf := make([]byte, 0, lqrPktLen)
+	for _, f := range pkt.fields() {
+		buf = binary.BigEndian.AppendUint32(buf, *f)
+	}
+	return buf
+}
+
+// Parse buf into pkt
+func (pkt *LQRPkt) Parse(buf []byte) error {
+	if len(buf) < lqrPktLen {
+		return fmt.Errorf("invalid LQR packet length %d", len(buf))
+	}
+	for i, f := range pkt.fields() {
+		*f = binary.BigEndian.Uint32(buf[i*4 : i*4+4])
+	}
+	return nil
+}
+
+// LinkQuality is the link quality in one direction during an interval, calculated from received LQRs as in RFC1989 section 2.7;
+// lost could be negative due to pkts in flight when counters are taken
+type LinkQuality struct {
+	// LQRs, Packets and Octets are number of LQR, pkts and octets sent
+	LQRs, Packets, Octets uint32
+	// LostLQRs, LostPackets and LostOctets are number of LQR, pkts and octets sent but not received
+	LostLQRs, LostPackets, LostOctets int64
+	// Discards and Errors are number of pkts discarded and with errors by receiver
+	Discards, Errors uint32
+}
+
+// Quality returns the ratio of pkts received, 1 if no pkt is sent
+func (q LinkQuality) Quality() float64 {
+	if q.Packets == 0 {
+		return 1
+	}
+	r := 1 - float64(q.LostPackets)/float64(q.Packets)
+	if r < 0 {
+		return 0
+	}
+	if r > 1 {
+		return 1
+	}
+	return r
+}
+
+func (q LinkQuality) String() string {
+	return fmt.Sprintf("quality %.2f%%, pkts %d lost %d, octets %d lost %d, LQRs %d lost %d, discards %d, errors %d",
+		q.Quality()*100, q.Packets, q.LostPackets, q.Octets, q.LostOctets, q.LQRs, q.LostLQRs, q.Discards, q.Errors)
+}
+
+// LQRStats is the statistics of LQR and link quality
+type LQRStats struct {
+	// Sent and Received are number of LQR sent and received
+	Sent, Received uint32
+	// Inbound and Outbound are link quality between last two received LQRs
+	Inbound, Outbound LinkQuality
+	// InboundTotal and OutboundTotal are link quality since 1st received LQR
+	InboundTotal, OutboundTotal LinkQuality
+}
+
+func (stats LQRStats) String() string {
+	return fmt.Sprintf("sent %d, received %d, inbound: %v, outbound: %v", stats.Sent, stats.Received, stats.Inbound, stats.Outbound)
+}
+
+// lqrRecord is a received LQR with the save fields, RFC1989 section 2.7
+type lqrRecord struct {
+	LQRPkt
+	saveInLQRs, saveInPackets, saveInDiscards, saveInErrors, saveInOctets uint32
+}
+
+// lost returns sent-rcvd, which could be negative; sent and rcvd are counter deltas computed as uint32 by the caller, so counter wraparound is already handled
+func lost(sent, rcvd uint32) int64 {
+	return int64(sent) - int64(rcvd)
+}
+
+// linkQuality returns inbound and outbound LinkQuality between received LQR from and to
+func linkQuality(from, to *lqrRecord) (inbound, outbound LinkQuality) {
+	inbound = LinkQuality{
+		LQRs:     to.PeerOutLQRs - from.PeerOutLQRs,
+		Packets:  to.PeerOutPackets - from.PeerOutPackets,
+		Octets:   to.PeerOutOctets - from.PeerOutOctets,
+		Discards: to.saveInDiscards - from.saveInDiscards,
+		Errors:   to.saveInErrors - from.saveInErrors,
+	}
+	inbound.LostLQRs = lost(inbound.LQRs, to.saveInLQRs-from.saveInLQRs)
+	inbound.LostPackets = lost(inbound.Packets, to.saveInPackets-from.saveInPackets)
+	inbound.LostOctets = lost(inbound.Octets, to.saveInOctets-from.saveInOctets)
+	outbound = LinkQuality{
+		LQRs:     to.LastOutLQRs - from.LastOutLQRs,
+		Packets:  to.LastOutPackets - from.LastOutPackets,
+		Octets:   to.LastOutOctets - from.LastOutOctets,
+		Discards: to.PeerInDiscards - from.PeerInDiscards,
+		Errors:   to.PeerInErrors - from.PeerInErrors,
+	}
+	outbound.LostLQRs = lost(outbound.LQRs, to.PeerInLQRs-from.PeerInLQRs)
+	outbound.LostPackets = lost(outbound.Packets, to.PeerInPackets-from.PeerInPackets)
+	outbound.LostOctets = lost(outbound.Octets, to.PeerInOctets-from.PeerInOctets)
+	return
+}
+
+// lqrMonitor sends and processes LQR after LCP is opened
+type lqrMonitor struct {
+	lcp      *LCP
+	sendChan chan []byte
+	recvChan chan []byte
+	// txPeriod is the Reporting-Period requested by peer, 0 means sending LQR upon receiving one
+	txPeriod time.Duration
+	// rx is true if own Reporting-Period is acked, i.e. peer sends LQR
+	rx bool
+	// tx is true if peer's Reporting-Period is acked
+	tx         bool
+	cancel     context.CancelFunc
+	lock       *sync.Mutex
+	outLQRs    uint32
+	inLQRs     uint32
+	first      *lqrRecord
+	prev, last *lqrRecord
+}
+
+// lqrFrameLen is the length of a LQR PPP frame, protocol field is never compressed
+const lqrFrameLen = 2 + lqrPktLen
+
+// startLQR starts a lqrMonitor if LQR is negotiated in either direction, called upon LCP layer up
+func (lcp *LCP) startLQR(ctx context.Context) {
+	m := &lqrMonitor{
+		lcp:  lcp,
+		lock: new(sync.Mutex),
+	}
+	if op, ok := lcp.OwnRule.GetOption(uint8(OpTypeQualityProtocol)).(*LCPOpQualityProto); ok && op.Proto == ProtoLinkQualityReport {
+		m.rx = true
+	}
+	if op, ok := lcp.PeerRule.GetOptions().GetFirst(uint8(OpTypeQualityProtocol)).(*LCPOpQualityProto); ok && op.Proto == ProtoLinkQualityReport {
+		m.tx = true
+		m.txPeriod = op.ReportingPeriod()
+	}
+	if !m.rx && !m.tx {
+		return
+	}
+	lcp.logger.Sugar().Infof("starting LQR, peer sends LQR: %v, sending LQR: %v, period: %v", m.rx, m.tx, m.txPeriod)
+	m.sendChan, m.recvChan = lcp.ppp.Register(ProtoLinkQualityReport)
+	var childctx context.Context
+	childctx, m.cancel = context.WithCancel(ctx)
+	lcp.lqr.Store(m)
+	go m.run(childctx)
+}
+
+// stopLQR stops current lqrMonitor, called upon LCP layer down
+func (lcp *LCP) stopLQR() {
+	m := lcp.lqr.Load()
+	if m == nil || m.cancel == nil {
+		return
+	}
+	m.lock.Lock()
+	cancel := m.cancel
+	m.cancel = nil
+	m.lock.Unlock()
+	if cancel != nil {
+		cancel()
+		lcp.ppp.UnRegister(ProtoLinkQualityReport)
+	}
+}
+
+// lqrNotify wraps h, starts LQR upon LCP layer up if it is negotiated, and stops it upon LCP layer down
+func (lcp *LCP) lqrNotify(h LayerNotifyHandler) LayerNotifyHandler {
+	return func(ctx context.Context, evt LayerNotifyEvent) {
+		switch evt {
+		case LCPLayerNotifyUp:
+			lcp.startLQR(ctx)
+		case LCPLayerNotifyDown, LCPLayerNotifyFinished:
+			lcp.stopLQR()
+		}
+		h(ctx, evt)
+	}
+}
+
+func (m *lqrMonitor) run(ctx context.Context) {
+	var tick <-chan time.Time
+	if m.tx && m.txPeriod > 0 {
+		ticker := time.NewTicker(m.txPeriod)
+		defer ticker.Stop()
+		tick = ticker.C
+		m.send()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			m.send()
+		case b, ok := <-m.recvChan:
+			if !ok {
+				return
+			}
+			m.rcvd(b)
+		}
+	}
+}
+
+// send sends a LQR, RFC1989 section 2.6
+func (m *lqrMonitor) send() {
+	ppp := m.lcp.ppp
+	m.lock.Lock()
+	m.outLQRs++
+	pkt := &LQRPkt{
+		MagicNum:    m.lcp.ownMagicNum(),
+		PeerOutLQRs: m.outLQRs,
+		// including this LQR
+		PeerOutPackets: ppp.outPackets.Load() + 1,
+		PeerOutOctets:  ppp.outOctets.Load() + lqrFrameLen,
+	}
+	if last := m.last; last != nil {
+		pkt.LastOutLQRs, pkt.LastOutPackets, pkt.LastOutOctets = last.PeerOutLQRs, last.PeerOutPackets, last.PeerOutOctets
+		pkt.PeerInLQRs, pkt.PeerInPackets, pkt.PeerInDiscards = last.saveInLQRs, last.saveInPackets, last.saveInDiscards
+		pkt.PeerInErrors, pkt.PeerInOctets = last.saveInErrors, last.saveInOctets
+	}
+	m.lock.Unlock()
+	m.lcp.logger.Sugar().Debugf("sending LQR: %+v", *pkt)
+	m.sendChan <- NewPPPPkt(pkt.Serialize(), ProtoLinkQualityReport).Serialize()
+}
+
+// rcvd processes a received LQR, RFC1989 section 2.7
+func (m *lqrMonitor) rcvd(buf []byte) {
+	rec := new(lqrRecord)
+	if err := rec.Parse(buf); err != nil {
+		m.lcp.logger.Sugar().Warnf("invalid LQR pkt, %v", err)
+		return
+	}
+	m.lcp.logger.Sugar().Debugf("got LQR: %+v", rec.LQRPkt)
+	ppp := m.lcp.ppp
+	m.lock.Lock()
+	m.inLQRs++
+	rec.saveInLQRs = m.inLQRs
+	rec.saveInPackets = ppp.inPackets.Load()
+	rec.saveInDiscards = ppp.inDiscards.Load()
+	rec.saveInErrors = ppp.inErrors.Load()
+	rec.saveInOctets = ppp.inOctets.Load()
+	if m.first == nil {
+		m.first = rec
+	}
+	m.prev, m.last = m.last, rec
+	m.lock.Unlock()
+	if m.tx && m.txPeriod == 0 {
+		m.send()
+	}
+}
+
+func (m *lqrMonitor) stats() LQRStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r := LQRStats{
+		Sent:     m.outLQRs,
+		Received: m.inLQRs,
+	}
+	if m.prev != nil {
+		r.Inbound, r.Outbound = linkQuality(m.prev, m.last)
+		r.InboundTotal, r.OutboundTotal = linkQuality(m.first, m.last)
+	}
+	return r
+}
+
+// LQRStats returns the LQR statistics and link quality of last time LCP is opened, zero if LQR is not negotiated
+func (lcp *LCP) LQRStats() LQRStats {
+	if m := lcp.lqr.Load(); m != nil {
+		return m.stats()
+	}
+	return LQRStats{}
+}
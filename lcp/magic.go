@@ -0,0 +1,83 @@
+package lcp
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+)
+
+// magic-number loopback detection, see RFC1661 section 6.4
+
+// newMagicNum returns a random non-zero magic number different from old
+func newMagicNum(old uint32) uint32 {
+	for {
+		if r := rand.Uint32(); r != 0 && r != old {
+			return r
+		}
+	}
+}
+
+// ownMagicNum returns own magic number, 0 if magic number option is not used
+func (lcp *LCP) ownMagicNum() uint32 {
+	if mn := lcp.OwnRule.GetOption(uint8(OpTypeMagicNumber)); mn != nil {
+		return uint32(*(mn.(*LCPOpMagicNum)))
+	}
+	return 0
+}
+
+// checkConfReqMagicNum returns a magic number option with a new magic number to NAK,
+// if rcvd conf-req contains own magic number, which means the link might be looped-back; otherwise returns nil
+func (lcp *LCP) checkConfReqMagicNum(req *Pkt) Option {
+	if lcp.protoType != ProtoLCP {
+		return nil
+	}
+	own := lcp.ownMagicNum()
+	if own == 0 {
+		return nil
+	}
+	for _, o := range Options(req.Options).Get(uint8(OpTypeMagicNumber)) {
+		if uint32(*(o.(*LCPOpMagicNum))) != own {
+			continue
+		}
+		lcp.logger.Sugar().Warnf("rcvd conf-req with own magic number %#x, link might be looped-back", own)
+		mn := LCPOpMagicNum(newMagicNum(own))
+		atomic.StoreUint32(lcp.nakedMagicNum, uint32(mn))
+		return &mn
+	}
+	return nil
+}
+
+// checkConfNAKMagicNum returns true if rcvd conf-nak contains the magic number in last sent conf-nak, which means the link is looped-back
+func (lcp *LCP) checkConfNAKMagicNum(nak *Pkt) bool {
+	if lcp.protoType != ProtoLCP {
+		return false
+	}
+	naked := atomic.LoadUint32(lcp.nakedMagicNum)
+	if naked == 0 {
+		return false
+	}
+	for _, o := range Options(nak.Options).Get(uint8(OpTypeMagicNumber)) {
+		if uint32(*(o.(*LCPOpMagicNum))) == naked {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEchoMagicNum returns true if rcvd echo-request/echo-reply contains own magic number, which means the link is looped-back
+func (lcp *LCP) checkEchoMagicNum(pkt *Pkt) bool {
+	if lcp.protoType != ProtoLCP {
+		return false
+	}
+	own := lcp.ownMagicNum()
+	return own != 0 && pkt.MagicNum == own
+}
+
+// loopedBack reports looped-back to the LayerNotifyHandler, only once
+func (lcp *LCP) loopedBack(ctx context.Context) {
+	if !atomic.CompareAndSwapUint32(lcp.isLoopedBack, 0, 1) {
+		return
+	}
+	lcp.logger.Error("link is looped-back")
+	lcp.layerNotify(ctx, LCPLayerNotifyLoopedBack)
+}
@@ -169,6 +169,8 @@ const (
 	LCPLayerNotifyDown
 	LCPLayerNotifyStarted
 	LCPLayerNotifyFinished
+	// LCPLayerNotifyLoopedBack is not defined in RFC1661, it means the link is detected as looped-back by magic number
+	LCPLayerNotifyLoopedBack
 )
 
 func (n LayerNotifyEvent) String() string {
@@ -181,6 +183,8 @@ func (n LayerNotifyEvent) String() string {
 		return "started"
 	case LCPLayerNotifyFinished:
 		return "finished"
+	case LCPLayerNotifyLoopedBack:
+		return "looped-back"
 	}
 	return fmt.Sprintf("unknown (%d)", n)
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hujun-open/etherconn"
@@ -17,22 +18,41 @@ import (
 type PPPPkt struct {
 	Proto   PPPProtocolNumber
 	Payload []byte
+	// PFC means the protocol field is compressed into 1 byte, as negotiated by LCP Protocol-Field-Compression option;
+	// only protocol number less than 0x100 could be compressed
+	PFC bool
 }
 
-// Serialize into bytes, without copying, and no padding
+// Serialize into bytes, without copying, and no padding;
+// the protocol field is compressed if PFC is true and Proto could be compressed
 func (ppppkt *PPPPkt) Serialize() []byte {
+	if ppppkt.PFC && ppppkt.Proto < 0x100 {
+		return append([]byte{byte(ppppkt.Proto)}, ppppkt.Payload...)
+	}
 	buf := make([]byte, 2)
 	binary.BigEndian.PutUint16(buf, uint16(ppppkt.Proto))
 	return append(buf, ppppkt.Payload...)
 }
 
-// Parse buf into PPPPkt
+// Parse buf into PPPPkt, both compressed and uncompressed protocol field are accepted,
+// a compressed one is identified by the least significant bit of 1st byte as in RFC1661 section 6.5;
+// leading address and control field (0xff03) is skipped if present
 func (ppppkt *PPPPkt) Parse(buf []byte) error {
+	if len(buf) > 2 && buf[0] == 0xff && buf[1] == 0x03 {
+		buf = buf[2:]
+	}
+	if len(buf) > 1 && buf[0]&0x1 == 1 {
+		ppppkt.Proto = PPPProtocolNumber(buf[0])
+		ppppkt.Payload = buf[1:]
+		ppppkt.PFC = true
+		return nil
+	}
 	if len(buf) <= 2 {
 		return fmt.Errorf("invalid PPP packet length %d", len(buf))
 	}
 	ppppkt.Proto = PPPProtocolNumber(binary.BigEndian.Uint16(buf[:2]))
 	ppppkt.Payload = buf[2:]
+	ppppkt.PFC = false
 	return nil
 }
 
@@ -52,6 +72,12 @@ type PPP struct {
 	conn              net.PacketConn
 	logger            *zap.Logger
 	reqID             uint8 //used by send project-reject
+	// lastRecv is the unix nano time of last received pkt
+	lastRecv atomic.Int64
+	// txPFC means compressing protocol field of sent pkt, set by LCP
+	txPFC atomic.Bool
+	// counters used by LQR, as defined in RFC1989
+	outPackets, outOctets, inPackets, inOctets, inDiscards, inErrors atomic.Uint32
 }
 
 // NewPPP creates a new PPP protocol instance, using conn as underlying transport, l as logger;
@@ -70,8 +96,8 @@ func NewPPP(ctx context.Context, conn net.PacketConn, l *zap.Logger) *PPP {
 const (
 	relayChanDepth = 128
 	sendCHanDepth  = 128
-	// MaxPPPMsgSize specifies max length of a received PPP pkt
-	MaxPPPMsgSize = 1500
+	// MaxPPPMsgSize specifies max length of a received PPP pkt, big enough for baby jumbo frame as in RFC4638
+	MaxPPPMsgSize = 2048
 )
 
 // Register a new protocol to run over ppp;
@@ -94,6 +120,14 @@ func (ppp *PPP) UnRegister(p PPPProtocolNumber) {
 	ppp.relayChanListLock.Unlock()
 }
 
+// LastRecv returns the time of last received pkt of any protocol, zero if nothing is received
+func (ppp *PPP) LastRecv() time.Time {
+	if n := ppp.lastRecv.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
 // GetLogger return the logger
 func (ppp *PPP) GetLogger() *zap.Logger {
 	return ppp.logger
@@ -106,10 +140,17 @@ func (ppp *PPP) send(ctx context.Context) {
 			ppp.logger.Info("ppp send routined stopped")
 			return
 		case b := <-ppp.sendChan:
+			if ppp.txPFC.Load() && len(b) > 2 && b[0] == 0 {
+				// protocol number is less than 0x100, i.e. not LCP/NCP
+				b = b[1:]
+			}
 			_, err := ppp.conn.WriteTo(b, nil)
 			if err != nil {
 				ppp.logger.Sugar().Warnf("failed to send pkt,%v", err)
+				continue
 			}
+			ppp.outPackets.Add(1)
+			ppp.outOctets.Add(uint32(len(b)))
 		}
 	}
 
@@ -135,23 +176,24 @@ func (ppp *PPP) recv(ctx context.Context) {
 			ppp.logger.Sugar().Errorf("failed to recv,%v", err)
 			return
 		}
+		ppp.lastRecv.Store(time.Now().UnixNano())
 		go ppp.relay(buf[:n])
 	}
 }
 
-// b is the recvd unknown protocol pkt
-func (ppp *PPP) sendProtocolRejct(b []byte) {
-	proto := make([]byte, 2)
-	copy(proto, b[:2])
-	switch PPPProtocolNumber(binary.BigEndian.Uint16(proto)) {
+// rcvd is the recvd unknown protocol pkt
+func (ppp *PPP) sendProtocolRejct(rcvd *PPPPkt) {
+	switch rcvd.Proto {
 	case ProtoCHAP, ProtoIPCP, ProtoLCP, ProtoPAP, ProtoIPv6CP, ProtoIPv4, ProtoIPv6:
 		return
 	}
+	proto := make([]byte, 2)
+	binary.BigEndian.PutUint16(proto, uint16(rcvd.Proto))
 	pkt := NewPkt(ProtoLCP)
 	pkt.Code = CodeProtocolReject
 	ppp.reqID++
 	pkt.ID = ppp.reqID
-	pkt.Payload = append(proto, b...)
+	pkt.Payload = append(proto, rcvd.Payload...)
 	pktbytes, err := pkt.Serialize()
 	if err == nil {
 		ppppkt := NewPPPPkt(pktbytes, ProtoLCP)
@@ -161,13 +203,20 @@ func (ppp *PPP) sendProtocolRejct(b []byte) {
 }
 
 func (ppp *PPP) relay(buf []byte) {
-	if len(buf) <= 2 {
+	pkt := new(PPPPkt)
+	if err := pkt.Parse(buf); err != nil {
+		ppp.inErrors.Add(1)
 		return
 	}
+	ppp.inPackets.Add(1)
+	ppp.inOctets.Add(uint32(len(buf)))
 	ppp.relayChanListLock.RLock()
 	defer ppp.relayChanListLock.RUnlock()
-	if ch, ok := ppp.relayChanList[PPPProtocolNumber(binary.BigEndian.Uint16(buf[:2]))]; ok {
-		ch <- buf[2:]
+	if ch, ok := ppp.relayChanList[pkt.Proto]; ok {
+		ch <- pkt.Payload
+		// a registered protocol must not be protocol-rejected
+		return
 	}
-	go ppp.sendProtocolRejct(buf)
+	ppp.inDiscards.Add(1)
+	go ppp.sendProtocolRejct(pkt)
 }
@@ -0,0 +1,107 @@
+package loopback
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+)
+
+// Verdict is the result of a Filter
+type Verdict int
+
+const (
+	// Pass means the pkt is delivered to the peer
+	Pass Verdict = iota
+	// Drop means the pkt is dropped
+	Drop
+	// Loop means the pkt is delivered back to the sender
+	Loop
+)
+
+// Filter is called with every pkt written into a PacketConn, the returned Verdict decides how the pkt is delivered
+type Filter func(p []byte) Verdict
+
+// PacketConn is one end of an in-process net.PacketConn pair, pkt written into one PacketConn is read from its peer;
+// it allows PPP layers (LCP, PAP, CHAP, EAP...) to run against each other in one process, e.g. in tests;
+// ReadFrom returns etherconn.ErrTimeOut when read deadline expires, as etherconn does
+type PacketConn struct {
+	peer     *PacketConn
+	recvChan chan []byte
+	// deadline is the read deadline in unix nano, 0 means no deadline
+	deadline atomic.Int64
+	filter   atomic.Pointer[Filter]
+}
+
+// NewPacketConnPair returns a pair of connected PacketConn
+func NewPacketConnPair() (*PacketConn, *PacketConn) {
+	a := &PacketConn{recvChan: make(chan []byte, DefaultChanDepth)}
+	b := &PacketConn{recvChan: make(chan []byte, DefaultChanDepth)}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+// SetFilter sets the Filter applied to pkts written into pc, nil means all pkts pass
+func (pc *PacketConn) SetFilter(f Filter) {
+	if f == nil {
+		pc.filter.Store(nil)
+		return
+	}
+	pc.filter.Store(&f)
+}
+
+// ReadFrom implements net.PacketConn interface, returned addr is always nil
+func (pc *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	var timeout <-chan time.Time
+	if d := pc.deadline.Load(); d != 0 {
+		t := time.NewTimer(time.Until(time.Unix(0, d)))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case b := <-pc.recvChan:
+		return copy(p, b), nil, nil
+	case <-timeout:
+		return 0, nil, etherconn.ErrTimeOut
+	}
+}
+
+// WriteTo implements net.PacketConn interface, addr is ignored
+func (pc *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	dst := pc.peer
+	if f := pc.filter.Load(); f != nil {
+		switch (*f)(p) {
+		case Drop:
+			return len(p), nil
+		case Loop:
+			dst = pc
+		}
+	}
+	b := make([]byte, len(p))
+	copy(b, p)
+	dst.recvChan <- b
+	return len(p), nil
+}
+
+// Close implements net.PacketConn interface, it does nothing
+func (pc *PacketConn) Close() error { return nil }
+
+// LocalAddr implements net.PacketConn interface, it always returns nil
+func (pc *PacketConn) LocalAddr() net.Addr { return nil }
+
+// SetDeadline implements net.PacketConn interface, only read deadline is supported
+func (pc *PacketConn) SetDeadline(t time.Time) error { return pc.SetReadDeadline(t) }
+
+// SetReadDeadline implements net.PacketConn interface, zero t means no deadline
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		pc.deadline.Store(0)
+	} else {
+		pc.deadline.Store(t.UnixNano())
+	}
+	return nil
+}
+
+// SetWriteDeadline implements net.PacketConn interface, it does nothing since WriteTo never blocks unless peer's queue is full
+func (pc *PacketConn) SetWriteDeadline(t time.Time) error { return nil }
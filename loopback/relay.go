@@ -0,0 +1,207 @@
+// Package loopback implements an in-process etherconn.PacketRelay pair, like a veth pair,
+// Ethernet frames sent via one Relay are received by its peer;
+// it allows PPPoE client and its peers (e.g. pppoe.AC) to exchange frames in one process without privilege, e.g. in tests
+package loopback
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hujun-open/etherconn"
+)
+
+// DefaultChanDepth is the default depth of send channel and per EtherConn receive channel
+const DefaultChanDepth = 1024
+
+const (
+	etherHeaderLen = 14
+	vlanTagLen     = 4
+)
+
+// Relay is one end of a loopback relay pair, it implements etherconn.PacketRelay interface;
+// frame is dropped if receiver's channel is full, as a real relay does
+type Relay struct {
+	ifName          string
+	peer            *Relay
+	lock            *sync.RWMutex
+	recvList        map[etherconn.L2EndpointKey]chan *etherconn.RelayReceival
+	multicastList   map[etherconn.L2EndpointKey]chan *etherconn.RelayReceival
+	defaultRecvChan chan *etherconn.RelayReceival
+	sendChan        chan []byte
+	stopChan        chan struct{}
+	onceStop        *sync.Once
+	stats           *etherconn.RelayPacketStats
+}
+
+// NewRelayPair returns a pair of connected Relay, with interface name ifA and ifB
+func NewRelayPair(ifA, ifB string) (*Relay, *Relay) {
+	a, b := newRelay(ifA), newRelay(ifB)
+	a.peer, b.peer = b, a
+	go a.forward()
+	go b.forward()
+	return a, b
+}
+
+func newRelay(ifname string) *Relay {
+	return &Relay{
+		ifName:          ifname,
+		lock:            new(sync.RWMutex),
+		recvList:        make(map[etherconn.L2EndpointKey]chan *etherconn.RelayReceival),
+		multicastList:   make(map[etherconn.L2EndpointKey]chan *etherconn.RelayReceival),
+		defaultRecvChan: make(chan *etherconn.RelayReceival, DefaultChanDepth),
+		sendChan:        make(chan []byte, DefaultChanDepth),
+		stopChan:        make(chan struct{}),
+		onceStop:        new(sync.Once),
+		stats:           newStats(),
+	}
+}
+
+func newStats() *etherconn.RelayPacketStats {
+	return &etherconn.RelayPacketStats{
+		Tx:                 new(uint64),
+		RxOffered:          new(uint64),
+		RxInvalid:          new(uint64),
+		RxBufferFull:       new(uint64),
+		RxMiss:             new(uint64),
+		Rx:                 new(uint64),
+		RxDefault:          new(uint64),
+		RxNonHitMulticast:  new(uint64),
+		RxMulticastIgnored: new(uint64),
+	}
+}
+
+// forward delivers frames sent via relay to its peer, until relay stops
+func (relay *Relay) forward() {
+	for {
+		select {
+		case <-relay.stopChan:
+			return
+		case p := <-relay.sendChan:
+			atomic.AddUint64(relay.stats.Tx, 1)
+			relay.peer.deliver(p)
+		}
+	}
+}
+
+// deliver parses the received frame p and sends it to the receiver
+func (relay *Relay) deliver(p []byte) {
+	atomic.AddUint64(relay.stats.RxOffered, 1)
+	rcv := parseFrame(p)
+	if rcv == nil {
+		atomic.AddUint64(relay.stats.RxInvalid, 1)
+		return
+	}
+	relay.lock.RLock()
+	defer relay.lock.RUnlock()
+	if ch, ok := relay.recvList[rcv.LocalEndpoint.GetKey()]; ok {
+		relay.sendTo(ch, rcv, relay.stats.Rx)
+		return
+	}
+	if p[0]&0x1 == 1 {
+		if len(relay.multicastList) == 0 {
+			atomic.AddUint64(relay.stats.RxMulticastIgnored, 1)
+		}
+		for _, ch := range relay.multicastList {
+			relay.sendTo(ch, rcv, relay.stats.RxNonHitMulticast)
+		}
+	} else {
+		atomic.AddUint64(relay.stats.RxMiss, 1)
+	}
+	relay.sendTo(relay.defaultRecvChan, rcv, relay.stats.RxDefault)
+}
+
+// sendTo sends rcv to ch without blocking, counter is increased if it is sent
+func (relay *Relay) sendTo(ch chan *etherconn.RelayReceival, rcv *etherconn.RelayReceival, counter *uint64) {
+	select {
+	case ch <- rcv:
+		atomic.AddUint64(counter, 1)
+	default:
+		atomic.AddUint64(relay.stats.RxBufferFull, 1)
+	}
+}
+
+// parseFrame returns a RelayReceival of Ethernet frame p, nil if p is invalid
+func parseFrame(p []byte) *etherconn.RelayReceival {
+	if len(p) < etherHeaderLen {
+		return nil
+	}
+	buf := make([]byte, len(p))
+	copy(buf, p)
+	rcv := &etherconn.RelayReceival{
+		LocalEndpoint:  &etherconn.L2Endpoint{HwAddr: net.HardwareAddr(buf[:6]), VLANs: []uint16{}},
+		RemoteEndpoint: &etherconn.L2Endpoint{HwAddr: net.HardwareAddr(buf[6:12])},
+		EtherBytes:     buf,
+	}
+	index := 12
+	for {
+		if index+2 > len(buf) {
+			return nil
+		}
+		etype := binary.BigEndian.Uint16(buf[index : index+2])
+		if etype != 0x8100 && etype != 0x88a8 {
+			rcv.LocalEndpoint.Etype = etype
+			break
+		}
+		if index+vlanTagLen+2 > len(buf) {
+			return nil
+		}
+		rcv.LocalEndpoint.VLANs = append(rcv.LocalEndpoint.VLANs, binary.BigEndian.Uint16(buf[index+2:index+4])&0xfff)
+		index += vlanTagLen
+	}
+	rcv.EtherPayloadBytes = buf[index+2:]
+	rcv.RemoteEndpoint.VLANs = rcv.LocalEndpoint.VLANs
+	rcv.RemoteEndpoint.Etype = rcv.LocalEndpoint.Etype
+	return rcv
+}
+
+// Register implements etherconn.PacketRelay interface
+func (relay *Relay) Register(ks []etherconn.L2EndpointKey, recvMulticast bool) (chan *etherconn.RelayReceival, chan []byte, chan struct{}) {
+	ch := make(chan *etherconn.RelayReceival, DefaultChanDepth)
+	relay.lock.Lock()
+	defer relay.lock.Unlock()
+	for _, k := range ks {
+		relay.recvList[k] = ch
+	}
+	if recvMulticast && len(ks) > 0 {
+		// only use one key, otherwise EtherConn receives multiple copies
+		relay.multicastList[ks[0]] = ch
+	}
+	return ch, relay.sendChan, relay.stopChan
+}
+
+// RegisterDefault implements etherconn.PacketRelay interface
+func (relay *Relay) RegisterDefault() (chan *etherconn.RelayReceival, chan []byte, chan struct{}) {
+	return relay.defaultRecvChan, relay.sendChan, relay.stopChan
+}
+
+// Deregister implements etherconn.PacketRelay interface
+func (relay *Relay) Deregister(ks []etherconn.L2EndpointKey) {
+	relay.lock.Lock()
+	defer relay.lock.Unlock()
+	for _, k := range ks {
+		delete(relay.recvList, k)
+		delete(relay.multicastList, k)
+	}
+}
+
+// Stop implements etherconn.PacketRelay interface, it stops forwarding frames sent via relay
+func (relay *Relay) Stop() {
+	relay.onceStop.Do(func() { close(relay.stopChan) })
+}
+
+// IfName implements etherconn.PacketRelay interface
+func (relay *Relay) IfName() string {
+	return relay.ifName
+}
+
+// GetStats implements etherconn.PacketRelay interface
+func (relay *Relay) GetStats() *etherconn.RelayPacketStats {
+	return relay.stats
+}
+
+// Type implements etherconn.PacketRelay interface
+func (relay *Relay) Type() etherconn.RelayType {
+	return etherconn.UnknownRelay
+}
@@ -0,0 +1,105 @@
+// relay_test
+package loopback
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+)
+
+func TestRelay(t *testing.T) {
+	relayA, relayB := NewRelayPair("a", "b")
+	defer relayA.Stop()
+	defer relayB.Stop()
+	macA := net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1}
+	macB := net.HardwareAddr{0x2, 0, 0, 0, 0, 0x2}
+	vlans := etherconn.VLANs{{ID: 100, EtherType: 0x8100}, {ID: 200, EtherType: 0x8100}}
+	etypes := []uint16{0x8863}
+	connA := etherconn.NewEtherConn(macA, relayA, etherconn.WithEtherTypes(etypes), etherconn.WithVLANs(vlans))
+	connB := etherconn.NewEtherConn(macB, relayB, etherconn.WithEtherTypes(etypes), etherconn.WithVLANs(vlans),
+		etherconn.WithRecvMulticast(true))
+	// unicast both ways
+	if _, err := connA.WritePktTo([]byte{1, 2, 3}, 0x8863, macB); err != nil {
+		t.Fatal(err)
+	}
+	connB.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf, l2ep, err := connB.ReadPkt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte{1, 2, 3}) || !bytes.Equal(l2ep.HwAddr, macA) {
+		t.Fatalf("B got unexpected pkt %x from %v", buf, l2ep.HwAddr)
+	}
+	if _, err = connB.WritePktTo([]byte{4, 5}, 0x8863, macA); err != nil {
+		t.Fatal(err)
+	}
+	connA.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if buf, _, err = connA.ReadPkt(); err != nil || !bytes.Equal(buf, []byte{4, 5}) {
+		t.Fatalf("A got unexpected pkt %x, %v", buf, err)
+	}
+	// broadcast is received by EtherConn receiving multicast
+	if _, err = connA.WritePktTo([]byte{6}, 0x8863, etherconn.BroadCastMAC); err != nil {
+		t.Fatal(err)
+	}
+	connB.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if buf, _, err = connB.ReadPkt(); err != nil || !bytes.Equal(buf, []byte{6}) {
+		t.Fatalf("B got unexpected broadcast pkt %x, %v", buf, err)
+	}
+	// pkt to unknown MAC goes to default receiving channel
+	if _, err = connA.WritePktTo([]byte{7}, 0x8863, net.HardwareAddr{0x2, 0, 0, 0, 0, 0x3}); err != nil {
+		t.Fatal(err)
+	}
+	// multicast pkt is also sent to default receiving channel
+	defch, _, _ := relayB.RegisterDefault()
+	for _, expected := range [][]byte{{6}, {7}} {
+		select {
+		case rcv := <-defch:
+			if !bytes.Equal(rcv.EtherPayloadBytes, expected) {
+				t.Fatalf("expect pkt %x in default channel, got %x", expected, rcv.EtherPayloadBytes)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("pkt %x is not sent to default channel", expected)
+		}
+	}
+	if rx := atomic.LoadUint64(relayB.GetStats().Rx); rx != 1 {
+		t.Fatalf("expect B Rx 1, got %d", rx)
+	}
+	if tx := atomic.LoadUint64(relayA.GetStats().Tx); tx != 3 {
+		t.Fatalf("expect A Tx 3, got %d", tx)
+	}
+	// invalid frame
+	relayB.deliver([]byte{1, 2, 3})
+	if n := atomic.LoadUint64(relayB.GetStats().RxInvalid); n != 1 {
+		t.Fatalf("expect B RxInvalid 1, got %d", n)
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	a, b := NewPacketConnPair()
+	buf := make([]byte, 16)
+	verdict := Pass
+	a.SetFilter(func(p []byte) Verdict { return verdict })
+	for _, v := range []Verdict{Pass, Loop, Drop} {
+		verdict = v
+		if _, err := a.WriteTo([]byte{byte(v)}, nil); err != nil {
+			t.Fatal(err)
+		}
+		rcvr := map[Verdict]*PacketConn{Pass: b, Loop: a}[v]
+		for _, c := range []*PacketConn{a, b} {
+			c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := c.ReadFrom(buf)
+			if c == rcvr {
+				if err != nil || n != 1 || buf[0] != byte(v) {
+					t.Fatalf("verdict %d: expect pkt, got %x %v", v, buf[:n], err)
+				}
+			} else if !errors.Is(err, etherconn.ErrTimeOut) {
+				t.Fatalf("verdict %d: expect timeout, got %v", v, err)
+			}
+		}
+	}
+}
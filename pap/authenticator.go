@@ -0,0 +1,90 @@
+package pap
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/hujun-open/zouppp/auth"
+	"github.com/hujun-open/zouppp/lcp"
+
+	"go.uber.org/zap"
+)
+
+// Authenticator is the authenticator side of PAP, it validates peer's Authenticate-Request against a CredentialStore
+type Authenticator struct {
+	store    auth.CredentialStore
+	sendChan chan []byte
+	recvChan chan []byte
+	logger   *zap.Logger
+	timeout  time.Duration
+	retry    int
+}
+
+const (
+	defaultACKMsg = "Login ok"
+	defaultNAKMsg = "Login incorrect"
+)
+
+// NewAuthenticator creates a new PAP Authenticator use store to validate peer's credential;
+// uses pppProto as the underlying PPP protocol;
+func NewAuthenticator(store auth.CredentialStore, pppProto *lcp.PPP) *Authenticator {
+	r := new(Authenticator)
+	r.store = store
+	r.sendChan, r.recvChan = pppProto.Register(lcp.ProtoPAP)
+	r.logger = pppProto.GetLogger().Named("PAP")
+	r.timeout = DefaultTimeout
+	r.retry = DefaultRetry
+	return r
+}
+
+func (pa *Authenticator) sendResponse(req *Pkt, code Code, msg string) error {
+	resp := new(Pkt)
+	resp.Code = code
+	resp.ID = req.ID
+	resp.Msg = []byte(msg)
+	pktbytes, err := resp.Serialize()
+	if err != nil {
+		return err
+	}
+	pa.sendChan <- lcp.NewPPPPkt(pktbytes, lcp.ProtoPAP).Serialize()
+	pa.logger.Sugar().Debugf("sent PAP response:\n%v", resp)
+	return nil
+}
+
+// AuthPeer waits for peer's Authenticate-Request, replies Authenticate-Ack if the credential matches the one in store,
+// otherwise replies Authenticate-Nak; returns peer ID if authentication succeeds
+func (pa *Authenticator) AuthPeer() (string, error) {
+	t := time.NewTimer(pa.timeout * time.Duration(pa.retry))
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			return "", fmt.Errorf("timeout")
+		case rcvdbytes := <-pa.recvChan:
+			req := new(Pkt)
+			err := req.Parse(rcvdbytes)
+			if err != nil {
+				pa.logger.Sugar().Warnf("got a invalid PAP request, %v", err)
+				continue
+			}
+			if req.Code != CodeAuthRequest {
+				pa.logger.Sugar().Warnf("got a PAP non-request, %v", req.Code)
+				continue
+			}
+			pa.logger.Sugar().Debugf("got PAP auth request\n%v", req)
+			peerID := string(req.PeerID)
+			passwd, ok := pa.store.GetPassword(peerID)
+			if !ok || subtle.ConstantTimeCompare([]byte(passwd), req.Passwd) != 1 {
+				if err = pa.sendResponse(req, CodeAuthNAK, defaultNAKMsg); err != nil {
+					return "", fmt.Errorf("failed to send PAP NAK, %w", err)
+				}
+				return "", fmt.Errorf("auth failed for %v", peerID)
+			}
+			if err = pa.sendResponse(req, CodeAuthACK, defaultACKMsg); err != nil {
+				return "", fmt.Errorf("failed to send PAP ACK, %w", err)
+			}
+			return peerID, nil
+		}
+	}
+}
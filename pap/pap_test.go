@@ -0,0 +1,49 @@
+// pap_test
+package pap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hujun-open/zouppp/auth"
+	"github.com/hujun-open/zouppp/lcp"
+	"github.com/hujun-open/zouppp/loopback"
+	"go.uber.org/zap"
+)
+
+func TestPAP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	store := auth.NewStaticStore(map[string]string{"user1": "passwd1"})
+	testList := []struct {
+		passwd     string
+		shouldFail bool
+	}{
+		{passwd: "passwd1"},
+		{passwd: "wrongpasswd", shouldFail: true},
+	}
+	for i, c := range testList {
+		clntConn, srvConn := loopback.NewPacketConnPair()
+		clnt := NewPAP("user1", c.passwd, lcp.NewPPP(ctx, clntConn, logger.Named("clnt")))
+		srv := NewAuthenticator(store, lcp.NewPPP(ctx, srvConn, logger.Named("srv")))
+		clntErrCh := make(chan error, 1)
+		go func() {
+			clntErrCh <- clnt.AuthSelf()
+		}()
+		peer, srvErr := srv.AuthPeer()
+		clntErr := <-clntErrCh
+		if c.shouldFail {
+			if srvErr == nil || clntErr == nil {
+				t.Fatalf("case %d should fail but succeed", i)
+			}
+			continue
+		}
+		if srvErr != nil || clntErr != nil {
+			t.Fatalf("case %d failed, authenticator: %v, client: %v", i, srvErr, clntErr)
+		}
+		if peer != "user1" {
+			t.Fatalf("case %d authenticated unexpected peer %v", i, peer)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package pcapng implements a minimal pcapng (draft-ietf-opsawg-pcapng) writer for Ethernet frames,
+// with interface description and per packet comment
+package pcapng
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// block types
+const (
+	blockTypeSHB   = 0x0A0D0D0A
+	blockTypeIDB   = 0x00000001
+	blockTypeEPB   = 0x00000006
+	byteOrderMagic = 0x1A2B3C4D
+)
+
+// option codes
+const (
+	optEndOfOpt      = 0
+	optComment       = 1
+	optSHBUserAppl   = 4
+	optIfName        = 2
+	optIfDescription = 3
+	optIfTSResol     = 9
+)
+
+// LinkTypeEthernet is the link type of Ethernet
+const LinkTypeEthernet = 1
+
+// Writer writes Ethernet frames into pcapng format, it is safe for concurrent use;
+// each block is written to the underlying io.Writer with a single Write call, there is no buffering
+type Writer struct {
+	w        io.Writer
+	closer   io.Closer
+	lock     *sync.Mutex
+	numOfIfs uint32
+}
+
+// NewWriter returns a new Writer writes to w, the section header block with application name app is written
+func NewWriter(w io.Writer, app string) (*Writer, error) {
+	r := &Writer{
+		w:    w,
+		lock: new(sync.Mutex),
+	}
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], byteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1)
+	binary.LittleEndian.PutUint16(body[6:8], 0)
+	// section length is not specified
+	binary.LittleEndian.PutUint64(body[8:16], 0xFFFFFFFFFFFFFFFF)
+	body = appendOptions(body, option{optSHBUserAppl, []byte(app)})
+	if err := r.writeBlock(blockTypeSHB, body); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Create creates the file path and returns a Writer writes to it, Close closes the file
+func Create(path, app string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %v, %w", path, err)
+	}
+	r, err := NewWriter(f, app)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// AddInterface writes an Ethernet interface description block with name, description and comment, returns the interface id;
+// empty name/description/comment is not included
+func (w *Writer) AddInterface(name, description, comment string) (uint32, error) {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], LinkTypeEthernet)
+	// snap length 0 means no limit
+	body = appendOptions(body,
+		option{optIfName, []byte(name)},
+		option{optIfDescription, []byte(description)},
+		option{optComment, []byte(comment)},
+		// nanosecond resolution
+		option{optIfTSResol, []byte{9}},
+	)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.writeBlockLocked(blockTypeIDB, body); err != nil {
+		return 0, err
+	}
+	w.numOfIfs++
+	return w.numOfIfs - 1, nil
+}
+
+// WritePacket writes frame received or sent at t on interface ifid as an enhanced packet block, with comment if it is not empty
+func (w *Writer) WritePacket(ifid uint32, t time.Time, frame []byte, comment string) error {
+	body := make([]byte, 20, 20+len(frame)+len(comment)+16)
+	ts := uint64(t.UnixNano())
+	binary.LittleEndian.PutUint32(body[0:4], ifid)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(len(frame)))
+	body = append(body, pad(frame)...)
+	body = appendOptions(body, option{optComment, []byte(comment)})
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if ifid >= w.numOfIfs {
+		return fmt.Errorf("interface %d doesn't exist", ifid)
+	}
+	return w.writeBlockLocked(blockTypeEPB, body)
+}
+
+// Close closes the underlying file if the Writer is created by Create
+func (w *Writer) Close() error {
+	if w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
+
+func (w *Writer) writeBlock(t uint32, body []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.writeBlockLocked(t, body)
+}
+
+// writeBlockLocked writes a block of type t with body, body must be padded to 32-bit boundary
+func (w *Writer) writeBlockLocked(t uint32, body []byte) error {
+	l := uint32(len(body) + 12)
+	buf := make([]byte, 8, l)
+	binary.LittleEndian.PutUint32(buf[0:4], t)
+	binary.LittleEndian.PutUint32(buf[4:8], l)
+	buf = append(buf, body...)
+	buf = binary.LittleEndian.AppendUint32(buf, l)
+	_, err := w.w.Write(buf)
+	return err
+}
+
+type option struct {
+	code  uint16
+	value []byte
+}
+
+// appendOptions appends non-empty options and end of option to buf
+func appendOptions(buf []byte, options ...option) []byte {
+	n := 0
+	for _, op := range options {
+		if len(op.value) == 0 {
+			continue
+		}
+		buf = binary.LittleEndian.AppendUint16(buf, op.code)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(op.value)))
+		buf = append(buf, pad(op.value)...)
+		n++
+	}
+	if n == 0 {
+		return buf
+	}
+	return append(buf, 0, 0, 0, 0)
+}
+
+// pad returns b padded to 32-bit boundary
+func pad(b []byte) []byte {
+	if len(b)%4 == 0 {
+		return b
+	}
+	return append(append([]byte{}, b...), make([]byte, 4-len(b)%4)...)
+}
@@ -0,0 +1,107 @@
+// pcapng_test
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type testBlock struct {
+	t    uint32
+	body []byte
+}
+
+// parseBlocks splits buf into blocks, checking the leading and trailing block length
+func parseBlocks(t *testing.T, buf []byte) []testBlock {
+	r := []testBlock{}
+	for len(buf) > 0 {
+		if len(buf) < 12 {
+			t.Fatalf("truncated block, %d bytes left", len(buf))
+		}
+		l := binary.LittleEndian.Uint32(buf[4:8])
+		if l%4 != 0 || int(l) > len(buf) {
+			t.Fatalf("invalid block length %d", l)
+		}
+		if binary.LittleEndian.Uint32(buf[l-4:l]) != l {
+			t.Fatalf("mismatched trailing block length")
+		}
+		r = append(r, testBlock{t: binary.LittleEndian.Uint32(buf[0:4]), body: buf[8 : l-4]})
+		buf = buf[l:]
+	}
+	return r
+}
+
+// getOption returns the value of option code in buf, nil if not found
+func getOption(buf []byte, code uint16) []byte {
+	for len(buf) >= 4 {
+		c := binary.LittleEndian.Uint16(buf[0:2])
+		l := int(binary.LittleEndian.Uint16(buf[2:4]))
+		if c == optEndOfOpt {
+			return nil
+		}
+		if c == code {
+			return buf[4 : 4+l]
+		}
+		buf = buf[4+(l+3)/4*4:]
+	}
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	out := new(bytes.Buffer)
+	w, err := NewWriter(out, "zouppp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := w.AddInterface("aa:bb:cc:dd:ee:01|100", "client 1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 0 {
+		t.Fatalf("expect interface id 0, got %d", id)
+	}
+	frame := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 0x88, 0x63, 0x11}
+	ts := time.Unix(1700000000, 123456789)
+	if err = w.WritePacket(id, ts, frame, "sent PADI"); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WritePacket(1, ts, frame, ""); err == nil {
+		t.Fatal("writing to non-existing interface should fail")
+	}
+	blocks := parseBlocks(t, out.Bytes())
+	if len(blocks) != 3 {
+		t.Fatalf("expect 3 blocks, got %d", len(blocks))
+	}
+	if blocks[0].t != blockTypeSHB || binary.LittleEndian.Uint32(blocks[0].body[0:4]) != byteOrderMagic {
+		t.Fatalf("invalid section header block")
+	}
+	if string(getOption(blocks[0].body[16:], optSHBUserAppl)) != "zouppp" {
+		t.Fatalf("invalid shb_userappl")
+	}
+	idb := blocks[1]
+	if idb.t != blockTypeIDB || binary.LittleEndian.Uint16(idb.body[0:2]) != LinkTypeEthernet {
+		t.Fatalf("invalid interface description block")
+	}
+	if string(getOption(idb.body[8:], optIfName)) != "aa:bb:cc:dd:ee:01|100" ||
+		string(getOption(idb.body[8:], optIfDescription)) != "client 1" ||
+		getOption(idb.body[8:], optComment) != nil {
+		t.Fatalf("invalid interface options")
+	}
+	epb := blocks[2]
+	if epb.t != blockTypeEPB {
+		t.Fatalf("invalid enhanced packet block")
+	}
+	tsv := uint64(binary.LittleEndian.Uint32(epb.body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(epb.body[8:12]))
+	if tsv != uint64(ts.UnixNano()) {
+		t.Fatalf("expect timestamp %d, got %d", ts.UnixNano(), tsv)
+	}
+	caplen := binary.LittleEndian.Uint32(epb.body[12:16])
+	if caplen != uint32(len(frame)) || !bytes.Equal(epb.body[20:20+caplen], frame) {
+		t.Fatalf("invalid packet data")
+	}
+	if string(getOption(epb.body[20+(caplen+3)/4*4:], optComment)) != "sent PADI" {
+		t.Fatalf("invalid packet comment")
+	}
+}
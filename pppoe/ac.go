@@ -0,0 +1,568 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+	"go.uber.org/zap"
+)
+
+// AC is the PPPoE Access Concentrator (server side of PPPoE discovery);
+// it answers PADI with PADO, PADR with PADS, and creates an ACSession for each opened PPPoE session
+type AC struct {
+	name          string
+	serviceNames  []string
+	cookieSecret  []byte
+	maxPayload    uint16
+	conn          *etherconn.EtherConn
+	logger        *zap.Logger
+	sessions      map[uint16]*ACSession
+	sessionsLock  *sync.RWMutex
+	lastSessionID uint16
+	acceptBacklog int
+	acceptChan    chan *ACSession
+}
+
+const (
+	// DefaultACName is the default AC-Name of AC
+	DefaultACName   = "zouppp"
+	acCookieLen     = 16
+	acceptChanDepth = 32
+)
+
+// ACModifier is a function to provide custom configuration when creating new AC instances
+type ACModifier func(ac *AC)
+
+// WithACName specifies the AC-Name tag value in PADO/PADS
+func WithACName(name string) ACModifier {
+	return func(ac *AC) {
+		ac.name = name
+	}
+}
+
+// WithACServiceNames specifies the list of service names AC offers;
+// a PADI/PADR requesting a non-empty service name that is not in the list is ignored/rejected;
+// if the list is empty, then any service name is accepted
+func WithACServiceNames(svcs []string) ACModifier {
+	return func(ac *AC) {
+		ac.serviceNames = svcs
+	}
+}
+
+// WithACCookieSecret specifies the secret used to generate and validate AC-Cookie;
+// a random secret is used by default
+func WithACCookieSecret(secret []byte) ACModifier {
+	return func(ac *AC) {
+		ac.cookieSecret = secret
+	}
+}
+
+// WithACMaxPayload specifies the max PPP payload AC supports, as defined in RFC4638;
+// if it is bigger than DefaultMaxPayload, PPP-Max-Payload in PADI/PADR is answered in PADO/PADS
+func WithACMaxPayload(n uint16) ACModifier {
+	return func(ac *AC) {
+		ac.maxPayload = n
+	}
+}
+
+// WithACAcceptBacklog specifies the max number of opened sessions waiting for Accept,
+// a PADR creating a new session beyond that is rejected with AC-System-Error
+func WithACAcceptBacklog(n int) ACModifier {
+	return func(ac *AC) {
+		ac.acceptBacklog = n
+	}
+}
+
+// NewAC returns a new AC, use conn as underlying transport, logger for logging;
+// conn should be created with etherconn.WithRecvMulticast(true) in order to receive PADI;
+// optionally ACModifier could provide custom configurations;
+func NewAC(conn *etherconn.EtherConn, logger *zap.Logger, options ...ACModifier) (*AC, error) {
+	r := new(AC)
+	r.name = DefaultACName
+	r.conn = conn
+	r.logger = logger
+	r.sessions = make(map[uint16]*ACSession)
+	r.sessionsLock = new(sync.RWMutex)
+	r.acceptBacklog = acceptChanDepth
+	for _, option := range options {
+		option(r)
+	}
+	r.acceptChan = make(chan *ACSession, r.acceptBacklog)
+	if len(r.cookieSecret) == 0 {
+		r.cookieSecret = make([]byte, 16)
+		if _, err := rand.Read(r.cookieSecret); err != nil {
+			return nil, fmt.Errorf("failed to generate cookie secret, %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Serve handles received PPPoE pkts until ctx is cancelled
+func (ac *AC) Serve(ctx context.Context) error {
+	defer ac.closeAllSessions()
+	for {
+		select {
+		case <-ctx.Done():
+			ac.logger.Info("AC serve routine stopped")
+			return nil
+		default:
+		}
+		ac.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		buf, l2ep, err := ac.conn.ReadPkt()
+		if err != nil {
+			if errors.Is(err, etherconn.ErrTimeOut) {
+				continue
+			}
+			return fmt.Errorf("failed to recv, %w", err)
+		}
+		pkt := new(Pkt)
+		if err = pkt.Parse(buf); err != nil {
+			ac.logger.Sugar().Debugf("got an invalid PPPoE pkt from %v, %v", l2ep.HwAddr, err)
+			continue
+		}
+		switch l2ep.Etype {
+		case EtherTypePPPoEDiscovery:
+			ac.handleDiscovery(pkt, l2ep.HwAddr)
+		case EtherTypePPPoESession:
+			ac.handleSession(pkt, l2ep.HwAddr)
+		}
+	}
+}
+
+// Accept waits and returns the next opened ACSession
+func (ac *AC) Accept(ctx context.Context) (*ACSession, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case s := <-ac.acceptChan:
+		return s, nil
+	}
+}
+
+// GetLogger returns AC's logger
+func (ac *AC) GetLogger() *zap.Logger {
+	return ac.logger
+}
+
+func (ac *AC) handleDiscovery(pkt *Pkt, peer net.HardwareAddr) {
+	ac.logger.Sugar().Infof("got %v from %v", pkt.Code, peer)
+	ac.logger.Sugar().Debugf("%v:\n%v", pkt.Code, pkt)
+	var err error
+	switch pkt.Code {
+	case CodePADI:
+		err = ac.handlePADI(pkt, peer)
+	case CodePADR:
+		err = ac.handlePADR(pkt, peer)
+	case CodePADT:
+		if s := ac.getSession(pkt.SessionID, peer); s != nil {
+			ac.logger.Sugar().Infof("session %X terminated by peer", pkt.SessionID)
+			ac.removeSession(s)
+			s.closeRecv()
+		}
+	}
+	if err != nil {
+		ac.logger.Sugar().Errorf("failed to handle %v from %v, %v", pkt.Code, peer, err)
+	}
+}
+
+func (ac *AC) handleSession(pkt *Pkt, peer net.HardwareAddr) {
+	s := ac.getSession(pkt.SessionID, peer)
+	if s == nil {
+		return
+	}
+	buf := make([]byte, len(pkt.Payload))
+	copy(buf, pkt.Payload)
+	// recvChan is never closed, a closed session is detected via done
+	select {
+	case <-s.done:
+	case s.recvChan <- buf:
+	default:
+		ac.logger.Sugar().Warnf("session %X recv chan is full, drop pkt", s.sessionID)
+	}
+}
+
+// getSvcName return requested service name in pkt, ok is false if the service is not offered by ac
+func (ac *AC) getSvcName(pkt *Pkt) (svc string, ok bool) {
+	if tags := pkt.GetTag(TagTypeServiceName); len(tags) > 0 {
+		if strtag, isstr := tags[0].(*TagString); isstr {
+			svc = strtag.Value
+		}
+	}
+	if svc == "" || len(ac.serviceNames) == 0 {
+		return svc, true
+	}
+	for _, s := range ac.serviceNames {
+		if s == svc {
+			return svc, true
+		}
+	}
+	return svc, false
+}
+
+func (ac *AC) genCookie(peer net.HardwareAddr) []byte {
+	h := hmac.New(sha256.New, ac.cookieSecret)
+	h.Write(peer)
+	return h.Sum(nil)[:acCookieLen]
+}
+
+// echoTags return tags in req that need to be included in response as is
+func echoTags(req *Pkt) (r []Tag) {
+	r = append(r, req.GetTag(TagTypeHostUniq)...)
+	r = append(r, req.GetTag(TagTypeRelaySessionID)...)
+	return
+}
+
+// maxPayloadTags returns the PPP-Max-Payload tag in response to req, which is the smaller one of requested and AC's
+func (ac *AC) maxPayloadTags(req *Pkt) []Tag {
+	n := getMaxPayload(req)
+	if n == 0 || ac.maxPayload <= DefaultMaxPayload {
+		return nil
+	}
+	if n > ac.maxPayload {
+		n = ac.maxPayload
+	}
+	return []Tag{NewMaxPayloadTag(n)}
+}
+
+func (ac *AC) send(pkt *Pkt, dst net.HardwareAddr) error {
+	pktbytes, err := pkt.Serialize()
+	if err != nil {
+		return err
+	}
+	_, err = ac.conn.WritePktTo(pktbytes, EtherTypePPPoEDiscovery, dst)
+	if err != nil {
+		return err
+	}
+	ac.logger.Sugar().Infof("sending %v to %v", pkt.Code, dst)
+	ac.logger.Sugar().Debugf("%v:\n%v", pkt.Code, pkt)
+	return nil
+}
+
+func (ac *AC) handlePADI(padi *Pkt, peer net.HardwareAddr) error {
+	svc, ok := ac.getSvcName(padi)
+	if !ok {
+		ac.logger.Sugar().Infof("ignore PADI from %v, service %v is not offered", peer, svc)
+		return nil
+	}
+	pado := new(Pkt)
+	pado.Code = CodePADO
+	pado.Tags = []Tag{
+		&TagString{TagType: TagTypeACName, Value: ac.name},
+		NewSvcTag(svc),
+	}
+	for _, s := range ac.serviceNames {
+		if s != svc {
+			pado.Tags = append(pado.Tags, NewSvcTag(s))
+		}
+	}
+	pado.Tags = append(pado.Tags, &TagByteSlice{TagType: TagTypeACCookie, Value: ac.genCookie(peer)})
+	pado.Tags = append(pado.Tags, ac.maxPayloadTags(padi)...)
+	pado.Tags = append(pado.Tags, echoTags(padi)...)
+	return ac.send(pado, peer)
+}
+
+func (ac *AC) buildPADSError(padr *Pkt, t TagType, msg string) *Pkt {
+	pads := new(Pkt)
+	pads.Code = CodePADS
+	pads.Tags = []Tag{&TagString{TagType: t, Value: msg}}
+	pads.Tags = append(pads.Tags, echoTags(padr)...)
+	return pads
+}
+
+func (ac *AC) handlePADR(padr *Pkt, peer net.HardwareAddr) error {
+	cookies := padr.GetTag(TagTypeACCookie)
+	if len(cookies) == 0 || !hmac.Equal(cookies[0].(*TagByteSlice).Value, ac.genCookie(peer)) {
+		return ac.send(ac.buildPADSError(padr, TagTypeGenericError, "invalid AC-Cookie"), peer)
+	}
+	svc, ok := ac.getSvcName(padr)
+	if !ok {
+		return ac.send(ac.buildPADSError(padr, TagTypeServiceNameError, "service not offered"), peer)
+	}
+	var hostuniq []byte
+	if tags := padr.GetTag(TagTypeHostUniq); len(tags) > 0 {
+		hostuniq = tags[0].(*TagByteSlice).Value
+	}
+	// a retransmitted PADR gets the same session
+	s := ac.findSession(peer, hostuniq)
+	if s == nil {
+		sid, err := ac.allocSessionID()
+		if err != nil {
+			return ac.send(ac.buildPADSError(padr, TagTypeACSystemError, err.Error()), peer)
+		}
+		s = ac.newSession(sid, peer, svc, hostuniq, padr.Tags)
+		// never block Serve on a slow or absent Accept caller
+		select {
+		case ac.acceptChan <- s:
+		default:
+			ac.removeSession(s)
+			s.closeRecv()
+			return ac.send(ac.buildPADSError(padr, TagTypeACSystemError, "too many sessions pending"), peer)
+		}
+	}
+	pads := new(Pkt)
+	pads.Code = CodePADS
+	pads.SessionID = s.sessionID
+	pads.Tags = []Tag{NewSvcTag(svc)}
+	pads.Tags = append(pads.Tags, ac.maxPayloadTags(padr)...)
+	pads.Tags = append(pads.Tags, echoTags(padr)...)
+	return ac.send(pads, peer)
+}
+
+func (ac *AC) allocSessionID() (uint16, error) {
+	ac.sessionsLock.Lock()
+	defer ac.sessionsLock.Unlock()
+	for i := 0; i < 0xfffe; i++ {
+		ac.lastSessionID++
+		if ac.lastSessionID == 0 || ac.lastSessionID == 0xffff {
+			ac.lastSessionID = 1
+		}
+		if _, inuse := ac.sessions[ac.lastSessionID]; !inuse {
+			// reserve the id
+			ac.sessions[ac.lastSessionID] = nil
+			return ac.lastSessionID, nil
+		}
+	}
+	return 0, fmt.Errorf("no session id available")
+}
+
+func (ac *AC) newSession(sid uint16, peer net.HardwareAddr, svc string, hostuniq []byte, tags []Tag) *ACSession {
+	s := new(ACSession)
+	s.ac = ac
+	s.sessionID = sid
+	s.peerMAC = peer
+	s.serviceName = svc
+	s.hostUniq = hostuniq
+	s.tags = tags
+	s.recvChan = make(chan []byte, recvChanDepth)
+	s.done = make(chan struct{})
+	s.closed = new(uint32)
+	s.closeOnce = new(sync.Once)
+	s.readDeadlineLock = new(sync.RWMutex)
+	s.logger = ac.logger.Named(fmt.Sprintf("%X", sid))
+	ac.sessionsLock.Lock()
+	ac.sessions[sid] = s
+	ac.sessionsLock.Unlock()
+	return s
+}
+
+func (ac *AC) getSession(sid uint16, peer net.HardwareAddr) *ACSession {
+	ac.sessionsLock.RLock()
+	defer ac.sessionsLock.RUnlock()
+	s := ac.sessions[sid]
+	if s == nil || !bytes.Equal(s.peerMAC, peer) {
+		return nil
+	}
+	return s
+}
+
+func (ac *AC) findSession(peer net.HardwareAddr, hostuniq []byte) *ACSession {
+	ac.sessionsLock.RLock()
+	defer ac.sessionsLock.RUnlock()
+	for _, s := range ac.sessions {
+		if s != nil && bytes.Equal(s.peerMAC, peer) && bytes.Equal(s.hostUniq, hostuniq) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (ac *AC) removeSession(s *ACSession) {
+	ac.sessionsLock.Lock()
+	defer ac.sessionsLock.Unlock()
+	if ac.sessions[s.sessionID] == s {
+		delete(ac.sessions, s.sessionID)
+	}
+}
+
+func (ac *AC) closeAllSessions() {
+	ac.sessionsLock.RLock()
+	list := []*ACSession{}
+	for _, s := range ac.sessions {
+		if s != nil {
+			list = append(list, s)
+		}
+	}
+	ac.sessionsLock.RUnlock()
+	for _, s := range list {
+		s.Close()
+	}
+}
+
+// ACSession is a PPPoE session opened on AC, it implements net.PacketConn interface
+type ACSession struct {
+	ac               *AC
+	sessionID        uint16
+	peerMAC          net.HardwareAddr
+	serviceName      string
+	hostUniq         []byte
+	tags             []Tag
+	recvChan         chan []byte
+	done             chan struct{}
+	closed           *uint32
+	closeOnce        *sync.Once
+	readDeadline     time.Time
+	readDeadlineLock *sync.RWMutex
+	logger           *zap.Logger
+}
+
+// SessionID returns the PPPoE session ID
+func (s *ACSession) SessionID() uint16 {
+	return s.sessionID
+}
+
+// PeerMAC returns the client's MAC address
+func (s *ACSession) PeerMAC() net.HardwareAddr {
+	return s.peerMAC
+}
+
+// ServiceName returns the service name requested by client
+func (s *ACSession) ServiceName() string {
+	return s.serviceName
+}
+
+// Tags returns all tags included in client's PADR
+func (s *ACSession) Tags() []Tag {
+	return s.tags
+}
+
+// GetLogger returns session's logger
+func (s *ACSession) GetLogger() *zap.Logger {
+	return s.logger
+}
+
+// ReadFrom implements net.PacketConn interface, return etherconn.ErrTimeOut if read deadline expires
+func (s *ACSession) ReadFrom(buf []byte) (int, net.Addr, error) {
+	s.readDeadlineLock.RLock()
+	deadline := s.readDeadline
+	s.readDeadlineLock.RUnlock()
+	var timeout <-chan time.Time
+	if d := time.Until(deadline); !deadline.IsZero() {
+		if d <= 0 {
+			return 0, nil, etherconn.ErrTimeOut
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-timeout:
+		return 0, nil, etherconn.ErrTimeOut
+	case <-s.done:
+		return 0, nil, fmt.Errorf("pppoe session %X is closed", s.sessionID)
+	case p := <-s.recvChan:
+		n := copy(buf, p)
+		return n, s.remoteAddr(), nil
+	}
+}
+
+// WriteTo implements net.PacketConn interface, addr is ignored, pkt is always sent to client's MAC
+func (s *ACSession) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if atomic.LoadUint32(s.closed) != 0 {
+		return 0, fmt.Errorf("pppoe session %X is closed", s.sessionID)
+	}
+	pkt := new(Pkt)
+	pkt.SessionID = s.sessionID
+	pkt.Code = CodeSession
+	pkt.Payload = p
+	pktbytes, err := pkt.Serialize()
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize pppoe pkt,%w", err)
+	}
+	_, err = s.ac.conn.WritePktTo(pktbytes, EtherTypePPPoESession, s.peerMAC)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send pppoe pkt,%w", err)
+	}
+	return len(p), nil
+}
+
+// Close implements net.PacketConn interface, send PADT to client if session is not already closed
+func (s *ACSession) Close() error {
+	if atomic.LoadUint32(s.closed) != 0 {
+		return nil
+	}
+	s.ac.removeSession(s)
+	padt := &Pkt{
+		Code:      CodePADT,
+		SessionID: s.sessionID,
+	}
+	err := s.ac.send(padt, s.peerMAC)
+	s.closeRecv()
+	return err
+}
+
+// SendPADM sends a PADM with HURL and MOTM tag to client as defined in RFC4937, empty hurl/motm is not included
+func (s *ACSession) SendPADM(hurl, motm string) error {
+	padm := &Pkt{
+		Code:      CodePADM,
+		SessionID: s.sessionID,
+	}
+	if hurl != "" {
+		padm.Tags = append(padm.Tags, &TagString{TagType: TagTypeHURL, Value: hurl})
+	}
+	if motm != "" {
+		padm.Tags = append(padm.Tags, &TagString{TagType: TagTypeMOTM, Value: motm})
+	}
+	return s.ac.send(padm, s.peerMAC)
+}
+
+// SendPADN sends a PADN with an IP_Route_Add tag for each of routes to client as defined in RFC4937
+func (s *ACSession) SendPADN(routes []IPRoute) error {
+	padn := &Pkt{
+		Code:      CodePADN,
+		SessionID: s.sessionID,
+	}
+	for _, r := range routes {
+		padn.Tags = append(padn.Tags, NewIPRouteAddTag(r))
+	}
+	return s.ac.send(padn, s.peerMAC)
+}
+
+func (s *ACSession) closeRecv() {
+	s.closeOnce.Do(func() {
+		atomic.StoreUint32(s.closed, 1)
+		close(s.done)
+	})
+}
+
+// LocalAddr return local Endpoint, see doc of Endpoint
+func (s *ACSession) LocalAddr() net.Addr {
+	return newPPPoEEndpoint(s.ac.conn.LocalAddr(), s.sessionID)
+}
+
+func (s *ACSession) remoteAddr() *Endpoint {
+	l2ep := etherconn.L2Endpoint{
+		HwAddr: s.peerMAC,
+		VLANs:  s.ac.conn.LocalAddr().VLANs,
+	}
+	return newPPPoEEndpoint(&l2ep, s.sessionID)
+}
+
+// SetReadDeadline implements net.PacketConn interface
+func (s *ACSession) SetReadDeadline(t time.Time) error {
+	s.readDeadlineLock.Lock()
+	s.readDeadline = t
+	s.readDeadlineLock.Unlock()
+	return nil
+}
+
+// SetWriteDeadline implements net.PacketConn interface
+func (s *ACSession) SetWriteDeadline(t time.Time) error {
+	return s.ac.conn.SetWriteDeadline(t)
+}
+
+// SetDeadline implements net.PacketConn interface
+func (s *ACSession) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	s.SetWriteDeadline(t)
+	return nil
+}
@@ -0,0 +1,184 @@
+// ac_test
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+	"github.com/hujun-open/zouppp/loopback"
+	"go.uber.org/zap"
+)
+
+func TestAC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, acRelay := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	defer acRelay.Stop()
+	acMAC := net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1}
+	clntMAC := net.HardwareAddr{0x2, 0, 0, 0, 0, 0x2}
+	etypes := []uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}
+	acConn := etherconn.NewEtherConn(acMAC, acRelay,
+		etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+	ac, err := NewAC(acConn, logger.Named("ac"), WithACName("testac"), WithACServiceNames([]string{"svc1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ac.Serve(ctx)
+	clntConn := etherconn.NewEtherConn(clntMAC, clntRelay, etherconn.WithEtherTypes(etypes))
+	clnt := NewPPPoE(clntConn, logger.Named("clnt"))
+	if err = clnt.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sctx, scancel := context.WithTimeout(ctx, 3*time.Second)
+	defer scancel()
+	s, err := ac.Accept(sctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SessionID() != clnt.LocalAddr().(*Endpoint).SessionID {
+		t.Fatalf("session id mismatch, AC %X, client %X", s.SessionID(), clnt.LocalAddr().(*Endpoint).SessionID)
+	}
+	if !bytes.Equal(s.PeerMAC(), clntMAC) {
+		t.Fatalf("unexpected session peer %v", s.PeerMAC())
+	}
+	// data both ways
+	buf := make([]byte, 128)
+	if _, err = clnt.WriteTo([]byte{0xc0, 0x21, 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, _, err := s.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte{0xc0, 0x21, 1}) {
+		t.Fatalf("AC got unexpected payload %x", buf[:n])
+	}
+	if _, err = s.WriteTo([]byte{0xc0, 0x21, 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	clnt.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, _, err = clnt.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte{0xc0, 0x21, 2}) {
+		t.Fatalf("client got unexpected payload %x", buf[:n])
+	}
+	// client terminates the session
+	clnt.Close()
+	s.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err = s.ReadFrom(buf); err == nil || err == etherconn.ErrTimeOut {
+		t.Fatalf("session is not closed after PADT, %v", err)
+	}
+}
+
+func TestACInitiatedDiscovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, acRelay := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	defer acRelay.Stop()
+	etypes := []uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}
+	acConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1}, acRelay,
+		etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+	ac, err := NewAC(acConn, logger.Named("ac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ac.Serve(ctx)
+	rcvd := make(chan *Pkt, 3)
+	clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, 0x2}, clntRelay, etherconn.WithEtherTypes(etypes))
+	clnt := NewPPPoE(clntConn, logger.Named("clnt"), WithDiscoveryHandler(func(pkt *Pkt) { rcvd <- pkt }))
+	if err = clnt.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sctx, scancel := context.WithTimeout(ctx, 3*time.Second)
+	defer scancel()
+	s, err := ac.Accept(sctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dst, _ := net.ParseCIDR("192.168.0.0/16")
+	route := IPRoute{Dst: dst, Src: &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}, Metric: 10}
+	if err = s.SendPADM("http://portal", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SendPADN([]IPRoute{route}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 128)
+	clnt.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err = clnt.ReadFrom(buf); !errors.Is(err, ErrSessionTerminated) {
+		t.Fatalf("expect session terminated by PADT, got %v", err)
+	}
+	padm := <-rcvd
+	if padm.Code != CodePADM || padm.HURL() != "http://portal" || padm.MOTM() != "hello" {
+		t.Fatalf("unexpected PADM:\n%v", padm)
+	}
+	padn := <-rcvd
+	routes, err := padn.IPRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if padn.Code != CodePADN || len(routes) != 1 || routes[0].String() != route.String() {
+		t.Fatalf("unexpected PADN routes %v", routes)
+	}
+	if padt := <-rcvd; padt.Code != CodePADT {
+		t.Fatalf("expect PADT, got %v", padt.Code)
+	}
+	if _, err = clnt.WriteTo([]byte{0xc0, 0x21, 1}, nil); err == nil {
+		t.Fatal("session is still open after PADT")
+	}
+}
+
+func TestACAcceptBacklog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, acRelay := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	defer acRelay.Stop()
+	etypes := []uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}
+	acConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1}, acRelay,
+		etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+	ac, err := NewAC(acConn, logger.Named("ac"), WithACAcceptBacklog(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ac.Serve(ctx)
+	// nobody calls Accept, 1st session fills the backlog, 2nd one is rejected without blocking AC
+	for i, expectErr := range []bool{false, true} {
+		clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 1, byte(i)}, clntRelay, etherconn.WithEtherTypes(etypes))
+		clnt := NewPPPoE(clntConn, logger.Named("clnt"))
+		err = clnt.Dial(ctx)
+		var terr *TagError
+		if expectErr != (err != nil) || (expectErr && (!errors.Is(err, ErrPADSRejected) || !errors.As(err, &terr) || terr.Type != TagTypeACSystemError)) {
+			t.Fatalf("client %d: unexpected dial result %v", i, err)
+		}
+	}
+	// the backlogged session could still be accepted and closed
+	sctx, scancel := context.WithTimeout(ctx, 3*time.Second)
+	defer scancel()
+	s, err := ac.Accept(sctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.ReadFrom(make([]byte, 128)); err == nil {
+		t.Fatal("session is still open after Close")
+	}
+}
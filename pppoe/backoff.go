@@ -0,0 +1,97 @@
+package pppoe
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultPADIBackoffFactor is the default backoff factor of PADI retransmission, 1 means fixed timeout;
+	// RFC2516 recommends exponential backoff, which could be enabled with a factor bigger than 1, e.g. 2
+	DefaultPADIBackoffFactor = 1
+	// DefaultPADRBackoffFactor is the default backoff factor of PADR retransmission
+	DefaultPADRBackoffFactor = 1
+)
+
+// Backoff specifies the retransmission timers of a discovery request
+type Backoff struct {
+	// Timeout is the amount of time to wait for response after 1st transmission
+	Timeout time.Duration
+	// Factor multiplies the timeout after each retransmission, 1 or less means fixed timeout
+	Factor float64
+	// MaxTimeout is the max amount of time to wait for response after a transmission, 0 means no limit
+	MaxTimeout time.Duration
+	// Retry is the max number of transmissions
+	Retry int
+}
+
+// DefaultPADIBackoff returns the default Backoff of PADI
+func DefaultPADIBackoff() Backoff {
+	return Backoff{
+		Timeout: DefaultTimeout,
+		Factor:  DefaultPADIBackoffFactor,
+		Retry:   DefaultRetry,
+	}
+}
+
+// DefaultPADRBackoff returns the default Backoff of PADR
+func DefaultPADRBackoff() Backoff {
+	return Backoff{
+		Timeout: DefaultTimeout,
+		Factor:  DefaultPADRBackoffFactor,
+		Retry:   DefaultRetry,
+	}
+}
+
+// timeout returns the amount of time to wait for response after i-th transmission, i starts from 0;
+// it is capped by MaxTimeout, or the max time.Duration if MaxTimeout is 0
+func (b Backoff) timeout(i int) time.Duration {
+	limit := time.Duration(math.MaxInt64)
+	if b.MaxTimeout > 0 {
+		limit = b.MaxTimeout
+	}
+	// multiply in float64, which doesn't overflow, and clamp before converting back to time.Duration
+	d := float64(b.Timeout)
+	for ; i > 0 && b.Factor > 1 && d < float64(limit); i-- {
+		d *= b.Factor
+	}
+	if d >= float64(limit) {
+		return limit
+	}
+	return time.Duration(d)
+}
+
+func (b Backoff) String() string {
+	return fmt.Sprintf("timeout %v factor %v max-timeout %v retry %d", b.Timeout, b.Factor, b.MaxTimeout, b.Retry)
+}
+
+// WithPADIBackoff specifies the retransmission timers of PADI, zero fields of b keep default values
+func WithPADIBackoff(b Backoff) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.padiBackoff.merge(b)
+	}
+}
+
+// WithPADRBackoff specifies the retransmission timers of PADR, zero fields of b keep default values
+func WithPADRBackoff(b Backoff) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.padrBackoff.merge(b)
+	}
+}
+
+// merge overrides b with non-zero fields of newb
+func (b *Backoff) merge(newb Backoff) {
+	if newb.Timeout > 0 {
+		b.Timeout = newb.Timeout
+	}
+	if newb.Factor > 0 {
+		b.Factor = newb.Factor
+	}
+	if newb.MaxTimeout > 0 {
+		b.MaxTimeout = newb.MaxTimeout
+	}
+	if newb.Retry > 0 {
+		b.Retry = newb.Retry
+	}
+}
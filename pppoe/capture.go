@@ -0,0 +1,22 @@
+package pppoe
+
+import "net"
+
+// CaptureHandler is called with every PPPoE pkt sent to or received from AC by the PPPoE instance,
+// sent is true if pkt is sent, etype is the EtherType, peer is the remote MAC, pkt is the PPPoE header plus payload;
+// pkt should not be retained after the call returns
+type CaptureHandler func(sent bool, etype uint16, peer net.HardwareAddr, pkt []byte)
+
+// WithCaptureHandler specifies h to be called with every pkt sent or received
+func WithCaptureHandler(h CaptureHandler) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.captureHandler = h
+	}
+}
+
+// capture calls the CaptureHandler if it is specified
+func (pppoe *PPPoE) capture(sent bool, etype uint16, peer net.HardwareAddr, pkt []byte) {
+	if pppoe.captureHandler != nil {
+		pppoe.captureHandler(sent, etype, peer, pkt)
+	}
+}
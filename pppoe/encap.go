@@ -3,6 +3,7 @@ package pppoe
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -33,18 +34,23 @@ func (pkt *Pkt) Parse(buf []byte) error {
 	pkt.Code = Code(buf[1])
 	pkt.SessionID = binary.BigEndian.Uint16(buf[2:4])
 	pkt.Len = binary.BigEndian.Uint16(buf[4:6])
+	if len(buf) < 6+int(pkt.Len) {
+		return fmt.Errorf("invalid PPPoE packet, payload length %d exceeds packet length %d", pkt.Len, len(buf))
+	}
 	pkt.Payload = buf[6 : 6+pkt.Len]
 	pkt.Tags = []Tag{}
-	if pkt.Code == CodeSession {
-		// no parsing of tag for session pkt
+	if pkt.Code == CodeSession || pkt.Len == 0 {
+		// no parsing of tag for session pkt or pkt without tag
 		return nil
 	}
 	newFunc := func(t TagType) Tag {
 		switch t {
-		case TagTypeACName, TagTypeServiceName, TagTypeGenericError, TagTypeServiceNameError, TagTypeACSystemError:
+		case TagTypeACName, TagTypeServiceName, TagTypeGenericError, TagTypeServiceNameError, TagTypeACSystemError, TagTypeHURL, TagTypeMOTM:
 			return new(TagString)
 		case TagTypeEndOfList:
 			return new(TagEndofList)
+		case TagTypePPPMaxPayload:
+			return new(TagUint16)
 		}
 		return new(TagByteSlice)
 	}
@@ -226,6 +232,52 @@ func (bslice *TagByteSlice) Parse(buf []byte) (int, error) {
 	return 4 + len(bslice.Value), nil
 }
 
+// TagUint16 is for all uint16 type of tag, e.g. PPP-Max-Payload
+type TagUint16 struct {
+	Value   uint16
+	TagType TagType
+}
+
+// NewMaxPayloadTag returns a new PPP-Max-Payload tag, as defined in RFC4638
+func NewMaxPayloadTag(n uint16) *TagUint16 {
+	return &TagUint16{
+		TagType: TagTypePPPMaxPayload,
+		Value:   n,
+	}
+}
+
+// String implements Tag interface
+func (u16 TagUint16) String() string {
+	return fmt.Sprintf("%v: %d", u16.TagType, u16.Value)
+}
+
+// Type implements Tag interface
+func (u16 TagUint16) Type() uint16 {
+	return uint16(u16.TagType)
+}
+
+// Serialize implements Tag interface
+func (u16 TagUint16) Serialize() ([]byte, error) {
+	r := make([]byte, 6)
+	binary.BigEndian.PutUint16(r[0:2], uint16(u16.TagType))
+	binary.BigEndian.PutUint16(r[2:4], 2)
+	binary.BigEndian.PutUint16(r[4:6], u16.Value)
+	return r, nil
+}
+
+// Parse implements Tag interface
+func (u16 *TagUint16) Parse(buf []byte) (int, error) {
+	if len(buf) < 6 {
+		return 0, fmt.Errorf("invalid uint16 tag length %d", len(buf))
+	}
+	u16.TagType = TagType(binary.BigEndian.Uint16(buf[:2]))
+	if l := binary.BigEndian.Uint16(buf[2:4]); l != 2 {
+		return 0, fmt.Errorf("failed to parse %v, length is %d instead of 2", u16.TagType, l)
+	}
+	u16.Value = binary.BigEndian.Uint16(buf[4:6])
+	return 6, nil
+}
+
 // BBFSubTagString is for string type of BBF sub-tag
 type BBFSubTagString struct {
 	TagType BBFSubTagNum
@@ -281,11 +333,15 @@ func (num BBFSubTagUint32) Serialize() ([]byte, error) {
 	buf := make([]byte, 6)
 	buf[0] = byte(num.TagType)
 	buf[1] = 4
+	binary.BigEndian.PutUint32(buf[2:6], num.Value)
 	return buf, nil
 }
 
 // Parse implements Tag interface
 func (num *BBFSubTagUint32) Parse(buf []byte) (int, error) {
+	if len(buf) < 6 || buf[1] != 4 {
+		return 0, fmt.Errorf("invalid numeric BBF sub-tag")
+	}
 	num.TagType = BBFSubTagNum(buf[0])
 	num.Value = binary.BigEndian.Uint32(buf[2:6])
 	return 6, nil
@@ -344,14 +400,25 @@ func (bbf *BBFTag) Parse(buf []byte) (int, error) {
 		switch t {
 		case BBFSubTagNumRemoteID, BBFSubTagNumCircuitID:
 			return new(BBFSubTagString)
-		case BBFSubTagActualDataRateUpstream, BBFSubTagActualDataRateDownstream, BBFSubTagMinimumDataRateUpstream, BBFSubTagMinimumDataRateDownstream, BBFSubTagAttainableDataRateUpstream, BBFSubTagAttainableDataRateDownstream, BBFSubTagMaximumDataRateUpstream, BBFSubTagMaximumDataRateDownstream, BBFSubTagMinDataRateUpstreaminlow, BBFSubTagMinimumDataRateDownstreaminlow, BBFSubTagMaxInterleavingDelay, BBFSubTagActualInterleavingUpstreamDelay, BBFSubTagMaximumInterleavingDelay, BBFSubTagActualInterleavingDownstreamDelay:
+		}
+		if t.IsUint32() {
 			return new(BBFSubTagUint32)
 		}
 		return new(BBFSubTagByteSlice)
 	}
-	pos := 6
+	if len(buf) < int(tagLen)+4 {
+		return 0, fmt.Errorf("BBF tag length %d exceeds buffer length", tagLen)
+	}
+	buf = buf[:tagLen+4]
+	if len(buf) == 8 {
+		return 8, nil
+	}
+	pos := 8
 	var i int
 	for i = 0; i < MaxTags; i++ {
+		if len(buf)-pos < 2 || len(buf)-pos < int(buf[pos+1])+2 {
+			return 0, fmt.Errorf("invalid BBF subtag length")
+		}
 		tag := newFunc(BBFSubTagNum(buf[pos]))
 		n, err := tag.Parse(buf[pos:])
 		if err != nil {
@@ -419,3 +486,19 @@ func NewCircuitRemoteIDTag(cid, rid string) *BBFTag {
 	}
 	return bbftag
 }
+
+// NewAccessLineTag return a BBF Tag with circuit-id and remote-id sub tag, plus numeric access-line characteristics sub tags in attrs,
+// e.g. data rates, interleaving delays, DSL-Type and PON related sub tags; sub tags in attrs are ordered by sub tag number.
+// if cid or rid is empty string, then it will not be included
+func NewAccessLineTag(cid, rid string, attrs map[BBFSubTagNum]uint32) *BBFTag {
+	bbftag := NewCircuitRemoteIDTag(cid, rid)
+	nums := []BBFSubTagNum{}
+	for num := range attrs {
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	for _, num := range nums {
+		*bbftag = append(*bbftag, &BBFSubTagUint32{TagType: num, Value: attrs[num]})
+	}
+	return bbftag
+}
@@ -2,6 +2,8 @@ package pppoe
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // Code is the PPPoE msg code
@@ -19,6 +21,8 @@ const (
 	CodePADR    Code = 25
 	CodePADS    Code = 101
 	CodePADT    Code = 167
+	CodePADM    Code = 211
+	CodePADN    Code = 212
 )
 
 // String return a string representation of code
@@ -36,6 +40,10 @@ func (code Code) String() string {
 		return "PADS"
 	case CodePADT:
 		return "PADT"
+	case CodePADM:
+		return "PADM"
+	case CodePADN:
+		return "PADN"
 	}
 	return fmt.Sprintf("unknown (%d)", code)
 }
@@ -129,9 +137,69 @@ const (
 	BBFSubTagMaximumInterleavingDelay          BBFSubTagNum = 0x8D
 	BBFSubTagActualInterleavingDownstreamDelay BBFSubTagNum = 0x8E
 	BBFSubTagDataLinkEncap                     BBFSubTagNum = 0x90
+	BBFSubTagDSLType                           BBFSubTagNum = 0x91
+	BBFSubTagPONAccessType                     BBFSubTagNum = 0x97
+	BBFSubTagONTAverageDataRateDownstream      BBFSubTagNum = 0x98
+	BBFSubTagONTPeakDataRateDownstream         BBFSubTagNum = 0x99
+	BBFSubTagONTMaximumDataRateUpstream        BBFSubTagNum = 0x9A
+	BBFSubTagONTAssuredDataRateUpstream        BBFSubTagNum = 0x9B
+	BBFSubTagPONTreeMaximumDataRateUpstream    BBFSubTagNum = 0x9C
+	BBFSubTagPONTreeMaximumDataRateDownstream  BBFSubTagNum = 0x9D
 	BBFSubTagIWFSessionFlag                    BBFSubTagNum = 0xFE
 )
 
+// a list of DSL-Type sub-tag values
+const (
+	BBFDSLTypeADSL1  uint32 = 1
+	BBFDSLTypeADSL2  uint32 = 2
+	BBFDSLTypeADSL2P uint32 = 3
+	BBFDSLTypeVDSL1  uint32 = 4
+	BBFDSLTypeVDSL2  uint32 = 5
+	BBFDSLTypeSDSL   uint32 = 6
+)
+
+// a list of PON-Access-Type sub-tag values
+const (
+	BBFPONTypeGPON    uint32 = 1
+	BBFPONTypeXGPON1  uint32 = 2
+	BBFPONTypeTWDMPON uint32 = 3
+	BBFPONTypeXGSPON  uint32 = 4
+	BBFPONTypeWDMPON  uint32 = 5
+)
+
+// IsUint32 returns true if t is a numeric sub-tag with a 4 bytes value, e.g. data rates and interleaving delays
+func (t BBFSubTagNum) IsUint32() bool {
+	switch t {
+	case BBFSubTagActualDataRateUpstream, BBFSubTagActualDataRateDownstream, BBFSubTagMinimumDataRateUpstream, BBFSubTagMinimumDataRateDownstream, BBFSubTagAttainableDataRateUpstream, BBFSubTagAttainableDataRateDownstream, BBFSubTagMaximumDataRateUpstream, BBFSubTagMaximumDataRateDownstream, BBFSubTagMinDataRateUpstreaminlow, BBFSubTagMinimumDataRateDownstreaminlow, BBFSubTagMaxInterleavingDelay, BBFSubTagActualInterleavingUpstreamDelay, BBFSubTagMaximumInterleavingDelay, BBFSubTagActualInterleavingDownstreamDelay,
+		BBFSubTagDSLType, BBFSubTagPONAccessType, BBFSubTagONTAverageDataRateDownstream, BBFSubTagONTPeakDataRateDownstream, BBFSubTagONTMaximumDataRateUpstream, BBFSubTagONTAssuredDataRateUpstream, BBFSubTagPONTreeMaximumDataRateUpstream, BBFSubTagPONTreeMaximumDataRateDownstream:
+		return true
+	}
+	return false
+}
+
+// MarshalText implements encoding.TextMarshaler interface
+func (t BBFSubTagNum) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler interface,
+// text is either the name returned by String() (case insensitive) or the sub-tag number, e.g. "ActualDataRateUpstream" or "0x81"
+func (t *BBFSubTagNum) UnmarshalText(text []byte) error {
+	input := strings.TrimSpace(string(text))
+	for i := 1; i <= 0xff; i++ {
+		if strings.EqualFold(BBFSubTagNum(i).String(), input) {
+			*t = BBFSubTagNum(i)
+			return nil
+		}
+	}
+	n, err := strconv.ParseUint(input, 0, 8)
+	if err != nil {
+		return fmt.Errorf("unknown BBF sub-tag %v", input)
+	}
+	*t = BBFSubTagNum(n)
+	return nil
+}
+
 // String returns a string representation of t
 func (t BBFSubTagNum) String() string {
 	switch t {
@@ -169,6 +237,22 @@ func (t BBFSubTagNum) String() string {
 		return "ActualInterleavingDownstreamDelay"
 	case BBFSubTagDataLinkEncap:
 		return "DataLinkEncap"
+	case BBFSubTagDSLType:
+		return "DSLType"
+	case BBFSubTagPONAccessType:
+		return "PONAccessType"
+	case BBFSubTagONTAverageDataRateDownstream:
+		return "ONTAverageDataRateDownstream"
+	case BBFSubTagONTPeakDataRateDownstream:
+		return "ONTPeakDataRateDownstream"
+	case BBFSubTagONTMaximumDataRateUpstream:
+		return "ONTMaximumDataRateUpstream"
+	case BBFSubTagONTAssuredDataRateUpstream:
+		return "ONTAssuredDataRateUpstream"
+	case BBFSubTagPONTreeMaximumDataRateUpstream:
+		return "PONTreeMaximumDataRateUpstream"
+	case BBFSubTagPONTreeMaximumDataRateDownstream:
+		return "PONTreeMaximumDataRateDownstream"
 	case BBFSubTagIWFSessionFlag:
 		return "IWFSessionFlag"
 	}
@@ -0,0 +1,149 @@
+package pppoe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// DiscoveryHandler is called when a PADT, PADM or PADN for the session is received from AC after session is open;
+// it is called in the routine reading the session, so it should not block
+type DiscoveryHandler func(pkt *Pkt)
+
+// WithDiscoveryHandler specifies h to handle PADT, PADM and PADN received during the session
+func WithDiscoveryHandler(h DiscoveryHandler) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.discoveryHandler = h
+	}
+}
+
+// IPRoute is the route in IP_Route_Add tag of PADN, as defined in RFC4937
+type IPRoute struct {
+	Dst    *net.IPNet
+	Src    *net.IPNet
+	Metric uint32
+}
+
+// ipRouteLen is the length of IP_Route_Add tag value:
+// destination prefix, destination prefix length, source prefix, source prefix length and metric, each is 4 bytes
+const ipRouteLen = 20
+
+func (r IPRoute) String() string {
+	return fmt.Sprintf("%v from %v metric %d", r.Dst, r.Src, r.Metric)
+}
+
+// NewIPRouteAddTag returns a new IP_Route_Add tag with r
+func NewIPRouteAddTag(r IPRoute) *TagByteSlice {
+	buf := make([]byte, ipRouteLen)
+	putPrefix := func(b []byte, prefix *net.IPNet) {
+		if prefix == nil {
+			return
+		}
+		copy(b[:4], prefix.IP.To4())
+		plen, _ := prefix.Mask.Size()
+		binary.BigEndian.PutUint32(b[4:8], uint32(plen))
+	}
+	putPrefix(buf[0:8], r.Dst)
+	putPrefix(buf[8:16], r.Src)
+	binary.BigEndian.PutUint32(buf[16:20], r.Metric)
+	return &TagByteSlice{TagType: TagTypeIPRouteAdd, Value: buf}
+}
+
+// parseIPRoute parses IP_Route_Add tag value buf
+func parseIPRoute(buf []byte) (IPRoute, error) {
+	var r IPRoute
+	if len(buf) != ipRouteLen {
+		return r, fmt.Errorf("invalid IP_Route_Add tag length %d", len(buf))
+	}
+	getPrefix := func(b []byte) (*net.IPNet, error) {
+		plen := binary.BigEndian.Uint32(b[4:8])
+		if plen > 32 {
+			return nil, fmt.Errorf("invalid prefix length %d", plen)
+		}
+		mask := net.CIDRMask(int(plen), 32)
+		return &net.IPNet{IP: net.IP(b[:4]).Mask(mask), Mask: mask}, nil
+	}
+	var err error
+	if r.Dst, err = getPrefix(buf[0:8]); err != nil {
+		return r, err
+	}
+	if r.Src, err = getPrefix(buf[8:16]); err != nil {
+		return r, err
+	}
+	r.Metric = binary.BigEndian.Uint32(buf[16:20])
+	return r, nil
+}
+
+// HURL returns the value of HURL tag in pkt, empty if there is none
+func (pkt *Pkt) HURL() string {
+	return pkt.getStrTag(TagTypeHURL)
+}
+
+// MOTM returns the value of MOTM tag in pkt, empty if there is none
+func (pkt *Pkt) MOTM() string {
+	return pkt.getStrTag(TagTypeMOTM)
+}
+
+func (pkt *Pkt) getStrTag(t TagType) string {
+	if tags := pkt.GetTag(t); len(tags) > 0 {
+		if str, ok := tags[0].(*TagString); ok {
+			return str.Value
+		}
+	}
+	return ""
+}
+
+// IPRoutes returns routes in all IP_Route_Add tags in pkt
+func (pkt *Pkt) IPRoutes() ([]IPRoute, error) {
+	r := []IPRoute{}
+	for _, tag := range pkt.GetTag(TagTypeIPRouteAdd) {
+		bslice, ok := tag.(*TagByteSlice)
+		if !ok {
+			continue
+		}
+		route, err := parseIPRoute(bslice.Value)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, route)
+	}
+	return r, nil
+}
+
+// handleDiscovery handles discovery pkt buf received after session is open, return ErrSessionTerminated if it is a PADT
+func (pppoe *PPPoE) handleDiscovery(buf []byte) error {
+	pkt := new(Pkt)
+	if err := pkt.Parse(buf); err != nil {
+		pppoe.logger.Sugar().Debugf("got an invalid discovery pkt, %v", err)
+		return nil
+	}
+	if pkt.SessionID != pppoe.sessionID {
+		return nil
+	}
+	pppoe.capture(false, EtherTypePPPoEDiscovery, pppoe.acMAC, buf)
+	pppoe.logger.Sugar().Debugf("%v:\n%v", pkt.Code, pkt)
+	switch pkt.Code {
+	case CodePADT:
+		pppoe.logger.Info("got PADT, session terminated by AC")
+		atomic.StoreUint32(pppoe.state, pppoeStateClosed)
+	case CodePADM:
+		pppoe.logger.Sugar().Infof("got PADM, HURL: %v, MOTM: %v", pkt.HURL(), pkt.MOTM())
+	case CodePADN:
+		routes, err := pkt.IPRoutes()
+		if err != nil {
+			pppoe.logger.Sugar().Warnf("got an invalid PADN, %v", err)
+			return nil
+		}
+		pppoe.logger.Sugar().Infof("got PADN, routes: %v", routes)
+	default:
+		return nil
+	}
+	if pppoe.discoveryHandler != nil {
+		pppoe.discoveryHandler(pkt)
+	}
+	if pkt.Code == CodePADT {
+		return ErrSessionTerminated
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package pppoe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+)
+
+// Offer is a PADO received from an AC
+type Offer struct {
+	// ACMAC is the MAC address of the AC
+	ACMAC net.HardwareAddr
+	// ACName is the value of AC-Name tag
+	ACName string
+	// ServiceNames is the list of values of Service-Name tags
+	ServiceNames []string
+	// Delay is the amount of time between sending PADI and receiving the PADO
+	Delay time.Duration
+	// Time is when the PADO is received
+	Time time.Time
+	// PADO is the received PADO
+	PADO *Pkt
+}
+
+func newOffer(pado *Pkt, acmac net.HardwareAddr, sentTime time.Time) *Offer {
+	now := time.Now()
+	r := &Offer{
+		ACMAC: acmac,
+		Delay: now.Sub(sentTime),
+		Time:  now,
+		PADO:  pado,
+	}
+	if tags := pado.GetTag(TagTypeACName); len(tags) > 0 {
+		r.ACName = tags[0].(*TagString).Value
+	}
+	for _, t := range pado.GetTag(TagTypeServiceName) {
+		r.ServiceNames = append(r.ServiceNames, t.(*TagString).Value)
+	}
+	return r
+}
+
+func (offer *Offer) String() string {
+	return fmt.Sprintf("%v(%v) delay %v", offer.ACName, offer.ACMAC, offer.Delay)
+}
+
+// PADOSelector selects an Offer among offers, which are in the order of receiving;
+// return nil if there is no acceptable offer
+type PADOSelector func(offers []*Offer) *Offer
+
+// SelectLowestDelay returns a PADOSelector that selects the offer with lowest delay
+func SelectLowestDelay() PADOSelector {
+	return func(offers []*Offer) *Offer {
+		var r *Offer
+		for _, offer := range offers {
+			if r == nil || offer.Delay < r.Delay {
+				r = offer
+			}
+		}
+		return r
+	}
+}
+
+// SelectByACName returns a PADOSelector that selects the first offer with AC-Name name
+func SelectByACName(name string) PADOSelector {
+	return func(offers []*Offer) *Offer {
+		for _, offer := range offers {
+			if offer.ACName == name {
+				return offer
+			}
+		}
+		return nil
+	}
+}
+
+// SelectByServiceName returns a PADOSelector that selects the first offer includes Service-Name svc
+func SelectByServiceName(svc string) PADOSelector {
+	return func(offers []*Offer) *Offer {
+		for _, offer := range offers {
+			for _, s := range offer.ServiceNames {
+				if s == svc {
+					return offer
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// SelectByACMAC returns a PADOSelector that selects the first offer from AC with MAC address mac
+func SelectByACMAC(mac net.HardwareAddr) PADOSelector {
+	return func(offers []*Offer) *Offer {
+		for _, offer := range offers {
+			if bytes.Equal(offer.ACMAC, mac) {
+				return offer
+			}
+		}
+		return nil
+	}
+}
+
+// NewRoundRobinSelector returns a PADOSelector that selects ACs in turn,
+// it should be shared by multiple PPPoE instances to spread sessions across ACs
+func NewRoundRobinSelector() PADOSelector {
+	lock := new(sync.Mutex)
+	next := 0
+	return func(offers []*Offer) *Offer {
+		if len(offers) == 0 {
+			return nil
+		}
+		// one offer per AC, ordered by AC MAC
+		acs := []*Offer{}
+		for _, offer := range offers {
+			dup := false
+			for _, ac := range acs {
+				if bytes.Equal(ac.ACMAC, offer.ACMAC) {
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				acs = append(acs, offer)
+			}
+		}
+		sort.Slice(acs, func(i, j int) bool { return bytes.Compare(acs[i].ACMAC, acs[j].ACMAC) < 0 })
+		lock.Lock()
+		defer lock.Unlock()
+		r := acs[next%len(acs)]
+		next++
+		return r
+	}
+}
+
+// WithPADOSelection specifies that PADOs are collected for window after sending PADI, then sel selects one of them;
+// if sel selects none, PPPoE keeps waiting for more PADO until timeout;
+// if sel is nil, the offer with lowest delay is selected
+func WithPADOSelection(window time.Duration, sel PADOSelector) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.padoWindow = window
+		pppoe.padoSelector = sel
+	}
+}
+
+// Offers returns all offers received during last Dial
+func (pppoe *PPPoE) Offers() []*Offer {
+	return pppoe.offers
+}
+
+// SelectedOffer returns the selected offer during last Dial, nil if none is selected
+func (pppoe *PPPoE) SelectedOffer() *Offer {
+	return pppoe.selectedOffer
+}
+
+// getPADO sends PADI, return the PADO selected by pppoe.padoSelector, along with AC's mac;
+// ErrPADOTimeout is returned if no PADO is selected after all retries,
+// it also wraps the *TagError of last PADO ignored due to an error tag, if there is no acceptable offer
+func (pppoe *PPPoE) getPADO(padi *Pkt) (*Pkt, net.HardwareAddr, error) {
+	pktbytes, err := padi.Serialize()
+	if err != nil {
+		return nil, nil, err
+	}
+	sel := pppoe.padoSelector
+	if sel == nil {
+		sel = SelectLowestDelay()
+	}
+	pppoe.offers = []*Offer{}
+	var lastTagErr *TagError
+	for i := 0; i < pppoe.padiBackoff.Retry; i++ {
+		_, err = pppoe.conn.WritePktTo(pktbytes, EtherTypePPPoEDiscovery, etherconn.BroadCastMAC)
+		if err != nil {
+			return nil, nil, err
+		}
+		pppoe.capture(true, EtherTypePPPoEDiscovery, etherconn.BroadCastMAC, pktbytes)
+		pppoe.counters.sent(padi.Code)
+		pppoe.logger.Sugar().Infof("sending %v", padi.Code)
+		pppoe.logger.Sugar().Debugf("%v:\n%v", padi.Code, padi)
+		sentTime := time.Now()
+		deadline := sentTime.Add(pppoe.padiBackoff.timeout(i))
+		windowEnd := sentTime.Add(pppoe.padoWindow)
+		for {
+			readDeadline := deadline
+			inWindow := time.Now().Before(windowEnd)
+			if inWindow && windowEnd.Before(deadline) {
+				readDeadline = windowEnd
+			}
+			pppoe.conn.SetReadDeadline(readDeadline)
+			rcvpktbuf, l2ep, err := pppoe.conn.ReadPkt()
+			if err != nil {
+				if !errors.Is(err, etherconn.ErrTimeOut) {
+					return nil, nil, fmt.Errorf("failed to recv response, %w", err)
+				}
+				if offer := sel(pppoe.offers); offer != nil {
+					return pppoe.selectOffer(offer)
+				}
+				if readDeadline.Equal(deadline) {
+					break //timeout, retry
+				}
+				continue //window ends, wait for more PADO
+			}
+			pado, ok := pppoe.checkResponse(rcvpktbuf, l2ep, CodePADO, etherconn.BroadCastMAC)
+			if !ok {
+				continue
+			}
+			offer := newOffer(pado, l2ep.HwAddr, sentTime)
+			if err := pppoe.checkOffer(offer); err != nil {
+				pppoe.logger.Sugar().Infof("ignore offer from %v, %v", offer, err)
+				errors.As(err, &lastTagErr)
+				continue
+			}
+			pppoe.logger.Sugar().Infof("got offer from %v", offer)
+			pppoe.offers = append(pppoe.offers, offer)
+			if inWindow {
+				continue
+			}
+			if offer := sel(pppoe.offers); offer != nil {
+				return pppoe.selectOffer(offer)
+			}
+		}
+	}
+	if lastTagErr != nil && len(pppoe.offers) == 0 {
+		return nil, nil, fmt.Errorf("%w, all offers are rejected, %w", ErrPADOTimeout, lastTagErr)
+	}
+	return nil, nil, fmt.Errorf("%w, faile to recv expect response %v", ErrPADOTimeout, CodePADO)
+}
+
+// checkOffer returns an error if offer is not acceptable
+func (pppoe *PPPoE) checkOffer(offer *Offer) error {
+	if terr := getTagError(offer.PADO); terr != nil {
+		return terr
+	}
+	if pppoe.acName != "" && offer.ACName != pppoe.acName {
+		return fmt.Errorf("AC-Name is not %v", pppoe.acName)
+	}
+	if pppoe.serviceName == "" {
+		return nil
+	}
+	for _, svc := range offer.ServiceNames {
+		if svc == pppoe.serviceName {
+			return nil
+		}
+	}
+	return fmt.Errorf("service %v is not offered", pppoe.serviceName)
+}
+
+func (pppoe *PPPoE) selectOffer(offer *Offer) (*Pkt, net.HardwareAddr, error) {
+	pppoe.selectedOffer = offer
+	pppoe.logger.Sugar().Infof("selected offer from %v", offer)
+	return offer.PADO, offer.ACMAC, nil
+}
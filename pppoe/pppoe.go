@@ -2,7 +2,9 @@
 package pppoe
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/binary"
 
 	"errors"
@@ -19,6 +21,7 @@ import (
 // PPPoE is the PPPoE protocol
 type PPPoE struct {
 	serviceName string
+	acName      string
 	sessionID   uint16
 	tags        []Tag
 	acMAC       net.HardwareAddr
@@ -29,14 +32,61 @@ type PPPoE struct {
 	recvChan    chan []byte
 	state       *uint32
 	logger      *zap.Logger
-	timeout     time.Duration
-	retry       int
+	padiBackoff Backoff
+	padrBackoff Backoff
+	padoTime    time.Time
+	padsTime    time.Time
+	counters    *Counters
+	hostUniq    []byte
+	maxPayload  uint16
+	// acMaxPayload is the smaller PPP-Max-Payload in PADO and PADS, 0 if AC doesn't support it
+	acMaxPayload     uint16
+	discoveryHandler DiscoveryHandler
+	captureHandler   CaptureHandler
+	// following are for PADO selection
+	padoWindow    time.Duration
+	padoSelector  PADOSelector
+	offers        []*Offer
+	selectedOffer *Offer
+}
+
+// Counters is a set of PPPoE discovery pkt counters, it could be shared by multiple PPPoE instances
+type Counters struct {
+	PADISent, PADORcvd, PADRSent, PADSRcvd, PADTSent atomic.Uint64
+}
+
+// sent increases the counter of sent pkt with code
+func (c *Counters) sent(code Code) {
+	if c == nil {
+		return
+	}
+	switch code {
+	case CodePADI:
+		c.PADISent.Add(1)
+	case CodePADR:
+		c.PADRSent.Add(1)
+	case CodePADT:
+		c.PADTSent.Add(1)
+	}
+}
+
+// rcvd increases the counter of received pkt with code
+func (c *Counters) rcvd(code Code) {
+	if c == nil {
+		return
+	}
+	switch code {
+	case CodePADO:
+		c.PADORcvd.Add(1)
+	case CodePADS:
+		c.PADSRcvd.Add(1)
+	}
 }
 
 const (
-	// DefaultTimeout is default timeout for PPPoE
+	// DefaultTimeout is default initial timeout of PADI/PADR
 	DefaultTimeout = 3 * time.Second
-	// DefaultRetry is the default retry for PPPoE
+	// DefaultRetry is the default max number of PADI/PADR transmissions
 	DefaultRetry = 3
 )
 
@@ -55,8 +105,50 @@ const (
 	EtherTypePPPoEDiscovery = 0x8863
 	recvChanDepth           = 32
 	readTimeout             = time.Second
+	hostUniqLen             = 8
+	// DefaultMaxPayload is the max PPP payload of a PPPoE session without PPP-Max-Payload negotiation
+	DefaultMaxPayload = 1492
+)
+
+// errors returned by Dial
+var (
+	// ErrPADOTimeout means no PADO is received after all retries
+	ErrPADOTimeout = errors.New("timeout waiting for PADO")
+	// ErrPADSTimeout means no PADS is received after all retries
+	ErrPADSTimeout = errors.New("timeout waiting for PADS")
+	// ErrPADSRejected means AC rejected the PADR, the returned error also wraps a *TagError if PADS carries an error tag
+	ErrPADSRejected = errors.New("AC rejected")
+	// ErrSessionTerminated is returned by ReadFrom after receiving PADT from AC
+	ErrSessionTerminated = errors.New("session terminated by AC")
 )
 
+// TagError is the error carried by a PPPoE error tag, i.e. Service-Name-Error, AC-System-Error or Generic-Error
+type TagError struct {
+	// Type is the type of the error tag
+	Type TagType
+	// Msg is the value of the error tag
+	Msg string
+}
+
+func (te *TagError) Error() string {
+	return fmt.Sprintf("%v: %v", te.Type, te.Msg)
+}
+
+// getTagError returns a *TagError of 1st error tag in pkt, nil if there is no error tag
+func getTagError(pkt *Pkt) *TagError {
+	for _, tag := range pkt.Tags {
+		switch TagType(tag.Type()) {
+		case TagTypeServiceNameError, TagTypeACSystemError, TagTypeGenericError:
+			r := &TagError{Type: TagType(tag.Type())}
+			if strtag, ok := tag.(*TagString); ok {
+				r.Msg = strtag.Value
+			}
+			return r
+		}
+	}
+	return nil
+}
+
 // Modifier is a function to provide custom configuration when creating new PPPoE instances
 type Modifier func(pppoe *PPPoE)
 
@@ -67,7 +159,7 @@ func WithDebug(d bool) Modifier {
 	}
 }
 
-// WithTags adds all tags in t in PPPoE request pkt
+// WithTags adds all tags in t in PPPoE request pkt, except Service-Name tag, which is specified by WithServiceName
 func WithTags(t []Tag) Modifier {
 	return func(pppoe *PPPoE) {
 		if t == nil {
@@ -77,18 +169,52 @@ func WithTags(t []Tag) Modifier {
 	}
 }
 
+// WithServiceName specifies the Service-Name to request, empty means any service;
+// PADO doesn't offer the service is ignored
+func WithServiceName(svc string) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.serviceName = svc
+	}
+}
+
+// WithRequiredACName specifies that only PADO with AC-Name name is accepted, empty means any AC
+func WithRequiredACName(name string) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.acName = name
+	}
+}
+
+// WithHostUniq specifies hu as the Host-Uniq tag value, nil means not using Host-Uniq;
+// by default, a random Host-Uniq is generated for each PPPoE instance
+func WithHostUniq(hu []byte) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.hostUniq = hu
+	}
+}
+
+// WithMaxPayload specifies n as the PPP-Max-Payload tag value in PADI/PADR as defined in RFC4638,
+// 0 means not using PPP-Max-Payload; see MaxPayload() for negotiated result
+func WithMaxPayload(n uint16) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.maxPayload = n
+	}
+}
+
+// WithCounters specifies c to count discovery pkts sent and received
+func WithCounters(c *Counters) Modifier {
+	return func(pppoe *PPPoE) {
+		pppoe.counters = c
+	}
+}
+
 // NewPPPoE return a new PPPoE struct; use conn as underlying transport, logger for logging;
 // optionally Modifer could provide custom configurations;
 func NewPPPoE(conn *etherconn.EtherConn, logger *zap.Logger, options ...Modifier) *PPPoE {
 	r := new(PPPoE)
-	r.timeout = DefaultTimeout
-	r.retry = DefaultRetry
-	r.tags = []Tag{
-		&TagString{
-			TagType: TagTypeServiceName,
-			Value:   r.serviceName,
-		},
-	}
+	r.padiBackoff = DefaultPADIBackoff()
+	r.padrBackoff = DefaultPADRBackoff()
+	r.hostUniq = make([]byte, hostUniqLen)
+	rand.Read(r.hostUniq)
 	for _, option := range options {
 		option(r)
 	}
@@ -118,6 +244,23 @@ func (pppoe *PPPoE) SetDeadline(t time.Time) error {
 	return nil
 }
 
+// HostUniq returns the Host-Uniq tag value, nil if Host-Uniq is not used
+func (pppoe *PPPoE) HostUniq() []byte {
+	return pppoe.hostUniq
+}
+
+// MaxPayload returns the max PPP payload of the session, which is the smaller one of requested PPP-Max-Payload and
+// the ones in PADO and PADS; DefaultMaxPayload is returned if PPP-Max-Payload is not used or AC doesn't support it
+func (pppoe *PPPoE) MaxPayload() uint16 {
+	if pppoe.maxPayload <= DefaultMaxPayload || pppoe.acMaxPayload <= DefaultMaxPayload {
+		return DefaultMaxPayload
+	}
+	if pppoe.acMaxPayload < pppoe.maxPayload {
+		return pppoe.acMaxPayload
+	}
+	return pppoe.maxPayload
+}
+
 // LocalAddr return local Endpoint, see doc of Endpoint
 func (pppoe *PPPoE) LocalAddr() net.Addr {
 	return newPPPoEEndpoint(pppoe.conn.LocalAddr(), pppoe.sessionID)
@@ -132,6 +275,8 @@ func (pppoe *PPPoE) Close() error {
 			return err
 		}
 		pppoe.conn.WritePktTo(pktbytes, EtherTypePPPoEDiscovery, pppoe.acMAC)
+		pppoe.capture(true, EtherTypePPPoEDiscovery, pppoe.acMAC, pktbytes)
+		pppoe.counters.sent(CodePADT)
 	}
 	return nil
 }
@@ -147,26 +292,64 @@ func (pppoe *PPPoE) buildPADI() *Pkt {
 	padi := new(Pkt)
 	padi.Code = CodePADI
 	padi.SessionID = 0
-	padi.Tags = pppoe.tags
+	padi.Tags = pppoe.requestTags(true)
 	return padi
 }
 
-func (pppoe *PPPoE) buildPADRWithPADO(pado *Pkt) *Pkt {
-	padr := new(Pkt)
-	padr.Code = CodePADR
-	padr.SessionID = 0
-	padr.Tags = []Tag{
-		&TagString{
-			TagType: TagTypeServiceName,
-			Value:   pppoe.serviceName,
-		},
-	}
+// requestTags returns tags to be included in PADI/PADR, besides AC-Cookie;
+// PPP-Max-Payload is included only if withMaxPayload is true
+func (pppoe *PPPoE) requestTags(withMaxPayload bool) []Tag {
+	r := []Tag{NewSvcTag(pppoe.serviceName)}
 	for _, t := range pppoe.tags {
 		if t.Type() != uint16(TagTypeServiceName) {
-			padr.Tags = append(padr.Tags, t)
+			r = append(r, t)
+		}
+	}
+	if len(pppoe.hostUniq) > 0 {
+		r = append(r, pppoe.hostUniqTag())
+	}
+	if withMaxPayload && pppoe.maxPayload > 0 {
+		r = append(r, NewMaxPayloadTag(pppoe.maxPayload))
+	}
+	return r
+}
+
+// getMaxPayload returns the value of PPP-Max-Payload tag in pkt, 0 if there is none
+func getMaxPayload(pkt *Pkt) uint16 {
+	if tags := pkt.GetTag(TagTypePPPMaxPayload); len(tags) > 0 {
+		if u16, ok := tags[0].(*TagUint16); ok {
+			return u16.Value
 		}
 	}
-	padr.Tags = append(padr.Tags, pado.GetTag(TagTypeACCookie)...)
+	return 0
+}
+
+func (pppoe *PPPoE) hostUniqTag() *TagByteSlice {
+	return &TagByteSlice{
+		TagType: TagTypeHostUniq,
+		Value:   pppoe.hostUniq,
+	}
+}
+
+// hostUniqMatched returns true if resp carries the same Host-Uniq as sent, or Host-Uniq is not used
+func (pppoe *PPPoE) hostUniqMatched(resp *Pkt) bool {
+	if len(pppoe.hostUniq) == 0 {
+		return true
+	}
+	tags := resp.GetTag(TagTypeHostUniq)
+	if len(tags) == 0 {
+		return false
+	}
+	hu, ok := tags[0].(*TagByteSlice)
+	return ok && bytes.Equal(hu.Value, pppoe.hostUniq)
+}
+
+func (pppoe *PPPoE) buildPADRWithPADO(pado *Pkt) *Pkt {
+	padr := new(Pkt)
+	padr.Code = CodePADR
+	padr.SessionID = 0
+	// RFC4638: PPP-Max-Payload is not included in PADR if AC doesn't include it in PADO
+	padr.Tags = append(pppoe.requestTags(getMaxPayload(pado) > 0), pado.GetTag(TagTypeACCookie)...)
 	return padr
 }
 
@@ -187,11 +370,13 @@ func (pppoe *PPPoE) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 	if err != nil {
 		return 0, fmt.Errorf("failed to send pppoe pkt,%w", err)
 	}
+	pppoe.capture(true, EtherTypePPPoESession, pppoe.acMAC, pktbytes)
 	return len(p), nil
 
 }
 
-// ReadFrom implments net.PacketConn interface; only works after pppoe session is open
+// ReadFrom implments net.PacketConn interface; only works after pppoe session is open;
+// PADT/PADM/PADN received is handled by DiscoveryHandler, ErrSessionTerminated is returned after receiving PADT
 func (pppoe *PPPoE) ReadFrom(buf []byte) (int, net.Addr, error) {
 	if atomic.LoadUint32(pppoe.state) != pppoeStateOpen {
 		return 0, nil, fmt.Errorf("pppoe is not open")
@@ -211,6 +396,12 @@ func (pppoe *PPPoE) ReadFrom(buf []byte) (int, net.Addr, error) {
 		if l2ep.HwAddr.String() != pppoe.acMAC.String() {
 			continue
 		}
+		if l2ep.Etype == EtherTypePPPoEDiscovery {
+			if err = pppoe.handleDiscovery(buf[:n]); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
 		if Code(buf[1]) != CodeSession {
 			continue
 		}
@@ -218,6 +409,7 @@ func (pppoe *PPPoE) ReadFrom(buf []byte) (int, net.Addr, error) {
 		if binary.BigEndian.Uint16(buf[2:4]) != pppoe.sessionID {
 			continue
 		}
+		pppoe.capture(false, EtherTypePPPoESession, l2ep.HwAddr, buf[:n])
 		buf = append(buf[:0], buf[6:]...)
 		break
 	}
@@ -233,36 +425,60 @@ func (pppoe *PPPoE) newRemotePPPoEP(mac net.HardwareAddr) *Endpoint {
 	return newPPPoEEndpoint(&l2ep, pppoe.sessionID)
 }
 
-// getResponse return 1st rcvd PPPoE response as specified by code, along with remote mac
-func (pppoe *PPPoE) getResponse(req *Pkt, code Code, dst net.HardwareAddr) (*Pkt, net.HardwareAddr, error) {
+// getResponse return 1st rcvd PPPoE response as specified by code, along with remote mac;
+// response not for this PPPoE instance (e.g. mismatched Host-Uniq, or not from dst if dst is unicast) is dropped;
+// toErr is returned if there is no response after all retransmissions according to backoff
+func (pppoe *PPPoE) getResponse(req *Pkt, code Code, dst net.HardwareAddr, backoff Backoff, toErr error) (*Pkt, net.HardwareAddr, error) {
 	pktbytes, err := req.Serialize()
 	if err != nil {
 		return nil, nil, err
 	}
-	for i := 0; i < pppoe.retry; i++ {
+	for i := 0; i < backoff.Retry; i++ {
 		_, err = pppoe.conn.WritePktTo(pktbytes, EtherTypePPPoEDiscovery, dst)
 		if err != nil {
 			return nil, nil, err
 		}
+		pppoe.capture(true, EtherTypePPPoEDiscovery, dst, pktbytes)
+		pppoe.counters.sent(req.Code)
 		pppoe.logger.Sugar().Infof("sending %v", req.Code)
 		pppoe.logger.Sugar().Debugf("%v:\n%v", req.Code, req)
-		resp := new(Pkt)
-		pppoe.conn.SetReadDeadline(time.Now().Add(pppoe.timeout))
-		rcvpktbuf, l2ep, err := pppoe.conn.ReadPkt()
-		if err != nil {
-			if !errors.Is(err, etherconn.ErrTimeOut) {
-				return nil, nil, fmt.Errorf("failed to recv response, %w", err)
-			} //else timeout
-		}
-		err = resp.Parse(rcvpktbuf)
-		if err != nil {
-			continue
-		}
-		if resp.Code == code {
-			return resp, l2ep.HwAddr, nil
+		pppoe.conn.SetReadDeadline(time.Now().Add(backoff.timeout(i)))
+		for {
+			rcvpktbuf, l2ep, err := pppoe.conn.ReadPkt()
+			if err != nil {
+				if !errors.Is(err, etherconn.ErrTimeOut) {
+					return nil, nil, fmt.Errorf("failed to recv response, %w", err)
+				}
+				break //timeout, retry
+			}
+			if resp, ok := pppoe.checkResponse(rcvpktbuf, l2ep, code, dst); ok {
+				return resp, l2ep.HwAddr, nil
+			}
 		}
 	}
-	return nil, nil, fmt.Errorf("faile to recv expect response %v", code)
+	return nil, nil, fmt.Errorf("%w, faile to recv expect response %v", toErr, code)
+}
+
+// checkResponse parses rcvd buf, return the pkt and true if it is a response with code for this PPPoE instance
+func (pppoe *PPPoE) checkResponse(buf []byte, l2ep *etherconn.L2Endpoint, code Code, dst net.HardwareAddr) (*Pkt, bool) {
+	resp := new(Pkt)
+	if err := resp.Parse(buf); err != nil {
+		return nil, false
+	}
+	if resp.Code != code {
+		return nil, false
+	}
+	if dst[0]&0x1 == 0 && !bytes.Equal(l2ep.HwAddr, dst) {
+		pppoe.logger.Sugar().Debugf("drop %v from %v, expecting %v", resp.Code, l2ep.HwAddr, dst)
+		return nil, false
+	}
+	if !pppoe.hostUniqMatched(resp) {
+		pppoe.logger.Sugar().Debugf("drop %v from %v with mismatched Host-Uniq", resp.Code, l2ep.HwAddr)
+		return nil, false
+	}
+	pppoe.capture(false, EtherTypePPPoEDiscovery, l2ep.HwAddr, buf)
+	pppoe.counters.rcvd(code)
+	return resp, true
 }
 
 // GetLogger returns pppoe's logger
@@ -282,29 +498,55 @@ func (pppoe *PPPoE) Dial(ctx context.Context) error {
 	var err error
 	padi := pppoe.buildPADI()
 	var pado, pads *Pkt
-	pado, pppoe.acMAC, err = pppoe.getResponse(padi, CodePADO, etherconn.BroadCastMAC)
+	pado, pppoe.acMAC, err = pppoe.getPADO(padi)
 	if err != nil {
 		return err
 	}
+	// the selected offer could be received before the PADO selection window ends
+	pppoe.padoTime = pppoe.selectedOffer.Time
 	pppoe.logger.Info("Got PADO")
 	pppoe.logger.Sugar().Debugf("PADO:\n%v", pado)
 	padr := pppoe.buildPADRWithPADO(pado)
-	pads, _, err = pppoe.getResponse(padr, CodePADS, pppoe.acMAC)
+	pads, _, err = pppoe.getResponse(padr, CodePADS, pppoe.acMAC, pppoe.padrBackoff, ErrPADSTimeout)
 	if err != nil {
 		return err
 	}
 	pppoe.logger.Info("Got PADS")
 	pppoe.logger.Sugar().Debugf("PADS:\n%v", pads)
 	if pads.SessionID == 0 {
-		return fmt.Errorf("AC rejected,\n %v", pads.String())
+		if terr := getTagError(pads); terr != nil {
+			return fmt.Errorf("%w, %w", ErrPADSRejected, terr)
+		}
+		return fmt.Errorf("%w,\n %v", ErrPADSRejected, pads.String())
 	}
+	pppoe.padsTime = time.Now()
 	pppoe.sessionID = pads.SessionID
+	pppoe.acMaxPayload = 0
+	if padoMaxPayload := getMaxPayload(pado); padoMaxPayload > 0 {
+		pppoe.acMaxPayload = getMaxPayload(pads)
+		if padoMaxPayload < pppoe.acMaxPayload {
+			pppoe.acMaxPayload = padoMaxPayload
+		}
+	}
 	atomic.StoreUint32(pppoe.state, pppoeStateOpen)
 	pppoe.logger = pppoe.logger.Named(fmt.Sprintf("%X", pppoe.sessionID))
+	if pppoe.maxPayload > 0 {
+		pppoe.logger.Sugar().Infof("max PPP payload is %d, AC's PPP-Max-Payload is %d", pppoe.MaxPayload(), pppoe.acMaxPayload)
+	}
 	_, pppoe.cancelFunc = context.WithCancel(ctx)
 	return nil
 }
 
+// PADOTime returns when the PADO is received, zero if not received
+func (pppoe *PPPoE) PADOTime() time.Time {
+	return pppoe.padoTime
+}
+
+// PADSTime returns when the PADS is received and session is established, zero if not established
+func (pppoe *PPPoE) PADSTime() time.Time {
+	return pppoe.padsTime
+}
+
 // Endpoint represents a PPPoE endpont
 type Endpoint struct {
 	// L2EP is the associated EtherConn's L2Endpoint
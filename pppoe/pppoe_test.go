@@ -2,9 +2,19 @@
 package pppoe
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/hujun-open/etherconn"
+	"github.com/hujun-open/zouppp/loopback"
+	"go.uber.org/zap"
 )
 
 func TestEncap(t *testing.T) {
@@ -25,3 +35,369 @@ func TestEncap(t *testing.T) {
 	}
 
 }
+
+func TestHostUniq(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, acRelay := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	defer acRelay.Stop()
+	acMAC := net.HardwareAddr{0x2, 0, 0, 0, 0, 0x1}
+	otherMAC := net.HardwareAddr{0x2, 0, 0, 0, 0, 0x3}
+	clntMAC := net.HardwareAddr{0x2, 0, 0, 0, 0, 0x2}
+	etypes := []uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}
+	acConn := etherconn.NewEtherConn(acMAC, acRelay,
+		etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+	otherConn := etherconn.NewEtherConn(otherMAC, acRelay, etherconn.WithEtherTypes(etypes))
+	clntConn := etherconn.NewEtherConn(clntMAC, clntRelay, etherconn.WithEtherTypes(etypes))
+	clnt := NewPPPoE(clntConn, logger.Named("clnt"))
+	if len(clnt.HostUniq()) == 0 {
+		t.Fatal("Host-Uniq is not generated")
+	}
+	reply := func(conn *etherconn.EtherConn, code Code, sid uint16, hu []byte) {
+		pkt := &Pkt{Code: code, SessionID: sid, Tags: []Tag{NewSvcTag("")}}
+		if hu != nil {
+			pkt.Tags = append(pkt.Tags, &TagByteSlice{TagType: TagTypeHostUniq, Value: hu})
+		}
+		buf, _ := pkt.Serialize()
+		conn.WritePktTo(buf, EtherTypePPPoEDiscovery, clntMAC)
+	}
+	// fake AC, responses with wrong Host-Uniq, or from other MAC, are sent before the correct ones
+	go func() {
+		for {
+			buf, _, err := acConn.ReadPkt()
+			if err != nil {
+				return
+			}
+			req := new(Pkt)
+			if req.Parse(buf) != nil {
+				continue
+			}
+			tags := req.GetTag(TagTypeHostUniq)
+			if len(tags) == 0 {
+				t.Errorf("%v without Host-Uniq", req.Code)
+				return
+			}
+			hu := tags[0].(*TagByteSlice).Value
+			switch req.Code {
+			case CodePADI:
+				reply(acConn, CodePADO, 0, []byte("wrong"))
+				reply(acConn, CodePADO, 0, nil)
+				reply(acConn, CodePADO, 0, hu)
+			case CodePADR:
+				reply(otherConn, CodePADS, 0x10, hu)
+				reply(acConn, CodePADS, 0x11, []byte("wrong"))
+				reply(acConn, CodePADS, 0x12, hu)
+			}
+		}
+	}()
+	clnt.padiBackoff.Retry = 1
+	clnt.padrBackoff.Retry = 1
+	if err := clnt.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if sid := clnt.LocalAddr().(*Endpoint).SessionID; sid != 0x12 {
+		t.Fatalf("expect session id 0x12, got %X", sid)
+	}
+	acConn.Close()
+}
+
+func TestPADOSelection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, acRelay := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	defer acRelay.Stop()
+	etypes := []uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}
+	for i, name := range []string{"ac1", "ac2"} {
+		acConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, byte(i + 1)}, acRelay,
+			etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+		ac, err := NewAC(acConn, logger.Named(name), WithACName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ac.Serve(ctx)
+	}
+	dial := func(mac byte, sel PADOSelector) *PPPoE {
+		clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 1, mac}, clntRelay, etherconn.WithEtherTypes(etypes))
+		clnt := NewPPPoE(clntConn, logger.Named("clnt"), WithPADOSelection(200*time.Millisecond, sel))
+		start := time.Now()
+		if err := clnt.Dial(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if d := clnt.PADOTime().Sub(start); d >= 200*time.Millisecond {
+			t.Fatalf("PADO time %v after dialing includes the selection window", d)
+		}
+		if len(clnt.Offers()) != 2 {
+			t.Fatalf("expect 2 offers, got %v", clnt.Offers())
+		}
+		return clnt
+	}
+	clnt := dial(1, SelectByACName("ac2"))
+	if clnt.SelectedOffer().ACName != "ac2" || !bytes.Equal(clnt.acMAC, net.HardwareAddr{0x2, 0, 0, 0, 0, 2}) {
+		t.Fatalf("expect ac2 selected, got %v", clnt.SelectedOffer())
+	}
+	rr := NewRoundRobinSelector()
+	selected := map[string]bool{}
+	for i := byte(2); i < 4; i++ {
+		selected[dial(i, rr).SelectedOffer().ACName] = true
+	}
+	if len(selected) != 2 {
+		t.Fatalf("round robin selected %v", selected)
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, acRelay := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	defer acRelay.Stop()
+	etypes := []uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}
+	for i, name := range []string{"ac1", "ac2"} {
+		acConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, byte(i + 1)}, acRelay,
+			etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+		ac, err := NewAC(acConn, logger.Named(name), WithACName(name), WithACServiceNames([]string{"svc" + name}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ac.Serve(ctx)
+	}
+	newClnt := func(mac byte, mods ...Modifier) *PPPoE {
+		clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 1, mac}, clntRelay, etherconn.WithEtherTypes(etypes))
+		mods = append(mods, WithPADIBackoff(Backoff{Timeout: 500 * time.Millisecond, Retry: 1}))
+		return NewPPPoE(clntConn, logger.Named("clnt"), mods...)
+	}
+	clnt := newClnt(1, WithServiceName("svcac2"))
+	if err := clnt.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if clnt.SelectedOffer().ACName != "ac2" {
+		t.Fatalf("expect ac2 selected, got %v", clnt.SelectedOffer())
+	}
+	clnt = newClnt(2, WithServiceName("svcac2"), WithRequiredACName("ac1"))
+	if err := clnt.Dial(ctx); !errors.Is(err, ErrPADOTimeout) {
+		t.Fatalf("expect PADO timeout, got %v", err)
+	}
+	// fake AC offers any service in PADO, but rejects PADR
+	fakeRelay, fakeACRelay := loopback.NewRelayPair("clnt", "ac")
+	defer fakeRelay.Stop()
+	defer fakeACRelay.Stop()
+	fakeConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, 3}, fakeACRelay,
+		etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+	defer fakeConn.Close()
+	go func() {
+		for {
+			buf, l2ep, err := fakeConn.ReadPkt()
+			if err != nil {
+				return
+			}
+			req := new(Pkt)
+			if req.Parse(buf) != nil {
+				continue
+			}
+			rsp := &Pkt{Code: CodePADO, Tags: []Tag{NewSvcTag("svc")}}
+			if req.Code == CodePADR {
+				rsp = &Pkt{Code: CodePADS, Tags: []Tag{&TagString{TagType: TagTypeServiceNameError, Value: "no such service"}}}
+			}
+			rsp.Tags = append(rsp.Tags, req.GetTag(TagTypeHostUniq)...)
+			rspbuf, _ := rsp.Serialize()
+			fakeConn.WritePktTo(rspbuf, EtherTypePPPoEDiscovery, l2ep.HwAddr)
+		}
+	}()
+	clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 1, 3}, fakeRelay, etherconn.WithEtherTypes(etypes))
+	clnt = NewPPPoE(clntConn, logger.Named("clnt"), WithServiceName("svc"),
+		WithPADIBackoff(Backoff{Retry: 1}), WithPADRBackoff(Backoff{Retry: 1}))
+	err := clnt.Dial(ctx)
+	var terr *TagError
+	if !errors.Is(err, ErrPADSRejected) || !errors.As(err, &terr) {
+		t.Fatalf("expect PADS rejected with tag error, got %v", err)
+	}
+	if terr.Type != TagTypeServiceNameError || terr.Msg != "no such service" {
+		t.Fatalf("unexpected tag error %v", terr)
+	}
+}
+
+func TestPADOTagError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, acRelay := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	defer acRelay.Stop()
+	etypes := []uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}
+	acConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, 1}, acRelay,
+		etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+	defer acConn.Close()
+	// fake AC answers every PADI with a PADO carrying AC-System-Error
+	go func() {
+		for {
+			buf, l2ep, err := acConn.ReadPkt()
+			if err != nil {
+				return
+			}
+			req := new(Pkt)
+			if req.Parse(buf) != nil || req.Code != CodePADI {
+				continue
+			}
+			pado := &Pkt{Code: CodePADO, Tags: []Tag{NewSvcTag(""), &TagString{TagType: TagTypeACSystemError, Value: "busy"}}}
+			pado.Tags = append(pado.Tags, req.GetTag(TagTypeHostUniq)...)
+			padobuf, _ := pado.Serialize()
+			acConn.WritePktTo(padobuf, EtherTypePPPoEDiscovery, l2ep.HwAddr)
+		}
+	}()
+	clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 1, 1}, clntRelay, etherconn.WithEtherTypes(etypes))
+	clnt := NewPPPoE(clntConn, logger.Named("clnt"), WithPADIBackoff(Backoff{Timeout: 200 * time.Millisecond, Retry: 2}))
+	err := clnt.Dial(ctx)
+	var terr *TagError
+	if !errors.Is(err, ErrPADOTimeout) || !errors.As(err, &terr) {
+		t.Fatalf("expect PADO timeout with tag error, got %v", err)
+	}
+	if terr.Type != TagTypeACSystemError || terr.Msg != "busy" {
+		t.Fatalf("unexpected tag error %v", terr)
+	}
+}
+
+func TestMaxPayload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, _ := zap.NewDevelopment()
+	clntRelay, acRelay := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	defer acRelay.Stop()
+	etypes := []uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}
+	acConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, 1}, acRelay,
+		etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+	ac, err := NewAC(acConn, logger.Named("ac"), WithACMaxPayload(1508))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ac.Serve(ctx)
+	for i, c := range []struct {
+		requested, expected uint16
+	}{
+		{0, DefaultMaxPayload},
+		{1500, 1500},
+		{9000, 1508},
+	} {
+		clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 1, byte(i)}, clntRelay, etherconn.WithEtherTypes(etypes))
+		clnt := NewPPPoE(clntConn, logger.Named("clnt"), WithMaxPayload(c.requested))
+		if err := clnt.Dial(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if clnt.MaxPayload() != c.expected {
+			t.Fatalf("requesting %d, expect max payload %d, got %d", c.requested, c.expected, clnt.MaxPayload())
+		}
+	}
+	// fake AC doesn't include PPP-Max-Payload in PADO, but answers it in PADS
+	fakeRelay, fakeACRelay := loopback.NewRelayPair("clnt", "ac")
+	defer fakeRelay.Stop()
+	defer fakeACRelay.Stop()
+	fakeConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 0, 2}, fakeACRelay,
+		etherconn.WithEtherTypes(etypes), etherconn.WithRecvMulticast(true))
+	defer fakeConn.Close()
+	padrMaxPayload := make(chan uint16, 1)
+	go func() {
+		for {
+			buf, l2ep, err := fakeConn.ReadPkt()
+			if err != nil {
+				return
+			}
+			req := new(Pkt)
+			if req.Parse(buf) != nil {
+				continue
+			}
+			rsp := &Pkt{Code: CodePADO, Tags: []Tag{NewSvcTag("")}}
+			if req.Code == CodePADR {
+				padrMaxPayload <- getMaxPayload(req)
+				rsp = &Pkt{Code: CodePADS, SessionID: 1, Tags: []Tag{NewSvcTag(""), NewMaxPayloadTag(1500)}}
+			}
+			rsp.Tags = append(rsp.Tags, req.GetTag(TagTypeHostUniq)...)
+			rspbuf, _ := rsp.Serialize()
+			fakeConn.WritePktTo(rspbuf, EtherTypePPPoEDiscovery, l2ep.HwAddr)
+		}
+	}()
+	clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 1, 9}, fakeRelay, etherconn.WithEtherTypes(etypes))
+	clnt := NewPPPoE(clntConn, logger.Named("clnt"), WithMaxPayload(1500))
+	if err := clnt.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-padrMaxPayload; n != 0 {
+		t.Fatalf("PADR includes PPP-Max-Payload %d while PADO doesn't", n)
+	}
+	if clnt.MaxPayload() != DefaultMaxPayload {
+		t.Fatalf("expect max payload %d without PPP-Max-Payload in PADO, got %d", DefaultMaxPayload, clnt.MaxPayload())
+	}
+}
+
+func TestAccessLineTag(t *testing.T) {
+	attrs := map[BBFSubTagNum]uint32{
+		BBFSubTagActualDataRateDownstream: 100000,
+		BBFSubTagActualDataRateUpstream:   20000,
+		BBFSubTagPONAccessType:            BBFPONTypeXGSPON,
+	}
+	buf, err := NewAccessLineTag("cid", "rid", attrs).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bbf := new(BBFTag)
+	n, err := bbf.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(buf) || len(*bbf) != 5 {
+		t.Fatalf("failed to parse BBF tag:\n%v", bbf)
+	}
+	for _, st := range (*bbf)[2:] {
+		num := st.(*BBFSubTagUint32)
+		if attrs[num.TagType] != num.Value {
+			t.Fatalf("expect %v %d, got %d", num.TagType, attrs[num.TagType], num.Value)
+		}
+	}
+	if (*bbf)[2].(*BBFSubTagUint32).TagType != BBFSubTagActualDataRateUpstream {
+		t.Fatalf("sub-tags are not ordered:\n%v", bbf)
+	}
+	var num BBFSubTagNum
+	if err := num.UnmarshalText([]byte("ontpeakdataratedownstream")); err != nil || num != BBFSubTagONTPeakDataRateDownstream {
+		t.Fatalf("failed to parse sub-tag name, %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Timeout: time.Second, Factor: 2, MaxTimeout: 5 * time.Second, Retry: 5}
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := b.timeout(i); d != expected {
+			t.Fatalf("timeout %d expect %v, got %v", i, expected, d)
+		}
+	}
+	if d := (Backoff{Timeout: time.Second, Factor: 1}).timeout(3); d != time.Second {
+		t.Fatalf("expect fixed timeout, got %v", d)
+	}
+	// no MaxTimeout, timeout doesn't overflow after many retransmissions
+	if d := (Backoff{Timeout: time.Second, Factor: 2}).timeout(40); d != time.Duration(math.MaxInt64) {
+		t.Fatalf("expect max timeout without overflow, got %v", d)
+	}
+	// no AC, PADI is retransmitted with backoff
+	logger, _ := zap.NewDevelopment()
+	clntRelay, _ := loopback.NewRelayPair("clnt", "ac")
+	defer clntRelay.Stop()
+	clntConn := etherconn.NewEtherConn(net.HardwareAddr{0x2, 0, 0, 0, 1, 1}, clntRelay,
+		etherconn.WithEtherTypes([]uint16{EtherTypePPPoEDiscovery, EtherTypePPPoESession}))
+	counters := new(Counters)
+	clnt := NewPPPoE(clntConn, logger.Named("clnt"), WithCounters(counters),
+		WithPADIBackoff(Backoff{Timeout: 50 * time.Millisecond, Factor: 2, MaxTimeout: 150 * time.Millisecond, Retry: 4}))
+	start := time.Now()
+	if err := clnt.Dial(context.Background()); !errors.Is(err, ErrPADOTimeout) {
+		t.Fatalf("expect PADO timeout, got %v", err)
+	}
+	// 50+100+150+150
+	if d := time.Since(start); d < 450*time.Millisecond || d > 2*time.Second {
+		t.Fatalf("expect PADI timeout after about 450ms, took %v", d)
+	}
+	if n := counters.PADISent.Load(); n != 4 {
+		t.Fatalf("expect 4 PADI sent, got %d", n)
+	}
+}
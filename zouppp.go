@@ -29,7 +29,6 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"runtime"
-	"time"
 
 	"github.com/hujun-open/etherconn"
 	"github.com/hujun-open/shouchan"
@@ -61,6 +60,14 @@ func main() {
 			log.Println(http.ListenAndServe("0.0.0.0:6060", nil))
 		}()
 	}
+	// serve Prometheus metrics
+	if setup.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", setup.Metrics().Handler())
+		go func() {
+			log.Println(http.ListenAndServe(setup.MetricsAddr, mux))
+		}()
+	}
 	// create a context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -98,29 +105,39 @@ func main() {
 	sessionwg := new(sync.WaitGroup)
 	// start dialing
 	var clntList []*client.ZouPPP
+	launcher := setup.NewLaunchScheduler()
 	for _, cfg := range cfglist {
+		// wait for launch rate and max outstanding dials
+		if err := launcher.Wait(ctx); err != nil {
+			setup.Logger().Sugar().Errorf("failed to launch session, %v", err)
+			return
+		}
 		econn := etherconn.NewEtherConn(cfg.Mac, relay,
 			etherconn.WithEtherTypes([]uint16{pppoe.EtherTypePPPoEDiscovery, pppoe.EtherTypePPPoESession}),
 			etherconn.WithVLANs(cfg.VLANs), etherconn.WithRecvMulticast(true))
-		z, err := client.NewZouPPP(econn, cfg, client.WithDialWG(dialwg), client.WithSessionWG(sessionwg))
+		z, err := client.NewZouPPP(econn, cfg, client.WithDialWG(dialwg), client.WithSessionWG(sessionwg),
+			client.WithLaunchScheduler(launcher))
 		if err != nil {
 			setup.Logger().Sugar().Errorf("failed to create zouppp,%v", err)
 			return
 		}
 		go z.Dial(ctx)
 		clntList = append(clntList, z)
-		// sleep for dialing interval
-		time.Sleep(setup.Interval)
 	}
 	// wait for all sessions dialing finish
 	dialwg.Wait()
 	setup.Logger().Sugar().Info("all sessions dialing finished")
-	// get the dailing result summary
-	summary := <-summaryCh
-	fmt.Println(summary)
-	setup.Close()
+	// with re-dial or churn, every dial attempt has a result, get the summary after all sessions stop dialing
+	keepDialing := setup.RedialMaxAttempts > 0 || setup.Churn
+	var summary *client.ResultSummary
+	if !keepDialing {
+		// get the dailing result summary
+		summary = <-summaryCh
+		printSummary(setup, summary)
+		setup.Close()
+	}
 	// handle ctrl+c
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -129,10 +146,28 @@ func main() {
 			z.Close()
 		}
 	}()
-	// wait for all opened sessions to close
-	if summary.Success > 0 {
+	// wait for all opened sessions to close, or all sessions stop re-dialing
+	if keepDialing || summary.Success > 0 {
 		sessionwg.Wait()
 	}
+	if keepDialing {
+		setup.Close()
+		printSummary(setup, <-summaryCh)
+	}
+	if setup.Churn {
+		fmt.Println(setup.ChurnStats())
+	}
+	setup.CloseCaptures()
 	fmt.Println("done")
 
 }
+
+// printSummary prints the dialing result summary, and writes it to the report file if specified
+func printSummary(setup *client.Setup, summary *client.ResultSummary) {
+	fmt.Println(summary)
+	if setup.Report != "" {
+		if err := client.WriteReport(setup.Report, summary); err != nil {
+			setup.Logger().Sugar().Errorf("failed to write report, %v", err)
+		}
+	}
+}